@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/app"
+	"github.com/harrybawsac/p1-go/src/buffer"
+	"github.com/harrybawsac/p1-go/src/config"
+)
+
+const bufferUsage = "usage: metercli buffer stats|list|show N|export|purge --older-than D|requeue"
+
+// runBuffer inspects and manages the offline buffer:
+// `metercli buffer stats|list|show|export|purge|requeue`. Only purge and
+// requeue change the buffer, and so import the legacy buffer file first;
+// they lock the buffer directory against a running collector.
+func runBuffer(ctx context.Context, cfg config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(bufferUsage)
+	}
+	open := configureBuffer
+	if args[0] == "purge" || args[0] == "requeue" {
+		open = openBuffer
+	}
+	buf, err := open(cfg)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "stats":
+		return bufferStats(buf)
+	case "list":
+		return bufferList(buf, args[1:])
+	case "show":
+		return bufferShow(buf, args[1:])
+	case "export":
+		return bufferExport(buf, args[1:])
+	case "purge":
+		return busyHint(bufferPurge(buf, args[1:]))
+	case "requeue":
+		n, err := buf.Requeue()
+		if err != nil {
+			return busyHint(err)
+		}
+		log.Printf("requeued %d dead-lettered entries\n", n)
+		return nil
+	default:
+		return fmt.Errorf("unknown buffer command %q; %s", args[0], bufferUsage)
+	}
+}
+
+func bufferStats(buf *buffer.Buffer) error {
+	st, err := buf.Stats()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("directory:     %s\n", buf.Dir())
+	fmt.Printf("entries:       %d\n", st.Entries)
+	formats := make([]string, 0, len(st.ByFormat))
+	for format := range st.ByFormat {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	for _, format := range formats {
+		name := format
+		if name == "" {
+			name = "legacy"
+		}
+		fmt.Printf("  %-12s %d\n", name+":", st.ByFormat[format])
+	}
+	fmt.Printf("corrupt:       %d\n", st.Corrupt)
+	fmt.Printf("dead-lettered: %d (%s)\n", st.DeadLettered, buf.DeadLetterPath)
+	fmt.Printf("size on disk:  %d bytes in %d segments\n", st.Bytes, st.Segments)
+	if !st.Oldest.IsZero() {
+		fmt.Printf("oldest:        %s\n", st.Oldest.Local().Format(time.RFC3339))
+		fmt.Printf("newest:        %s\n", st.Newest.Local().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// bufferList prints one line per entry with its parse status
+func bufferList(buf *buffer.Buffer, args []string) error {
+	fs := flag.NewFlagSet("buffer list", flag.ContinueOnError)
+	dead := fs.Bool("dead-letter", false, "list dead-lettered entries instead")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	fmt.Printf("%-5s %-25s %-14s %-8s %s\n", "N", "CAPTURED", "FORMAT", "ATTEMPTS", "STATUS")
+	return walkEntries(buf, *dead, func(n int, e buffer.Entry, err error) error {
+		if err != nil {
+			fmt.Printf("%-5d %-25s %-14s %-8s %v\n", n, "-", "-", "-", err)
+			return nil
+		}
+		captured := "-"
+		if !e.CapturedAt.IsZero() {
+			captured = e.CapturedAt.Local().Format(time.RFC3339)
+		}
+		format := e.Format
+		if format == "" {
+			format = "legacy"
+		}
+		fmt.Printf("%-5d %-25s %-14s %-8d %s\n", n, captured, format, e.Attempts, parseStatus(e))
+		return nil
+	})
+}
+
+// bufferShow prints entry N (as numbered by list) and its parsed reading
+func bufferShow(buf *buffer.Buffer, args []string) error {
+	fs := flag.NewFlagSet("buffer show", flag.ContinueOnError)
+	dead := fs.Bool("dead-letter", false, "show a dead-lettered entry")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: metercli buffer show N")
+	}
+	want, err := strconv.Atoi(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid entry number %q", fs.Arg(0))
+	}
+
+	found := false
+	err = walkEntries(buf, *dead, func(n int, e buffer.Entry, rerr error) error {
+		if n != want {
+			return nil
+		}
+		found = true
+		if rerr != nil {
+			fmt.Printf("record %d is unreadable: %v\n", n, rerr)
+			return nil
+		}
+		out, _ := json.MarshalIndent(e, "", "  ")
+		fmt.Println(string(out))
+		if r, err := app.ParseEntry(e); err != nil {
+			fmt.Printf("parse error: %v\n", err)
+		} else {
+			fmt.Printf("parsed reading: %+v\n", r)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no entry %d", want)
+	}
+	return nil
+}
+
+// bufferExport writes all entries as CSV or a JSON array for manual recovery
+func bufferExport(buf *buffer.Buffer, args []string) error {
+	fs := flag.NewFlagSet("buffer export", flag.ContinueOnError)
+	format := fs.String("format", "json", "output format: json or csv")
+	out := fs.String("out", "", "output file (default stdout)")
+	dead := fs.Bool("dead-letter", false, "export dead-lettered entries instead")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch *format {
+	case "json":
+		first := true
+		fmt.Fprint(w, "[")
+		err := walkEntries(buf, *dead, func(n int, e buffer.Entry, err error) error {
+			if err != nil {
+				return nil
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if !first {
+				fmt.Fprint(w, ",")
+			}
+			first = false
+			_, err = fmt.Fprintf(w, "\n  %s", data)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprint(w, "\n]\n")
+		return err
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"captured_at", "source", "format", "attempts", "last_error", "parse_status", "payload"})
+		err := walkEntries(buf, *dead, func(n int, e buffer.Entry, err error) error {
+			if err != nil {
+				return nil
+			}
+			captured := ""
+			if !e.CapturedAt.IsZero() {
+				captured = e.CapturedAt.UTC().Format(time.RFC3339)
+			}
+			return cw.Write([]string{captured, e.Source, e.Format, strconv.Itoa(e.Attempts), e.LastError, parseStatus(e), string(e.Payload)})
+		})
+		if err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unknown export format %q (want json or csv)", *format)
+	}
+}
+
+func bufferPurge(buf *buffer.Buffer, args []string) error {
+	fs := flag.NewFlagSet("buffer purge", flag.ContinueOnError)
+	olderThan := fs.Duration("older-than", 0, "remove entries captured longer ago than this, e.g. 720h")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *olderThan <= 0 {
+		return fmt.Errorf("usage: metercli buffer purge --older-than 720h")
+	}
+	n, err := buf.Purge(time.Now().Add(-*olderThan))
+	if err != nil {
+		return err
+	}
+	log.Printf("purged %d entries\n", n)
+	return nil
+}
+
+// busyHint explains buffer.ErrLocked: purge and requeue are safe while the
+// collector appends, but wait for a drain to finish
+func busyHint(err error) error {
+	if errors.Is(err, buffer.ErrLocked) {
+		return fmt.Errorf("%w; try again once the drain has finished", err)
+	}
+	return err
+}
+
+// walkEntries numbers the entries of the buffer, or of the dead-letter file,
+// from 1 in drain order
+func walkEntries(buf *buffer.Buffer, dead bool, fn func(n int, e buffer.Entry, err error) error) error {
+	n := 0
+	if dead {
+		return buf.WalkDeadLetters(func(e buffer.Entry) error {
+			n++
+			return fn(n, e, nil)
+		})
+	}
+	return buf.Walk(func(_ string, e buffer.Entry, err error) error {
+		n++
+		return fn(n, e, err)
+	})
+}
+
+func parseStatus(e buffer.Entry) string {
+	if _, err := app.ParseEntry(e); err != nil {
+		return "parse error: " + err.Error()
+	}
+	if e.LastError != "" {
+		return "ok (last error: " + e.LastError + ")"
+	}
+	return "ok"
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/harrybawsac/p1-go/src/services/migrate"
+)
+
+// Exit codes follow the LSB conventions systemd understands, so a unit can
+// restart on failures while RestartPreventExitStatus=2 6 stops it from
+// restarting into a configuration that cannot work.
+const (
+	exitOK = 0
+	// exitFailure is a runtime failure a restart may fix, e.g. an
+	// unreachable meter or database
+	exitFailure = 1
+	// exitUsage is an invalid flag or option value
+	exitUsage = 2
+	// exitNotConfigured is a missing or unreadable config file or a
+	// database schema with pending migrations
+	exitNotConfigured = 6
+)
+
+// exitError attaches an exit code to an error
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+
+func (e *exitError) Unwrap() error { return e.err }
+
+func usageErrorf(format string, args ...any) error {
+	return &exitError{code: exitUsage, err: fmt.Errorf(format, args...)}
+}
+
+func notConfigured(err error) error {
+	return &exitError{code: exitNotConfigured, err: err}
+}
+
+// exitCode maps the error main ends with to the process exit code
+func exitCode(err error) int {
+	var ee *exitError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &ee):
+		return ee.code
+	case errors.Is(err, migrate.ErrSchemaBehind):
+		return exitNotConfigured
+	default:
+		return exitFailure
+	}
+}
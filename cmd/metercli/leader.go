@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/config"
+	"github.com/harrybawsac/p1-go/src/scheduler"
+	"github.com/harrybawsac/p1-go/src/services/db"
+)
+
+// collectorLease is the p1.leases row collectors campaign for with
+// --leader-election
+const collectorLease = "collector"
+
+// runLeader reports on leader election: `metercli leader status`.
+func runLeader(ctx context.Context, cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("leader", flag.ContinueOnError)
+	name := fs.String("lease", collectorLease, "lease name")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || fs.Arg(0) != "status" {
+		return fmt.Errorf("usage: metercli leader status")
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	pg, ok := store.(*db.PostgresAdapter)
+	if !ok {
+		return fmt.Errorf("leader election requires the postgres driver; storage driver %q runs a single collector", cfg.Storage.Driver)
+	}
+
+	st, err := scheduler.ReadLease(ctx, pg.DB, *name)
+	if errors.Is(err, scheduler.ErrNoLease) {
+		fmt.Printf("lease %q has no holder\n", *name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	state := "active"
+	if st.Expired {
+		state = "expired; the next standby to renew takes over"
+	}
+	fmt.Printf("lease:     %s\n", st.Name)
+	fmt.Printf("holder:    %s\n", st.Holder)
+	fmt.Printf("state:     %s\n", state)
+	fmt.Printf("acquired:  %s\n", st.AcquiredAt.Local().Format(time.RFC3339))
+	fmt.Printf("renewed:   %s\n", st.RenewedAt.Local().Format(time.RFC3339))
+	fmt.Printf("expires:   %s\n", st.ExpiresAt.Local().Format(time.RFC3339))
+	return nil
+}
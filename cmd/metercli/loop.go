@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/app"
+	"github.com/harrybawsac/p1-go/src/buffer"
+	"github.com/harrybawsac/p1-go/src/config"
+	"github.com/harrybawsac/p1-go/src/scheduler"
+	"github.com/harrybawsac/p1-go/src/services/db"
+	"github.com/harrybawsac/p1-go/src/services/systemd"
+)
+
+// Advisory lock keys of the loop-mode jobs
+const (
+	pollLockKey      = 42
+	drainLockKey     = 43
+	retentionLockKey = 44
+)
+
+// loopOptions carries the loop-mode flags
+type loopOptions struct {
+	interval       time.Duration
+	align          bool
+	schedule       string
+	jitter         time.Duration
+	missed         string
+	timeout        time.Duration
+	backoffMax     time.Duration
+	drainRate      float64
+	drainInterval  time.Duration
+	leaderElection bool
+	leaseTTL       time.Duration
+	holderID       string
+	runsRetention  time.Duration
+	shutdownGrace  time.Duration
+	// notify reports readiness and poll results to systemd; nil outside it
+	notify *systemd.Notifier
+}
+
+// runLoop runs the collector jobs until ctx is done: "poll" reads the meter
+// on the configured schedule and drains the buffer in the background once
+// the database is back, and "drain-buffer" retries buffered entries every
+// drainInterval. With PostgreSQL every run is recorded in p1.job_runs and
+// "job-runs-retention" prunes that table nightly.
+func runLoop(ctx context.Context, cfg config.Config, store db.Store, buf *buffer.Buffer, runOnce scheduler.Runner, o loopOptions) error {
+	drain := func(ctx context.Context) error {
+		summary, err := app.DrainBufferLimited(ctx, store, buf, o.drainRate)
+		if summary != (buffer.DrainSummary{}) {
+			log.Printf("background drain: %s\n", summary)
+		}
+		return err
+	}
+
+	poll := scheduler.Job{
+		Name:     "poll",
+		Run:      runOnce,
+		Interval: o.interval,
+		Jitter:   o.jitter,
+		Timeout:  o.timeout,
+		LockKey:  pollLockKey,
+		FailFast: true,
+	}
+	if o.backoffMax > 0 {
+		poll.Backoff = scheduler.Backoff{Initial: o.interval, Max: o.backoffMax}
+	}
+	switch p := scheduler.MissedPolicy(o.missed); p {
+	case scheduler.MissedSkip, scheduler.MissedCatchUp:
+		poll.Missed = p
+	default:
+		return usageErrorf("invalid --missed %q (want skip or catch-up)", o.missed)
+	}
+	switch {
+	case o.schedule != "":
+		sched, err := scheduler.ParseSchedule(o.schedule)
+		if err != nil {
+			return usageErrorf("%v", err)
+		}
+		poll.Schedule = sched
+	case o.align:
+		if o.interval <= 0 || (24*time.Hour)%o.interval != 0 {
+			return usageErrorf("--align needs an --interval that divides a day")
+		}
+		poll.Schedule = scheduler.Aligned(o.interval)
+	}
+	drainJob := scheduler.Job{Name: "drain-buffer", Run: drain, Interval: o.drainInterval, LockKey: drainLockKey}
+
+	g := &scheduler.Group{Instance: o.holderID, ShutdownGrace: o.shutdownGrace}
+	var drainLocker scheduler.Locker
+	var history *scheduler.DBHistory
+	switch st := store.(type) {
+	case *db.PostgresAdapter:
+		g.DB = st.DB
+		history = &scheduler.DBHistory{DB: st.DB}
+		g.History = history
+		if o.leaderElection {
+			elector := &scheduler.Elector{DB: st.DB, Name: collectorLease, HolderID: o.holderID, TTL: o.leaseTTL}
+			if err := elector.Campaign(ctx); err != nil {
+				log.Printf("leader election: %v\n", err)
+			}
+			if !elector.IsLeader() {
+				log.Println("leader election: standing by")
+			}
+			// keep leading until the jobs have stopped, then hand over
+			electCtx, stopElection := context.WithCancel(context.WithoutCancel(ctx))
+			elected := make(chan struct{})
+			go func() {
+				defer close(elected)
+				elector.Run(electCtx)
+			}()
+			defer func() {
+				stopElection()
+				<-elected
+			}()
+			poll.Locker = elector
+		}
+	case *db.SQLiteAdapter:
+		if o.leaderElection {
+			return usageErrorf("leader election requires the postgres driver")
+		}
+		poll.Locker = &scheduler.FileLock{Path: st.LockPath()}
+		drainJob.Locker = bufferDrainLock(st)
+		drainLocker = bufferDrainLock(st)
+	default:
+		return usageErrorf("loop mode is not supported with storage driver %q", cfg.Storage.Driver)
+	}
+
+	s, err := g.Add(poll)
+	if err != nil {
+		return err
+	}
+	s.Drain, s.DrainLockKey, s.DrainLocker = drain, drainLockKey, drainLocker
+	if o.notify != nil {
+		s.OnRun = notifyPolls(o.notify)
+		if wd := systemd.WatchdogInterval(); wd > 0 && poll.Schedule == nil && o.interval >= wd {
+			log.Printf("systemd watchdog %s is shorter than the poll interval %s; the service will be restarted between polls\n", wd, o.interval)
+		}
+	}
+	if o.drainInterval > 0 {
+		if _, err := g.Add(drainJob); err != nil {
+			return err
+		}
+	}
+	if history != nil && o.runsRetention > 0 {
+		_, err := g.Add(scheduler.Job{
+			Name:     "job-runs-retention",
+			Schedule: scheduler.Aligned(24 * time.Hour),
+			LockKey:  retentionLockKey,
+			Run: func(ctx context.Context) error {
+				n, err := history.Prune(ctx, time.Now().Add(-o.runsRetention))
+				scheduler.AddRows(ctx, n)
+				return err
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return g.Run(ctx)
+}
+
+// bufferDrainLock returns the lock every drain of the buffer takes, in loop
+// mode and with --drain-buffer, or nil for stores without one
+func bufferDrainLock(store db.Store) scheduler.Locker {
+	switch st := store.(type) {
+	case *db.PostgresAdapter:
+		return &scheduler.AdvisoryLock{DB: st.DB, Key: drainLockKey}
+	case *db.SQLiteAdapter:
+		return &scheduler.FileLock{Path: st.LockPath() + ".drain"}
+	}
+	return nil
+}
@@ -2,13 +2,14 @@ package main
 
 import (
 	"context"
-	"database/sql"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"sort"
+	"syscall"
 	"time"
 
 	"github.com/harrybawsac/p1-go/src/app"
@@ -18,90 +19,387 @@ import (
 	"github.com/harrybawsac/p1-go/src/scheduler"
 	"github.com/harrybawsac/p1-go/src/services/csvloader"
 	"github.com/harrybawsac/p1-go/src/services/db"
+	"github.com/harrybawsac/p1-go/src/services/meter"
 	"github.com/harrybawsac/p1-go/src/services/parser"
+	"github.com/harrybawsac/p1-go/src/services/systemd"
 	_ "github.com/lib/pq"
 )
 
+// options carries the command-line flags
+type options struct {
+	args            []string
+	loop            bool
+	drain           bool
+	dryRun          bool
+	importCSV       bool
+	onConflict      string
+	onInvalid       string
+	shutdownTimeout time.Duration
+	loopOpts        loopOptions
+}
+
 func main() {
-	ctx := context.Background()
 	cfgPath := flag.String("config", "./config.json", "path to JSON config file")
 	loop := flag.Bool("loop", false, "run in loop mode (use scheduler)")
 	interval := flag.Int("interval", 60, "interval in seconds when running in loop mode")
+	align := flag.Bool("align", false, "in loop mode, run on wall-clock multiples of --interval counted from midnight (e.g. :00, :15, :30, :45 for 900)")
+	schedule := flag.String("schedule", "", "in loop mode, cron expression (5 or 6 fields, optional TZ= prefix), @hourly-style descriptor or \"@every 15m\"; overrides --interval")
+	jitter := flag.Duration("jitter", 0, "in loop mode, delay every run by a random duration below this")
+	missed := flag.String("missed", "skip", "in loop mode, what to do with runs missed during a long run or suspend: skip or catch-up")
+	runTimeout := flag.Duration("run-timeout", 0, "in loop mode, cancel a poll that takes longer than this (0 = no limit)")
+	backoffMax := flag.Duration("backoff-max", 0, "in loop mode, back off exponentially from --interval up to this after consecutive failed polls (0 = keep the schedule)")
+	runsRetention := flag.Duration("runs-retention", 30*24*time.Hour, "in loop mode, delete p1.job_runs rows older than this every night (0 = keep forever)")
+	drainInterval := flag.Duration("drain-interval", 5*time.Minute, "in loop mode, also drain the buffer on this interval (0 = only after the database recovers)")
 	drain := flag.Bool("drain-buffer", false, "drain local buffer and attempt to persist entries")
 	dryRun := flag.Bool("dry-run", false, "fetch and log data without inserting into database")
 	importCSV := flag.Bool("import", false, "import CSV files from data directory")
+	drainRate := flag.Float64("drain-rate", 20, "maximum buffered entries per second replayed by the background drain in loop mode (0 = unlimited)")
+	onConflict := flag.String("on-conflict", "skip", "what to do with readings already stored for the same meter and timestamp: skip, overwrite or fail")
+	onInvalid := flag.String("on-invalid", "warn", "what to do with a meter payload that fails validation (missing, mistyped or out-of-range fields): warn, reject or review")
+	leaderElection := flag.Bool("leader-election", false, "in loop mode, elect one leader among collectors sharing the database via a lease in p1.leases")
+	leaseTTL := flag.Duration("lease-ttl", scheduler.DefaultLeaseTTL, "leader lease lifetime; a standby takes over within this time after the leader dies")
+	holderID := flag.String("holder-id", "", "identity of this collector in the lease (default hostname:pid)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "on SIGINT or SIGTERM, let work in progress finish for up to this long")
 	flag.Parse()
 
 	log.Println("metercli starting")
+	notify := systemd.NewNotifier()
+
+	// SIGINT and SIGTERM cancel ctx: no new work starts and work in progress
+	// gets --shutdown-timeout to finish. A second signal kills the process.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+		notify.Stopping()
+		log.Printf("shutting down, waiting up to %s for work in progress\n", *shutdownTimeout)
+		// last resort for work that ignores cancellation
+		time.AfterFunc(*shutdownTimeout+5*time.Second, func() {
+			log.Println("shutdown timed out")
+			os.Exit(exitFailure)
+		})
+	}()
 
-	cfg, err := config.Load(*cfgPath)
+	o := options{
+		args:            flag.Args(),
+		loop:            *loop,
+		drain:           *drain,
+		dryRun:          *dryRun,
+		importCSV:       *importCSV,
+		onConflict:      *onConflict,
+		onInvalid:       *onInvalid,
+		shutdownTimeout: *shutdownTimeout,
+		loopOpts: loopOptions{
+			interval:       time.Duration(*interval) * time.Second,
+			align:          *align,
+			schedule:       *schedule,
+			jitter:         *jitter,
+			missed:         *missed,
+			timeout:        *runTimeout,
+			backoffMax:     *backoffMax,
+			drainRate:      *drainRate,
+			drainInterval:  *drainInterval,
+			leaderElection: *leaderElection,
+			leaseTTL:       *leaseTTL,
+			holderID:       *holderID,
+			runsRetention:  *runsRetention,
+			shutdownGrace:  *shutdownTimeout,
+			notify:         notify,
+		},
+	}
+	err := run(ctx, *cfgPath, o)
 	if err != nil {
-		log.Fatalf("load config: %v", err)
+		log.Printf("%v", err)
 	}
+	os.Exit(exitCode(err))
+}
 
-	// expose config via env for other packages that might expect env vars
+// run loads the config and runs a subcommand, a single collection, an
+// import or drain, or loop mode
+func run(ctx context.Context, cfgPath string, o options) error {
+	cfg, err := loadConfig(cfgPath)
+	if err != nil {
+		return err
+	}
+	if len(o.args) > 0 {
+		if err := runCommand(ctx, cfgPath, cfg, o.args); err != nil {
+			return fmt.Errorf("%s failed: %w", o.args[0], err)
+		}
+		return nil
+	}
+	if o.loop {
+		return serve(ctx, cfgPath, cfg, o)
+	}
+	return collect(ctx, cfg, o)
+}
+
+// loadConfig reads the config file and exposes it via env for packages
+// that expect env vars
+func loadConfig(path string) (config.Config, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return cfg, notConfigured(fmt.Errorf("load config: %w", err))
+	}
 	if cfg.MeterEndpoint != "" {
 		os.Setenv("METER_ENDPOINT", cfg.MeterEndpoint)
 	}
 	if cfg.DBDSN != "" {
 		os.Setenv("DB_DSN", cfg.DBDSN)
 	}
+	return cfg, nil
+}
 
-	dsn := os.Getenv("DB_DSN")
-	if dsn == "" {
-		log.Fatalf("DB_DSN not set; provide in config or environment")
+// serve runs loop mode until ctx is done. SIGHUP reloads the config file
+// and restarts the jobs with it once the runs in progress have finished; a
+// config that fails to load is reported and the running one is kept. Under
+// systemd, readiness is reported again after the first poll with the new
+// config.
+func serve(ctx context.Context, cfgPath string, cfg config.Config, o options) error {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func() { done <- collect(runCtx, cfg, o) }()
+
+		reload := false
+		for !reload {
+			select {
+			case err := <-done:
+				cancel()
+				if ctx.Err() != nil && errors.Is(err, context.Canceled) {
+					log.Println("shutdown complete")
+					return nil
+				}
+				return err
+			case <-hup:
+				next, err := loadConfig(cfgPath)
+				if err == nil {
+					_, err = configureBuffer(next)
+				}
+				if err != nil {
+					log.Printf("reload %s: %v; keeping the running configuration\n", cfgPath, err)
+					continue
+				}
+				log.Printf("reloading %s\n", cfgPath)
+				o.loopOpts.notify.Reloading()
+				cfg, reload = next, true
+			}
+		}
+		cancel()
+		if err := <-done; err != nil && !errors.Is(err, context.Canceled) {
+			return err
+		}
+	}
+}
+
+// collect opens the store and buffer and performs the selected work. Pending
+// buffered writes are flushed to disk before it returns.
+func collect(ctx context.Context, cfg config.Config, o options) error {
+	policy, err := db.ParseConflictPolicy(o.onConflict)
+	if err != nil {
+		return usageErrorf("%v", err)
+	}
+
+	invalidPolicy, err := parser.ParseInvalidPolicy(o.onInvalid)
+	if err != nil {
+		return usageErrorf("%v", err)
+	}
+	if invalidPolicy != parser.InvalidWarn && (cfg.SerialDevice != "" || cfg.MeterAPIVersion == 2) {
+		return usageErrorf("--on-invalid %s only applies to API v1 payloads; telegrams and API v2 measurements are not validated", invalidPolicy)
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	store.SetConflictPolicy(policy)
+
+	if !o.dryRun {
+		if err := checkSchema(ctx, store); err != nil {
+			return err
+		}
 	}
-	dbConn, err := sql.Open("postgres", dsn)
+
+	buf, err := openBuffer(cfg)
 	if err != nil {
-		log.Fatalf("open db: %v", err)
+		return err
 	}
-	defer dbConn.Close()
+	defer func() {
+		if err := buf.Sync(); err != nil {
+			log.Printf("flush buffer: %v\n", err)
+		}
+	}()
 
-	adapter := &db.PostgresAdapter{DB: dbConn}
-	buf := buffer.New("/tmp/p1-buffer.jsonl")
+	// one-shot work may finish after a signal within the shutdown timeout
+	work, cancel := scheduler.WithGrace(ctx, o.shutdownTimeout)
+	defer cancel()
 
-	if *importCSV {
-		if err := importCSVData(ctx, cfg, adapter, *dryRun); err != nil {
-			log.Fatalf("import CSV failed: %v", err)
+	if o.importCSV {
+		if err := importCSVData(work, cfg, store, o.dryRun); err != nil {
+			return fmt.Errorf("import CSV failed: %w", err)
 		}
 		log.Println("import completed")
-		return
+		return nil
 	}
 
-	if *drain {
-		if err := buf.Drain(ctx, func(ctx context.Context, raw json.RawMessage) error {
-			// attempt to parse and insert
-			r, err := parser.ParseFullReading([]byte(raw))
+	if o.drain {
+		if lock := bufferDrainLock(store); lock != nil {
+			got, err := lock.TryLock(work)
 			if err != nil {
-				return err
+				return fmt.Errorf("drain lock: %w", err)
+			}
+			if !got {
+				log.Println("drain skipped: another instance is draining the buffer")
+				return nil
 			}
-			return adapter.InsertReading(ctx, r)
-		}); err != nil {
-			log.Fatalf("drain buffer failed: %v", err)
+			defer lock.Unlock(context.Background())
 		}
-		log.Println("drain completed")
-		return
+		summary, err := app.DrainBuffer(work, store, buf)
+		if err != nil {
+			return fmt.Errorf("drain buffer failed after %s: %w", summary, err)
+		}
+		log.Printf("drain completed: %s\n", summary)
+		return nil
 	}
 
-	if *loop {
-		s := &scheduler.Scheduler{
-			DB:       dbConn,
-			LockKey:  42,
-			Interval: time.Duration(*interval) * time.Second,
+	runOnce := func(ctx context.Context) error { return app.RunOnceValidated(ctx, store, buf, invalidPolicy, o.dryRun) }
+	switch {
+	case cfg.SerialDevice != "":
+		runOnce = func(ctx context.Context) error {
+			return app.RunSerialOnce(ctx, store, buf, cfg.SerialDevice, cfg.SerialBaud, o.dryRun)
+		}
+	case cfg.MeterAPIVersion == 2:
+		client := meter.NewV2Client(cfg.MeterEndpoint, cfg.MeterToken, cfg.MeterCertSHA256, 10*time.Second)
+		defer client.CloseIdleConnections()
+		runOnce = func(ctx context.Context) error {
+			return app.RunV2OnceWithDeps(ctx, store, buf, client, o.dryRun)
 		}
-		if err := s.Run(ctx, func(ctx context.Context) error { return app.RunOnceWithDeps(ctx, adapter, buf, *dryRun) }); err != nil {
-			log.Fatalf("scheduler failed: %v", err)
+	}
+
+	if o.loop {
+		if err := runLoop(ctx, cfg, store, buf, runOnce, o.loopOpts); err != nil {
+			return fmt.Errorf("scheduler failed: %w", err)
 		}
 	} else {
-		if err := app.RunOnceWithDeps(ctx, adapter, buf, *dryRun); err != nil {
-			log.Fatalf("run failed: %v", err)
+		if err := runOnce(work); err != nil {
+			return fmt.Errorf("run failed: %w", err)
 		}
 	}
 	log.Println("run completed")
+	return nil
+}
+
+// runCommand dispatches subcommands given after the global flags,
+// e.g. `metercli --config ./config.json pair`.
+func runCommand(ctx context.Context, cfgPath string, cfg config.Config, args []string) error {
+	switch args[0] {
+	case "pair":
+		return runPair(ctx, cfgPath, cfg, args[1:])
+	case "stream":
+		return runStream(ctx, cfg, args[1:])
+	case "buffer":
+		return runBuffer(ctx, cfg, args[1:])
+	case "migrate":
+		return runMigrate(ctx, cfg, args[1:])
+	case "leader":
+		return runLeader(ctx, cfg, args[1:])
+	case "runs":
+		return runRuns(ctx, cfg, args[1:])
+	case "report":
+		return runReport(ctx, cfg, args[1:])
+	case "peaks":
+		return runPeaks(ctx, cfg, args[1:])
+	case "systemd-unit":
+		return runSystemdUnit(cfgPath, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// openStore opens the storage backend selected by storage.driver. The
+// postgres driver falls back to DB_DSN when storage.dsn is not set.
+func openStore(cfg config.Config) (db.Store, error) {
+	driver := cfg.Storage.Driver
+	if driver == "" {
+		driver = "postgres"
+	}
+	dsn := cfg.Storage.DSN
+	if dsn == "" && driver == "postgres" {
+		dsn = os.Getenv("DB_DSN")
+		if dsn == "" {
+			return nil, fmt.Errorf("DB_DSN not set; provide in config or environment")
+		}
+	}
+	store, err := db.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open %s store: %w", driver, err)
+	}
+	return store, nil
+}
+
+// legacyBufferPath is the single-file buffer used before the segmented WAL
+const legacyBufferPath = "/tmp/p1-buffer.jsonl"
+
+// openBuffer configures the write-ahead log from the buffer config section
+// and moves entries left in the legacy buffer file into it.
+func openBuffer(cfg config.Config) (*buffer.Buffer, error) {
+	buf, err := configureBuffer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	n, err := buf.ImportFile(legacyBufferPath)
+	if err != nil {
+		return nil, fmt.Errorf("import legacy buffer %s: %w", legacyBufferPath, err)
+	}
+	if n > 0 {
+		log.Printf("moved %d entries from %s into %s\n", n, legacyBufferPath, buf.Dir())
+	}
+	return buf, nil
+}
+
+// configureBuffer applies the buffer config section without touching disk
+func configureBuffer(cfg config.Config) (*buffer.Buffer, error) {
+	bc := cfg.Buffer
+	dir := bc.Dir
+	if dir == "" {
+		dir = "./buffer"
+	}
+	buf := buffer.New(dir)
+	if bc.SegmentSizeBytes > 0 {
+		buf.SegmentSize = bc.SegmentSizeBytes
+	}
+	if bc.MaxSizeBytes > 0 {
+		buf.MaxSize = bc.MaxSizeBytes
+	}
+	if bc.FsyncIntervalMs > 0 {
+		buf.FsyncInterval = time.Duration(bc.FsyncIntervalMs) * time.Millisecond
+	}
+	if bc.MaxAttempts > 0 {
+		buf.MaxAttempts = bc.MaxAttempts
+	}
+	switch p := buffer.FsyncPolicy(bc.Fsync); p {
+	case "":
+	case buffer.FsyncAlways, buffer.FsyncInterval, buffer.FsyncNever:
+		buf.Fsync = p
+	default:
+		return nil, usageErrorf("invalid buffer.fsync %q (want always, interval or never)", bc.Fsync)
+	}
+	switch p := buffer.OverflowPolicy(bc.Overflow); p {
+	case "":
+	case buffer.OverflowDropOldest, buffer.OverflowRejectNew:
+		buf.Overflow = p
+	default:
+		return nil, usageErrorf("invalid buffer.overflow %q (want drop_oldest or reject_new)", bc.Overflow)
+	}
+	return buf, nil
 }
 
 // importCSVData loads CSV files and imports them into the database day-by-day
-func importCSVData(ctx context.Context, cfg config.Config, adapter *db.PostgresAdapter, dryRun bool) error {
+func importCSVData(ctx context.Context, cfg config.Config, store db.Store, dryRun bool) error {
 	if cfg.DataDir == "" {
 		return fmt.Errorf("data_dir not configured")
 	}
@@ -114,6 +412,19 @@ func importCSVData(ctx context.Context, cfg config.Config, adapter *db.PostgresA
 		return fmt.Errorf("load and merge CSV: %w", err)
 	}
 	log.Printf("Loaded %d records\n", len(merged))
+	if cfg.ImportMeterID == "" {
+		log.Println("import_meter_id is not set: imported readings get an empty meter_id and do not conflict with readings stored by the collector")
+	}
+	// the CSV export has no meter id; stamp the configured one so the
+	// (meter_id, created_at) key catches overlaps with collected readings
+	toReadings := func(rows []csvloader.MergedReading) []models.Reading {
+		readings := make([]models.Reading, len(rows))
+		for j, mr := range rows {
+			readings[j] = mr.ToReading()
+			readings[j].MeterID = cfg.ImportMeterID
+		}
+		return readings
+	}
 
 	// Group by day
 	dayMap := csvloader.GroupByDay(merged)
@@ -141,15 +452,17 @@ func importCSVData(ctx context.Context, cfg config.Config, adapter *db.PostgresA
 
 			log.Printf("\n--- Day %d: %s (%d readings) ---\n", i+1, day, len(dayReadings))
 
-			// Convert to models.Reading
-			readings := make([]models.Reading, len(dayReadings))
-			for j, mr := range dayReadings {
-				readings[j] = mr.ToReading()
-			}
+			readings := toReadings(dayReadings)
 
 			// Generate SQL (single statement for all readings of the day)
-			sqlStatement := adapter.GenerateInsertSQL(readings)
-			fmt.Println(sqlStatement)
+			gen, ok := store.(interface {
+				GenerateInsertSQL([]models.Reading) string
+			})
+			if !ok {
+				log.Printf("storage driver %s cannot render SQL; would insert %d readings\n", cfg.Storage.Driver, len(readings))
+				continue
+			}
+			fmt.Println(gen.GenerateInsertSQL(readings))
 		}
 		return nil
 	}
@@ -159,14 +472,10 @@ func importCSVData(ctx context.Context, cfg config.Config, adapter *db.PostgresA
 		dayReadings := dayMap[day]
 		log.Printf("Processing day %d/%d: %s (%d readings)\n", i+1, len(days), day, len(dayReadings))
 
-		// Convert to models.Reading
-		readings := make([]models.Reading, len(dayReadings))
-		for j, mr := range dayReadings {
-			readings[j] = mr.ToReading()
-		}
+		readings := toReadings(dayReadings)
 
 		// Insert batch for this day
-		if err := adapter.InsertReadingsBatch(ctx, readings); err != nil {
+		if err := store.InsertReadingsBatch(ctx, readings); err != nil {
 			return fmt.Errorf("insert day %s: %w", day, err)
 		}
 		log.Printf("Inserted %d readings for %s\n", len(readings), day)
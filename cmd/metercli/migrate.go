@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/harrybawsac/p1-go/src/config"
+	"github.com/harrybawsac/p1-go/src/services/db"
+	"github.com/harrybawsac/p1-go/src/services/migrate"
+)
+
+// runMigrate applies or reverts the embedded PostgreSQL migrations:
+// `metercli migrate up|down|status|to N`.
+func runMigrate(ctx context.Context, cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: metercli migrate up|down|status|to N")
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	pg, ok := store.(*db.PostgresAdapter)
+	if !ok {
+		return fmt.Errorf("migrations apply to the postgres driver only; storage driver %q manages its own schema", cfg.Storage.Driver)
+	}
+	m, err := migrate.New(pg.DB)
+	if err != nil {
+		return err
+	}
+
+	switch fs.Arg(0) {
+	case "up":
+		err = m.Up(ctx)
+	case "down":
+		err = m.Down(ctx)
+	case "to":
+		if fs.NArg() != 2 {
+			return fmt.Errorf("usage: metercli migrate to N")
+		}
+		target, perr := strconv.Atoi(fs.Arg(1))
+		if perr != nil {
+			return fmt.Errorf("invalid version %q", fs.Arg(1))
+		}
+		err = m.To(ctx, target)
+	case "status":
+		return printMigrationStatus(ctx, m)
+	default:
+		return fmt.Errorf("unknown migrate command %q (want up, down, status or to N)", fs.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+	current, err := m.Current(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("schema at version %03d (latest %03d)\n", current, m.Latest())
+	return nil
+}
+
+func printMigrationStatus(ctx context.Context, m *migrate.Migrator) error {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, s := range status {
+		state := "pending"
+		if s.Applied {
+			state = "applied " + s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%03d  %-24s %s\n", s.Version, s.Name, state)
+	}
+	return nil
+}
+
+// checkSchema refuses to start the collector against a PostgreSQL schema
+// with pending migrations. Other drivers create their schema on open. When
+// the database cannot be reached the check is skipped so readings are still
+// buffered.
+func checkSchema(ctx context.Context, store db.Store) error {
+	pg, ok := store.(*db.PostgresAdapter)
+	if !ok {
+		return nil
+	}
+	m, err := migrate.New(pg.DB)
+	if err != nil {
+		return err
+	}
+	if err := m.Check(ctx); err != nil {
+		if errors.Is(err, migrate.ErrSchemaBehind) {
+			return err
+		}
+		log.Printf("could not verify schema version: %v\n", err)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/config"
+	"github.com/harrybawsac/p1-go/src/services/meter"
+)
+
+// runPair performs the HomeWizard API v2 pairing flow and stores the token and
+// pinned certificate fingerprint in the config file.
+func runPair(ctx context.Context, cfgPath string, cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("pair", flag.ContinueOnError)
+	name := fs.String("name", "local/metercli", "user name registered on the meter")
+	timeout := fs.Duration("timeout", 60*time.Second, "how long to wait for the meter button press")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if cfg.MeterEndpoint == "" {
+		return fmt.Errorf("meter_endpoint not configured; set it to the meter base URL, e.g. https://192.168.101.20")
+	}
+
+	client := meter.NewV2Client(cfg.MeterEndpoint, "", cfg.MeterCertSHA256, 10*time.Second)
+
+	pairCtx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	log.Printf("Press the button on the meter within %s to pair...\n", *timeout)
+	if err := client.Pair(pairCtx, *name, 2*time.Second); err != nil {
+		return err
+	}
+
+	cfg.MeterAPIVersion = 2
+	cfg.MeterToken = client.Token
+	cfg.MeterCertSHA256 = client.CertSHA256
+	if err := config.Save(cfgPath, cfg); err != nil {
+		return fmt.Errorf("save config: %w", err)
+	}
+	log.Printf("Paired; token and certificate fingerprint %s stored in %s\n", client.CertSHA256, cfgPath)
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/config"
+	"github.com/harrybawsac/p1-go/src/models"
+	"github.com/harrybawsac/p1-go/src/services/db"
+	"github.com/harrybawsac/p1-go/src/services/report"
+)
+
+// runPeaks prints the monthly peak demand and the capacity tariff it costs:
+// `metercli peaks [--months N] [--rate R] [--minimum-kw KW]`. The current
+// month is projected from its peak so far and the months before it (see
+// report.CapacityCost).
+func runPeaks(ctx context.Context, cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("peaks", flag.ContinueOnError)
+	months := fs.Int("months", 12, "number of months to show, including the current one")
+	rate := fs.Float64("rate", cfg.CapacityTariff.RatePerKWYear, "capacity tariff per kW per year (default: capacity_tariff.rate_per_kw_year)")
+	minimumKW := fs.Float64("minimum-kw", cfg.CapacityTariff.MinimumKW, "lowest billed monthly peak in kW (default: capacity_tariff.minimum_kw or 2.5)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *months < 1 {
+		return usageErrorf("--months must be at least 1")
+	}
+	if *minimumKW == 0 {
+		*minimumKW = report.DefaultMinimumKW
+	}
+
+	now := time.Now()
+	current := report.Month.Start(now)
+	from := report.Month.Add(current, 1-*months)
+	// the tariff averages the peaks of the last 12 months, so load the
+	// months before the first one shown as well
+	loadFrom := report.Month.Add(from, -11)
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	demand, reported, err := loadPeaks(ctx, store, loadFrom, now)
+	if err != nil {
+		return err
+	}
+	var costs []report.CapacityCost
+	for _, c := range report.CapacityCosts(report.MonthlyPeaks(demand, reported, time.Local), *rate, *minimumKW, now) {
+		if !c.Month.Before(from) {
+			costs = append(costs, c)
+		}
+	}
+	if len(costs) == 0 {
+		fmt.Printf("no import readings since %s\n", from.Format("2006-01-02"))
+		return nil
+	}
+
+	fmt.Printf("%-20s %-8s %8s %-16s %9s %9s %-20s", "METER", "MONTH", "PEAK kW", "AT", "BILLED kW", "12M kW", "SOURCE")
+	if *rate > 0 {
+		fmt.Printf(" %10s", "COST")
+	}
+	fmt.Println()
+	for _, c := range costs {
+		w, at := c.Peak()
+		source := "computed"
+		if c.MeterPeakW > 0 {
+			source = "meter"
+		}
+		when := "-"
+		if !at.IsZero() {
+			when = at.Local().Format("2006-01-02 15:04")
+		}
+		month := c.Month.Format("2006-01")
+		if c.InProgress {
+			source += ", projected"
+		}
+		fmt.Printf("%-20s %-8s %8.3f %-16s %9.3f %9.3f %-20s", c.MeterID, month, w/1000, when, c.ProjectedKW, c.RollingKW, source)
+		if *rate > 0 {
+			fmt.Printf(" %10.2f", c.Cost)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// loadPeaks loads the quarter-hour demand and the meter-reported peaks in
+// [from, to), aggregated by the database when the store can, and computed
+// from the readings otherwise
+func loadPeaks(ctx context.Context, store db.Store, from, to time.Time) ([]models.Demand, []models.PowerPeak, error) {
+	if ds, ok := store.(db.DemandStore); ok {
+		demand, err := ds.QuarterHourDemand(ctx, from, to)
+		if err != nil {
+			return nil, nil, err
+		}
+		reported, err := ds.PowerPeaks(ctx, from, to)
+		if err != nil {
+			return nil, nil, err
+		}
+		return demand, reported, nil
+	}
+	readings, err := store.QueryRange(ctx, from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	return report.QuarterHourDemand(readings), report.PowerPeaks(readings), nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/config"
+	"github.com/harrybawsac/p1-go/src/models"
+	"github.com/harrybawsac/p1-go/src/services/db"
+	"github.com/harrybawsac/p1-go/src/services/report"
+)
+
+// reportBaseline is how far before --from readings are loaded to find the
+// counters the first period starts from
+const reportBaseline = 24 * time.Hour
+
+// subMeterLabels names the sub-meter columns of the report
+var subMeterLabels = map[string]string{
+	models.KindWater:     "WATER",
+	models.KindWarmWater: "WARM WATER",
+	models.KindHeat:      "HEAT",
+}
+
+// runReport prints the consumption of electricity, gas and the sub-meters
+// per day or month: `metercli report [--by day|month] [--from DATE] [--to DATE] [--gas]`.
+func runReport(ctx context.Context, cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	by := fs.String("by", "day", "report period: day or month")
+	fromFlag := fs.String("from", "", "first day of the report, YYYY-MM-DD (default: 7 days or 12 months back)")
+	toFlag := fs.String("to", "", "day after the report, YYYY-MM-DD (default: now)")
+	gas := fs.Bool("gas", false, "list the gas used per gas meter update instead")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	interval, err := report.ParseInterval(*by)
+	if err != nil {
+		return usageErrorf("%v", err)
+	}
+
+	now := time.Now()
+	to := now
+	if *toFlag != "" {
+		if to, err = time.ParseInLocation("2006-01-02", *toFlag, time.Local); err != nil {
+			return usageErrorf("invalid --to: %v", err)
+		}
+	}
+	from := interval.Start(now)
+	if interval == report.Month {
+		from = interval.Add(from, -11)
+	} else {
+		from = interval.Add(from, -6)
+	}
+	if *fromFlag != "" {
+		if from, err = time.ParseInLocation("2006-01-02", *fromFlag, time.Local); err != nil {
+			return usageErrorf("invalid --from: %v", err)
+		}
+	}
+	if !from.Before(to) {
+		return usageErrorf("--from must be before --to")
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	var u report.Usage
+	if us, ok := store.(db.UsageStore); ok && !*gas {
+		counters, err := us.PeriodCounters(ctx, reportBounds(from, to, interval))
+		if err != nil {
+			return err
+		}
+		u = report.SummarizeCounters(counters, from, interval, time.Local)
+	} else {
+		readings, err := store.QueryRange(ctx, from.Add(-reportBaseline), to)
+		if err != nil {
+			return err
+		}
+		if *gas {
+			printGasIntervals(report.GasIntervals(readings), from)
+			return nil
+		}
+		u = report.Summarize(readings, from, interval, time.Local)
+	}
+	if len(u.Periods) == 0 {
+		fmt.Printf("no consumption between %s and %s\n", from.Format("2006-01-02"), to.Format("2006-01-02 15:04"))
+		return nil
+	}
+
+	layout := "2006-01-02"
+	if interval == report.Month {
+		layout = "2006-01"
+	}
+	var kinds []string
+	header := fmt.Sprintf("%-10s %12s %12s %10s", strings.ToUpper(string(interval)), "IMPORT kWh", "EXPORT kWh", "GAS m3")
+	for _, k := range report.SubMeterKinds {
+		if unit, ok := u.Units[k]; ok {
+			kinds = append(kinds, k)
+			header += fmt.Sprintf(" %16s", subMeterLabels[k]+" "+unit)
+		}
+	}
+	fmt.Println(header)
+	for _, p := range u.Periods {
+		line := fmt.Sprintf("%-10s %12.3f %12.3f %10.3f", p.Start.Format(layout), p.ImportKwh, p.ExportKwh, p.GasM3)
+		for _, k := range kinds {
+			line += fmt.Sprintf(" %16.3f", p.SubMeters[k])
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// reportBounds splits [from, to) into report periods, preceded by the
+// baseline period the first counters are taken from
+func reportBounds(from, to time.Time, interval report.Interval) []time.Time {
+	bounds := []time.Time{from.Add(-reportBaseline), from}
+	for k := 1; ; k++ {
+		next := interval.Add(interval.Start(from), k)
+		if !next.Before(to) {
+			break
+		}
+		bounds = append(bounds, next)
+	}
+	return append(bounds, to)
+}
+
+// printGasIntervals lists the gas meter updates ending at or after from
+func printGasIntervals(intervals []report.GasInterval, from time.Time) {
+	fmt.Printf("%-20s %-20s %-20s %10s %8s\n", "METER", "FROM", "TO", "GAS m3", "m3/h")
+	for _, g := range intervals {
+		if g.End.Before(from) {
+			continue
+		}
+		fmt.Printf("%-20s %-20s %-20s %10.3f %8.3f\n", g.MeterID,
+			g.Start.Local().Format("2006-01-02 15:04:05"), g.End.Local().Format("2006-01-02 15:04:05"),
+			g.ConsumedM3, g.FlowM3h())
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/config"
+	"github.com/harrybawsac/p1-go/src/scheduler"
+	"github.com/harrybawsac/p1-go/src/services/db"
+)
+
+// runRuns lists recent scheduler runs from p1.job_runs and the current
+// failure streaks: `metercli runs [--job NAME] [--limit N] [--skipped]`.
+func runRuns(ctx context.Context, cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("runs", flag.ContinueOnError)
+	job := fs.String("job", "", "only show runs of this job")
+	limit := fs.Int("limit", 20, "number of runs to show")
+	skipped := fs.Bool("skipped", false, "include runs skipped because another instance held the lock")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	pg, ok := store.(*db.PostgresAdapter)
+	if !ok {
+		return fmt.Errorf("run history is kept by the postgres driver only; storage driver %q does not record runs", cfg.Storage.Driver)
+	}
+	history := &scheduler.DBHistory{DB: pg.DB}
+
+	runs, err := history.Recent(ctx, *job, *limit, *skipped)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%-20s %-20s %-22s %-10s %9s %6s  %s\n", "STARTED", "JOB", "INSTANCE", "STATUS", "DURATION", "ROWS", "ERROR")
+	for _, r := range runs {
+		fmt.Printf("%-20s %-20s %-22s %-10s %9s %6d  %s\n",
+			r.StartedAt.Local().Format("2006-01-02 15:04:05"), r.Job, r.Instance, r.Status,
+			r.Duration().Round(time.Millisecond), r.Rows, r.Error)
+	}
+
+	streaks, err := history.Streaks(ctx)
+	if err != nil {
+		return err
+	}
+	if len(streaks) == 0 {
+		fmt.Println("\nno failure streaks: every job's latest run succeeded")
+		return nil
+	}
+	fmt.Println("\nfailure streaks:")
+	for _, s := range streaks {
+		if *job != "" && s.Job != *job {
+			continue
+		}
+		fmt.Printf("  %s: %d failed runs since %s, last error: %s\n",
+			s.Job, s.Failures, s.Since.Local().Format("2006-01-02 15:04:05"), s.LastError)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/app"
+	"github.com/harrybawsac/p1-go/src/config"
+	"github.com/harrybawsac/p1-go/src/services/meter"
+)
+
+// runStream keeps a HomeWizard API v2 websocket open and persists every pushed
+// measurement, instead of polling on an interval.
+func runStream(ctx context.Context, cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("stream", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "log pushed measurements without inserting into database")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if cfg.MeterAPIVersion != 2 {
+		return fmt.Errorf("streaming requires meter_api_version 2; run metercli pair first")
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if !*dryRun {
+		if err := checkSchema(ctx, store); err != nil {
+			return err
+		}
+	}
+
+	buf, err := openBuffer(cfg)
+	if err != nil {
+		return err
+	}
+	client := meter.NewV2Client(cfg.MeterEndpoint, cfg.MeterToken, cfg.MeterCertSHA256, 10*time.Second)
+
+	log.Printf("Streaming measurements from %s\n", cfg.MeterEndpoint)
+	if err := app.RunStream(ctx, store, buf, client, *dryRun); err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/scheduler"
+	"github.com/harrybawsac/p1-go/src/services/systemd"
+)
+
+// runSystemdUnit prints a unit file running this binary in loop mode:
+// `metercli --config /etc/p1/config.json systemd-unit --user p1 -- --align --interval 900`.
+// Arguments after -- are appended to the loop-mode command line.
+func runSystemdUnit(cfgPath string, args []string) error {
+	fs := flag.NewFlagSet("systemd-unit", flag.ContinueOnError)
+	binary := fs.String("binary", "", "path of the metercli binary (default: this executable)")
+	user := fs.String("user", "", "run the service as this user")
+	workDir := fs.String("working-dir", "", "working directory, where relative buffer and data paths resolve (default: the config file's directory)")
+	watchdog := fs.Duration("watchdog", 0, "restart the service when no poll succeeds for this long; must exceed the poll interval (0 = no watchdog)")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 30*time.Second, "--shutdown-timeout passed to the service; systemd waits 10s longer before killing it")
+	description := fs.String("description", "P1 meter collector", "unit description")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *binary == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("locate binary: %w", err)
+		}
+		*binary = exe
+	}
+	cfgAbs, err := filepath.Abs(cfgPath)
+	if err != nil {
+		return err
+	}
+	if *workDir == "" {
+		*workDir = filepath.Dir(cfgAbs)
+	}
+
+	exec := []string{*binary, "--config", cfgAbs, "--loop", "--shutdown-timeout", shutdownTimeout.String()}
+	unit := systemd.Unit{
+		Description:      *description,
+		ExecStart:        append(exec, fs.Args()...),
+		User:             *user,
+		WorkingDirectory: *workDir,
+		Watchdog:         *watchdog,
+		StopTimeout:      *shutdownTimeout + 10*time.Second,
+	}
+	_, err = unit.WriteTo(os.Stdout)
+	return err
+}
+
+// notifyPolls reports poll runs to systemd: READY=1 after the first run
+// that did not fail, the outcome of the last run as STATUS and a WATCHDOG=1 ping for every run
+// that did not fail, so a collector stuck failing or hanging is restarted
+func notifyPolls(n *systemd.Notifier) func(scheduler.RunRecord) {
+	ready := false
+	return func(r scheduler.RunRecord) {
+		status := pollStatus(r)
+		ok := r.Status == scheduler.RunSucceeded || r.Status == scheduler.RunSkipped
+		var err error
+		if ok && !ready {
+			ready = true
+			err = n.Ready(status)
+		} else {
+			err = n.Status(status)
+		}
+		if err == nil && ok {
+			err = n.Watchdog()
+		}
+		if err != nil {
+			log.Printf("systemd notify: %v\n", err)
+		}
+	}
+}
+
+// pollStatus describes the outcome of a poll for systemctl status
+func pollStatus(r scheduler.RunRecord) string {
+	at := r.FinishedAt.Local().Format("15:04:05")
+	switch r.Status {
+	case scheduler.RunSucceeded:
+		return fmt.Sprintf("last poll succeeded at %s, %d readings stored", at, r.Rows)
+	case scheduler.RunSkipped:
+		return fmt.Sprintf("standby: poll at %s skipped, another instance holds the lock", at)
+	default:
+		return fmt.Sprintf("last poll %s at %s (%d in a row): %s", r.Status, at, r.Failures, r.Error)
+	}
+}
@@ -0,0 +1,3 @@
+-- Revert 001: drop the tables, keeping schema p1 (it also holds schema_migrations)
+DROP TABLE IF EXISTS p1.external_readings;
+DROP TABLE IF EXISTS p1.meter_readings;
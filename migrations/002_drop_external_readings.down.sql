@@ -0,0 +1,11 @@
+-- Recreate the (empty) external_readings table
+CREATE TABLE IF NOT EXISTS p1.external_readings (
+	id BIGSERIAL PRIMARY KEY,
+	meter_reading_id BIGINT NOT NULL REFERENCES p1.meter_readings(id) ON DELETE CASCADE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	unique_id TEXT,
+	type TEXT,
+	timestamp BIGINT,
+	value NUMERIC(14, 3),
+	unit TEXT
+);
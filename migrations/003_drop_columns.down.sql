@@ -0,0 +1,8 @@
+-- Restore the dropped columns. Their data is gone, so unique_id can no longer
+-- be NOT NULL.
+ALTER TABLE p1.meter_readings ADD COLUMN IF NOT EXISTS unique_id TEXT;
+ALTER TABLE p1.meter_readings ADD COLUMN IF NOT EXISTS wifi_ssid TEXT;
+ALTER TABLE p1.meter_readings ADD COLUMN IF NOT EXISTS wifi_strength INT;
+ALTER TABLE p1.meter_readings ADD COLUMN IF NOT EXISTS smr_version INT;
+ALTER TABLE p1.meter_readings ADD COLUMN IF NOT EXISTS meter_model TEXT;
+ALTER TABLE p1.meter_readings ADD COLUMN IF NOT EXISTS gas_unique_id TEXT;
@@ -0,0 +1,3 @@
+-- Drop the unique key and meter_id; removed duplicates are not restored
+DROP INDEX IF EXISTS p1.meter_readings_meter_created_at_key;
+ALTER TABLE p1.meter_readings DROP COLUMN IF EXISTS meter_id;
@@ -0,0 +1,16 @@
+-- Identify the meter a reading belongs to and make (meter_id, created_at) unique
+-- so re-running an import or draining a buffer twice does not duplicate rows.
+-- Existing rows get an empty meter_id: the meter's unique_id was dropped in
+-- 003, so they cannot be attributed here (see the README for a backfill when
+-- there is a single meter).
+ALTER TABLE p1.meter_readings ADD COLUMN IF NOT EXISTS meter_id TEXT NOT NULL DEFAULT '';
+
+-- Remove duplicates inserted before the key existed, keeping the oldest row
+DELETE FROM p1.meter_readings a
+USING p1.meter_readings b
+WHERE a.meter_id = b.meter_id
+  AND a.created_at = b.created_at
+  AND a.id > b.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS meter_readings_meter_created_at_key
+	ON p1.meter_readings (meter_id, created_at);
@@ -0,0 +1,9 @@
+-- Leases for leader election between collectors. One row per lease name;
+-- the holder renews expires_at and a standby takes over once it has passed.
+CREATE TABLE IF NOT EXISTS p1.leases (
+	name TEXT PRIMARY KEY,
+	holder TEXT NOT NULL,
+	acquired_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	renewed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	expires_at TIMESTAMPTZ NOT NULL
+);
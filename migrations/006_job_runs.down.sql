@@ -0,0 +1 @@
+DROP TABLE IF EXISTS p1.job_runs;
@@ -0,0 +1,17 @@
+-- One row per scheduler execution, written by collectors in --loop mode and
+-- pruned by the job-runs-retention job
+CREATE TABLE IF NOT EXISTS p1.job_runs (
+	id BIGSERIAL PRIMARY KEY,
+	job TEXT NOT NULL,
+	instance TEXT NOT NULL,
+	scheduled_at TIMESTAMPTZ,
+	started_at TIMESTAMPTZ NOT NULL,
+	finished_at TIMESTAMPTZ NOT NULL,
+	status TEXT NOT NULL,
+	error TEXT,
+	rows_written BIGINT NOT NULL DEFAULT 0,
+	failures INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS job_runs_job_started_at_idx ON p1.job_runs (job, started_at DESC);
+CREATE INDEX IF NOT EXISTS job_runs_started_at_idx ON p1.job_runs (started_at);
@@ -0,0 +1,4 @@
+-- Drop device metadata and sub-meter readings
+DROP TABLE IF EXISTS p1.device_wifi;
+DROP TABLE IF EXISTS p1.devices;
+DROP TABLE IF EXISTS p1.external_readings CASCADE;
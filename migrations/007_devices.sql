@@ -0,0 +1,53 @@
+-- Meter metadata dropped from meter_readings by 003, one row per meter
+-- (meter_readings.meter_id). The model, SMR version and Wi-Fi columns hold
+-- the values of the newest reading.
+CREATE TABLE IF NOT EXISTS p1.devices (
+	unique_id TEXT PRIMARY KEY,
+	meter_model TEXT,
+	smr_version INT,
+	wifi_ssid TEXT,
+	wifi_strength INT,
+	first_seen TIMESTAMPTZ NOT NULL,
+	last_seen TIMESTAMPTZ NOT NULL
+);
+
+-- Wi-Fi quality over time, sampled when it changes and at least hourly
+CREATE TABLE IF NOT EXISTS p1.device_wifi (
+	unique_id TEXT NOT NULL REFERENCES p1.devices(unique_id) ON DELETE CASCADE,
+	recorded_at TIMESTAMPTZ NOT NULL,
+	wifi_ssid TEXT,
+	wifi_strength INT,
+	PRIMARY KEY (unique_id, recorded_at)
+);
+
+-- Sub-meters (gas, water, heat) read with a reading, as dropped by 002
+CREATE TABLE IF NOT EXISTS p1.external_readings (
+	id BIGSERIAL PRIMARY KEY,
+	meter_reading_id BIGINT NOT NULL REFERENCES p1.meter_readings(id) ON DELETE CASCADE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	unique_id TEXT,
+	type TEXT,
+	timestamp BIGINT,
+	value NUMERIC(14, 3),
+	unit TEXT
+);
+
+-- a table recreated by 002's down migration allowed NULLs here
+UPDATE p1.external_readings SET unique_id = '' WHERE unique_id IS NULL;
+UPDATE p1.external_readings SET type = '' WHERE type IS NULL;
+-- rows that only differed in those NULLs are now duplicates; keep the first
+DELETE FROM p1.external_readings a
+	USING p1.external_readings b
+	WHERE a.meter_reading_id = b.meter_reading_id
+		AND a.type = b.type
+		AND a.unique_id = b.unique_id
+		AND a.id > b.id;
+ALTER TABLE p1.external_readings
+	ALTER COLUMN unique_id SET DEFAULT '',
+	ALTER COLUMN unique_id SET NOT NULL,
+	ALTER COLUMN type SET NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS external_readings_reading_device_key
+	ON p1.external_readings (meter_reading_id, type, unique_id);
+CREATE INDEX IF NOT EXISTS external_readings_type_created_at_idx
+	ON p1.external_readings (type, created_at);
@@ -0,0 +1,6 @@
+DROP VIEW IF EXISTS p1.heat_series;
+DROP VIEW IF EXISTS p1.warm_water_series;
+DROP VIEW IF EXISTS p1.water_series;
+DROP VIEW IF EXISTS p1.gas_series;
+DROP INDEX IF EXISTS p1.external_readings_kind_created_at_idx;
+ALTER TABLE p1.external_readings DROP COLUMN IF EXISTS kind;
@@ -0,0 +1,31 @@
+-- Per-type series of the sub-meters. kind classifies a row by its type and,
+-- for types the collector does not know, by its unit (see
+-- models.ExternalReading.Kind); it is '' for rows neither identifies.
+ALTER TABLE p1.external_readings ADD COLUMN IF NOT EXISTS kind TEXT NOT NULL DEFAULT '';
+
+UPDATE p1.external_readings SET kind = CASE
+	WHEN type = 'gas_meter' THEN 'gas'
+	WHEN type = 'water_meter' THEN 'water'
+	WHEN type = 'warm_water_meter' THEN 'warm_water'
+	WHEN type IN ('heat_meter', 'inlet_heat_meter') THEN 'heat'
+	WHEN unit IN ('GJ', 'MJ') THEN 'heat'
+	ELSE ''
+END
+WHERE kind = '';
+
+CREATE INDEX IF NOT EXISTS external_readings_kind_created_at_idx
+	ON p1.external_readings (kind, unique_id, created_at);
+
+-- one view per kind: the counter of each sub-meter over time
+CREATE OR REPLACE VIEW p1.gas_series AS
+	SELECT created_at, unique_id, timestamp, value, unit, meter_reading_id
+	FROM p1.external_readings WHERE kind = 'gas';
+CREATE OR REPLACE VIEW p1.water_series AS
+	SELECT created_at, unique_id, timestamp, value, unit, meter_reading_id
+	FROM p1.external_readings WHERE kind = 'water';
+CREATE OR REPLACE VIEW p1.warm_water_series AS
+	SELECT created_at, unique_id, timestamp, value, unit, meter_reading_id
+	FROM p1.external_readings WHERE kind = 'warm_water';
+CREATE OR REPLACE VIEW p1.heat_series AS
+	SELECT created_at, unique_id, timestamp, value, unit, meter_reading_id
+	FROM p1.external_readings WHERE kind = 'heat';
@@ -0,0 +1,4 @@
+DROP VIEW IF EXISTS p1.gas_intervals;
+DROP INDEX IF EXISTS p1.meter_readings_meter_gas_measured_at_idx;
+ALTER TABLE p1.meter_readings DROP COLUMN IF EXISTS gas_measured_at;
+DROP FUNCTION IF EXISTS p1.dsmr_timestamp(BIGINT);
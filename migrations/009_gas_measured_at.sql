@@ -0,0 +1,53 @@
+-- gas_timestamp decoded from Dutch local time (YYMMDDhhmmss). The DST flag of
+-- telegrams is not stored, so the backfill resolves the hour that occurs
+-- twice when the clocks go back to winter time like dsmr.DecodeTimestamp
+-- does for the collector: the time is read as summer time (+02) when that
+-- is summer time in Europe/Amsterdam, and as winter time (+01) otherwise,
+-- which also moves a time skipped when the clocks go forward an hour on.
+-- Plain AT TIME ZONE would pick the later, winter time reading of the
+-- repeated hour. Malformed timestamps give NULL.
+CREATE OR REPLACE FUNCTION p1.dsmr_timestamp(ts BIGINT) RETURNS TIMESTAMPTZ
+LANGUAGE plpgsql STABLE AS $$
+DECLARE
+	local_time TIMESTAMP;
+	summer_time TIMESTAMPTZ;
+BEGIN
+	IF ts IS NULL OR ts <= 0 THEN
+		RETURN NULL;
+	END IF;
+	local_time := make_timestamp(2000 + (ts / 10000000000)::int, (ts / 100000000 % 100)::int, (ts / 1000000 % 100)::int,
+		(ts / 10000 % 100)::int, (ts / 100 % 100)::int, (ts % 100)::double precision);
+	summer_time := (local_time - interval '2 hours') AT TIME ZONE 'UTC';
+	IF summer_time AT TIME ZONE 'Europe/Amsterdam' = local_time THEN
+		RETURN summer_time;
+	END IF;
+	RETURN (local_time - interval '1 hour') AT TIME ZONE 'UTC';
+EXCEPTION WHEN datetime_field_overflow THEN
+	RETURN NULL;
+END;
+$$;
+
+ALTER TABLE p1.meter_readings ADD COLUMN IF NOT EXISTS gas_measured_at TIMESTAMPTZ;
+
+UPDATE p1.meter_readings SET gas_measured_at = p1.dsmr_timestamp(gas_timestamp)
+WHERE gas_measured_at IS NULL AND gas_timestamp > 0;
+
+CREATE INDEX IF NOT EXISTS meter_readings_meter_gas_measured_at_idx
+	ON p1.meter_readings (meter_id, gas_measured_at);
+
+-- Gas use per gas meter update (hourly for DSMR 4, every 5 minutes for
+-- DSMR 5) instead of per reading: one row per distinct measurement, with
+-- the consumption since the previous one
+CREATE OR REPLACE VIEW p1.gas_intervals AS
+SELECT meter_id,
+	lag(gas_measured_at) OVER w AS started_at,
+	gas_measured_at AS ended_at,
+	total_gas_m3,
+	total_gas_m3 - lag(total_gas_m3) OVER w AS consumed_m3
+FROM (
+	SELECT DISTINCT ON (meter_id, gas_measured_at) meter_id, gas_measured_at, total_gas_m3
+	FROM p1.meter_readings
+	WHERE gas_measured_at IS NOT NULL
+	ORDER BY meter_id, gas_measured_at, created_at
+) g
+WINDOW w AS (PARTITION BY meter_id ORDER BY gas_measured_at);
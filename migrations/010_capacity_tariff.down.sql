@@ -0,0 +1,6 @@
+DROP VIEW IF EXISTS p1.quarter_hour_demand;
+DROP FUNCTION IF EXISTS p1.quarter_hour_demand_between(TIMESTAMPTZ, TIMESTAMPTZ);
+ALTER TABLE p1.meter_readings DROP COLUMN IF EXISTS monthly_power_peak_at;
+ALTER TABLE p1.meter_readings DROP COLUMN IF EXISTS monthly_power_peak_timestamp;
+ALTER TABLE p1.meter_readings DROP COLUMN IF EXISTS monthly_power_peak_w;
+ALTER TABLE p1.meter_readings DROP COLUMN IF EXISTS active_power_average_w;
@@ -0,0 +1,54 @@
+-- Belgian capacity tariff: the meter's running average import demand of the
+-- current quarter-hour (1-0:1.4.0) and the highest quarter-hour average of
+-- the month (1-0:1.6.0) with the time it occurred
+ALTER TABLE p1.meter_readings ADD COLUMN IF NOT EXISTS active_power_average_w NUMERIC(14, 3);
+ALTER TABLE p1.meter_readings ADD COLUMN IF NOT EXISTS monthly_power_peak_w NUMERIC(14, 3);
+ALTER TABLE p1.meter_readings ADD COLUMN IF NOT EXISTS monthly_power_peak_timestamp BIGINT;
+ALTER TABLE p1.meter_readings ADD COLUMN IF NOT EXISTS monthly_power_peak_at TIMESTAMPTZ;
+
+UPDATE p1.meter_readings SET monthly_power_peak_at = p1.dsmr_timestamp(monthly_power_peak_timestamp)
+WHERE monthly_power_peak_at IS NULL AND monthly_power_peak_timestamp > 0;
+
+-- Average import demand per quarter-hour computed from the import counters,
+-- for meters that do not report it: the counter at the end of each
+-- quarter-hour against the counter at its start, where the counter at a
+-- boundary is the first reading at or after it. A reading exactly on a
+-- boundary so ends the quarter before it. Quarters without a reading in the
+-- quarter-hour that follows are left out. The function only reads the
+-- readings between from_ts and to_ts, so reports over a few months do not
+-- aggregate the whole table; the view covers all readings.
+CREATE OR REPLACE FUNCTION p1.quarter_hour_demand_between(from_ts TIMESTAMPTZ, to_ts TIMESTAMPTZ)
+RETURNS TABLE (meter_id TEXT, quarter_start TIMESTAMPTZ, average_w NUMERIC)
+LANGUAGE sql STABLE AS $$
+SELECT d.meter_id,
+	d.quarter_start,
+	round((d.next_import_kwh - d.import_kwh) * 4000, 3)
+FROM (
+	SELECT b.meter_id,
+		b.quarter_start,
+		b.import_kwh,
+		lead(b.import_kwh) OVER w AS next_import_kwh,
+		lead(b.quarter_start) OVER w AS next_quarter_start
+	FROM (
+		SELECT r.meter_id,
+			to_timestamp(floor(extract(epoch FROM r.created_at) / 900) * 900) AS quarter_start,
+			(array_agg(r.import_kwh ORDER BY r.created_at))[1] AS import_kwh
+		FROM (
+			SELECT meter_id,
+				created_at,
+				COALESCE(NULLIF(total_power_import_kwh, 0), total_power_import_t1_kwh + total_power_import_t2_kwh) AS import_kwh
+			FROM p1.meter_readings
+			WHERE created_at >= from_ts AND created_at < to_ts
+		) r
+		WHERE r.import_kwh > 0
+		GROUP BY 1, 2
+	) b
+	WINDOW w AS (PARTITION BY b.meter_id ORDER BY b.quarter_start)
+) d
+WHERE d.next_quarter_start = d.quarter_start + interval '15 minutes'
+	AND d.next_import_kwh >= d.import_kwh;
+$$;
+
+CREATE OR REPLACE VIEW p1.quarter_hour_demand AS
+SELECT meter_id, quarter_start, average_w
+FROM p1.quarter_hour_demand_between('-infinity', 'infinity');
@@ -0,0 +1,10 @@
+package migrations
+
+import "embed"
+
+// FS holds the PostgreSQL migrations so metercli can apply them without the
+// SQL files being present on the host. Each version NNN has an up migration
+// NNN_name.sql and a NNN_name.down.sql that reverts it.
+//
+//go:embed *.sql
+var FS embed.FS
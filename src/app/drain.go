@@ -0,0 +1,108 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/buffer"
+	"github.com/harrybawsac/p1-go/src/models"
+	"github.com/harrybawsac/p1-go/src/scheduler"
+	"github.com/harrybawsac/p1-go/src/services/db"
+	"github.com/harrybawsac/p1-go/src/services/parser"
+)
+
+// DrainBuffer re-parses every buffered entry and inserts it into store.
+// Entries that are already stored count as persisted; an unreachable
+// database or a cancelled ctx stops the drain without charging the entries
+// an attempt.
+func DrainBuffer(ctx context.Context, store db.Store, buf *buffer.Buffer) (buffer.DrainSummary, error) {
+	return DrainBufferLimited(ctx, store, buf, 0)
+}
+
+// DrainBufferLimited is DrainBuffer inserting at most perSecond entries per
+// second, so a large backlog drained next to live polling does not saturate
+// the database. perSecond <= 0 means unlimited.
+func DrainBufferLimited(ctx context.Context, store db.Store, buf *buffer.Buffer, perSecond float64) (buffer.DrainSummary, error) {
+	var gap time.Duration
+	if perSecond > 0 {
+		gap = time.Duration(float64(time.Second) / perSecond)
+	}
+	var next time.Time
+	return buf.DrainEntries(ctx, func(ctx context.Context, e buffer.Entry) error {
+		if gap > 0 {
+			if wait := time.Until(next); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return fmt.Errorf("%w: %v", buffer.ErrUnavailable, ctx.Err())
+				case <-time.After(wait):
+				}
+			}
+			next = time.Now().Add(gap)
+		}
+
+		r, err := ParseEntry(e)
+		if err != nil {
+			return err
+		}
+		err = store.InsertReading(ctx, r)
+		switch {
+		case err == nil:
+			scheduler.AddRows(ctx, 1)
+		case errors.Is(err, db.ErrDuplicateReading):
+			return nil
+		case db.IsUnavailable(err), ctx.Err() != nil, errors.Is(err, context.Canceled):
+			// a drain stopped mid-insert is not the entry's fault
+			return fmt.Errorf("%w: %v", buffer.ErrUnavailable, err)
+		}
+		return err
+	})
+}
+
+// ParseEntry decodes a buffered entry into a Reading stamped with its capture
+// time. Legacy entries without a format are detected from the payload: JSON
+// strings are raw DSMR telegrams, objects are meter API payloads (v1 or v2);
+// they carry no capture time and are stamped when inserted.
+func ParseEntry(e buffer.Entry) (models.Reading, error) {
+	format := e.Format
+	if format == "" {
+		format = sniffFormat(e.Payload)
+	}
+
+	var r models.Reading
+	var err error
+	switch format {
+	case buffer.FormatTelegram:
+		var telegram string
+		if err := json.Unmarshal(e.Payload, &telegram); err != nil {
+			return models.Reading{}, fmt.Errorf("telegram payload: %w", err)
+		}
+		r, err = parser.ParseTelegram([]byte(telegram))
+	case buffer.FormatHomeWizardV2:
+		r, err = parser.ParseMeasurementV2(e.Payload)
+	case buffer.FormatHomeWizardV1:
+		r, err = parser.ParseFullReading(e.Payload)
+	default:
+		return models.Reading{}, fmt.Errorf("unknown buffered payload format %q", e.Format)
+	}
+	if err != nil {
+		return models.Reading{}, err
+	}
+	if !e.CapturedAt.IsZero() {
+		r.CreatedAt = e.CapturedAt
+	}
+	return r, nil
+}
+
+func sniffFormat(payload json.RawMessage) string {
+	var telegram string
+	if err := json.Unmarshal(payload, &telegram); err == nil {
+		return buffer.FormatTelegram
+	}
+	if parser.IsMeasurementV2(payload) {
+		return buffer.FormatHomeWizardV2
+	}
+	return buffer.FormatHomeWizardV1
+}
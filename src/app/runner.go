@@ -4,18 +4,34 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/harrybawsac/p1-go/src/buffer"
+	"github.com/harrybawsac/p1-go/src/models"
+	"github.com/harrybawsac/p1-go/src/scheduler"
 	"github.com/harrybawsac/p1-go/src/services/db"
+	"github.com/harrybawsac/p1-go/src/services/dsmr"
+	"github.com/harrybawsac/p1-go/src/services/meter"
 	"github.com/harrybawsac/p1-go/src/services/parser"
 )
 
 // RunOnceWithDeps performs a single fetch -> parse -> persist cycle using injected dependencies.
-func RunOnceWithDeps(ctx context.Context, adapter *db.PostgresAdapter, buf *buffer.Buffer, dryRun bool) error {
+func RunOnceWithDeps(ctx context.Context, store db.Store, buf *buffer.Buffer, dryRun bool) error {
+	return RunOnceValidated(ctx, store, buf, parser.InvalidWarn, dryRun)
+}
+
+// RunOnceValidated is RunOnceWithDeps validating the payload strictly. An
+// invalid payload is stored with a warning, rejected, or moved to the
+// buffer's dead-letter file for review, depending on policy; the last two
+// fail the run.
+func RunOnceValidated(ctx context.Context, store db.Store, buf *buffer.Buffer, policy parser.InvalidPolicy, dryRun bool) error {
 	endpoint := os.Getenv("METER_ENDPOINT")
 	if endpoint == "" {
 		return fmt.Errorf("METER_ENDPOINT not set")
@@ -38,8 +54,9 @@ func RunOnceWithDeps(ctx context.Context, adapter *db.PostgresAdapter, buf *buff
 		return err
 	}
 
-	r, err := parser.ParseFullReading(body)
-	if err != nil {
+	r, report, err := parser.ParseFullReadingStrict(body)
+	var invalid *parser.ValidationError
+	if err != nil && !errors.As(err, &invalid) {
 		return err
 	}
 
@@ -52,15 +69,153 @@ func RunOnceWithDeps(ctx context.Context, adapter *db.PostgresAdapter, buf *buff
 			fmt.Printf("[DRY RUN] Fetched data:\n%s\n", pretty.String())
 		}
 		fmt.Printf("[DRY RUN] Parsed reading: %+v\n", r)
+		fmt.Printf("[DRY RUN] Validation: %s\n", report)
 		return nil
 	}
 
-	if err := adapter.InsertReading(ctx, r); err != nil {
-		// buffer raw payload for retry
-		if berr := buf.Append(json.RawMessage(body)); berr != nil {
-			return fmt.Errorf("insert failed: %v; buffer append failed: %v", err, berr)
+	e := buffer.Entry{Source: endpoint, Format: buffer.FormatHomeWizardV1, Payload: body}
+	switch {
+	case invalid == nil:
+		logUnknownFields(report)
+	case policy == parser.InvalidReject:
+		return invalid
+	case policy == parser.InvalidReview:
+		e.CapturedAt, e.LastError = time.Now().UTC(), invalid.Error()
+		if derr := buf.DeadLetter(e); derr != nil {
+			return fmt.Errorf("%v; dead-letter failed: %v", invalid, derr)
 		}
+		return fmt.Errorf("%w; payload kept for review in %s", invalid, buf.DeadLetterPath)
+	default:
+		log.Printf("%v; storing the reading anyway\n", invalid)
+	}
+	return persist(ctx, store, buf, r, e)
+}
+
+// unknownFieldsLogged remembers reports of unknown fields already logged, so
+// a firmware field unknown to this version is mentioned once, not every poll
+var unknownFieldsLogged sync.Map
+
+func logUnknownFields(report parser.ValidationReport) {
+	if len(report.Issues) == 0 {
+		return
+	}
+	if _, seen := unknownFieldsLogged.LoadOrStore(report.String(), true); !seen {
+		log.Printf("meter payload has fields this version does not know: %s\n", report)
+	}
+}
+
+// RunTelegramOnceWithDeps reads the next complete DSMR telegram from src and
+// runs it through the same parse -> persist -> buffer cycle as RunOnceWithDeps.
+// The raw telegram is buffered as a JSON string when the insert fails; source
+// names the device it came from.
+func RunTelegramOnceWithDeps(ctx context.Context, store db.Store, buf *buffer.Buffer, src *dsmr.Reader, source string, dryRun bool) error {
+	telegram, err := src.ReadTelegram()
+	if err != nil {
+		return fmt.Errorf("read telegram: %w", err)
+	}
+
+	r, err := parser.ParseTelegram(telegram)
+	if err != nil {
 		return err
 	}
-	return nil
+
+	if dryRun {
+		fmt.Printf("[DRY RUN] Received telegram:\n%s\n", string(telegram))
+		fmt.Printf("[DRY RUN] Parsed reading: %+v\n", r)
+		return nil
+	}
+
+	payload, _ := json.Marshal(string(telegram))
+	return persist(ctx, store, buf, r, buffer.Entry{Source: source, Format: buffer.FormatTelegram, Payload: payload})
+}
+
+// RunSerialOnce opens the P1 serial device, reads a single telegram and
+// persists it via RunTelegramOnceWithDeps. The port is opened per run so that
+// each scheduler tick sees a fresh telegram instead of a stale queued one.
+func RunSerialOnce(ctx context.Context, store db.Store, buf *buffer.Buffer, device string, baud int, dryRun bool) error {
+	port, err := dsmr.OpenSerial(device, baud)
+	if err != nil {
+		return fmt.Errorf("open serial %s: %w", device, err)
+	}
+	defer port.Close()
+
+	// unblock the read when ctx is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			port.Close()
+		case <-done:
+		}
+	}()
+
+	return RunTelegramOnceWithDeps(ctx, store, buf, dsmr.NewReader(port), device, dryRun)
+}
+
+// RunV2OnceWithDeps performs a fetch -> parse -> persist cycle against the
+// HomeWizard API v2 measurement endpoint.
+func RunV2OnceWithDeps(ctx context.Context, store db.Store, buf *buffer.Buffer, client *meter.V2Client, dryRun bool) error {
+	body, err := client.GetMeasurement(ctx)
+	if err != nil {
+		return err
+	}
+
+	r, err := parser.ParseMeasurementV2(body)
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		fmt.Printf("[DRY RUN] Fetched measurement: %s\n", string(body))
+		fmt.Printf("[DRY RUN] Parsed reading: %+v\n", r)
+		return nil
+	}
+
+	return persist(ctx, store, buf, r, buffer.Entry{Source: client.BaseURL, Format: buffer.FormatHomeWizardV2, Payload: body})
+}
+
+// RunStream persists every measurement pushed over the HomeWizard API v2
+// websocket until ctx is done. Readings are stamped with their receive time so
+// per-second pushes keep their resolution.
+func RunStream(ctx context.Context, store db.Store, buf *buffer.Buffer, client *meter.V2Client, dryRun bool) error {
+	return client.Stream(ctx, func(ctx context.Context, body []byte) error {
+		r, err := parser.ParseMeasurementV2(body)
+		if err != nil {
+			return err
+		}
+		r.CreatedAt = time.Now().UTC()
+
+		if dryRun {
+			fmt.Printf("[DRY RUN] Pushed measurement: %s\n", string(body))
+			fmt.Printf("[DRY RUN] Parsed reading: %+v\n", r)
+			return nil
+		}
+
+		return persist(ctx, store, buf, r, buffer.Entry{Source: client.BaseURL, Format: buffer.FormatHomeWizardV2, Payload: body})
+	})
+}
+
+// persist inserts r and, when the store is unavailable, buffers the raw
+// payload in e for a later --drain-buffer. The reading is stamped before the
+// insert so a buffered entry keeps the time it was captured. Duplicates
+// rejected by the conflict policy are not buffered since retrying them can
+// never succeed.
+func persist(ctx context.Context, store db.Store, buf *buffer.Buffer, r models.Reading, e buffer.Entry) error {
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now().UTC()
+	}
+	err := store.InsertReading(ctx, r)
+	if err == nil {
+		scheduler.AddRows(ctx, 1)
+		return nil
+	}
+	if errors.Is(err, db.ErrDuplicateReading) {
+		return err
+	}
+	e.CapturedAt = r.CreatedAt
+	if berr := buf.AppendEntry(e); berr != nil {
+		return fmt.Errorf("insert failed: %v; buffer append failed: %v", err, berr)
+	}
+	return err
 }
@@ -0,0 +1,283 @@
+package buffer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Stats summarizes the buffer contents
+type Stats struct {
+	Entries  int
+	Corrupt  int
+	Segments int
+	Bytes    int64
+	// Oldest and Newest capture times; zero when no entry carries one
+	Oldest, Newest time.Time
+	ByFormat       map[string]int
+	DeadLettered   int
+}
+
+// Stats walks the buffer and the dead-letter file
+func (b *Buffer) Stats() (Stats, error) {
+	st := Stats{ByFormat: map[string]int{}}
+	err := b.Walk(func(_ string, e Entry, err error) error {
+		if err != nil {
+			st.Corrupt++
+			return nil
+		}
+		st.Entries++
+		st.ByFormat[e.Format]++
+		if !e.CapturedAt.IsZero() {
+			if st.Oldest.IsZero() || e.CapturedAt.Before(st.Oldest) {
+				st.Oldest = e.CapturedAt
+			}
+			if e.CapturedAt.After(st.Newest) {
+				st.Newest = e.CapturedAt
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return st, err
+	}
+
+	b.mu.Lock()
+	segs, err := b.segments()
+	b.mu.Unlock()
+	if err != nil {
+		return st, err
+	}
+	for _, seg := range segs {
+		if fi, err := os.Stat(filepath.Join(b.dir, seg)); err == nil {
+			st.Segments++
+			st.Bytes += fi.Size()
+		}
+	}
+
+	err = b.WalkDeadLetters(func(Entry) error {
+		st.DeadLettered++
+		return nil
+	})
+	return st, err
+}
+
+// WalkDeadLetters calls fn for every entry in the dead-letter file
+func (b *Buffer) WalkDeadLetters(fn func(Entry) error) error {
+	f, err := os.Open(b.DeadLetterPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// DeadLetter writes e straight to the dead-letter file, e.g. a payload that
+// failed validation and needs a look before it is stored. Requeue moves it
+// back into the buffer.
+func (b *Buffer) DeadLetter(e Entry) error {
+	b.deadMu.Lock()
+	defer b.deadMu.Unlock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	unlock, err := b.lockDir()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return appendLines(b.DeadLetterPath, []Entry{e})
+}
+
+// Purge removes entries captured before cutoff and returns how many were
+// removed. Legacy entries without a capture time are kept. Corrupt records
+// are dropped as well.
+func (b *Buffer) Purge(cutoff time.Time) (int, error) {
+	return b.filter(func(e Entry) bool {
+		return e.CapturedAt.IsZero() || !e.CapturedAt.Before(cutoff)
+	})
+}
+
+// Requeue moves every dead-lettered entry back into the buffer with its
+// attempt counter reset, and returns how many were moved. When an append
+// fails, the dead-letter file keeps only the entries not moved yet. It
+// returns ErrLocked while another process drains the buffer.
+func (b *Buffer) Requeue() (int, error) {
+	release, err := b.lockExclusive()
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	b.deadMu.Lock()
+	defer b.deadMu.Unlock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	unlock, err := b.lockDir()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	var entries []Entry
+	if err := b.WalkDeadLetters(func(e Entry) error {
+		e.Attempts, e.LastError = 0, ""
+		entries = append(entries, e)
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	for i, e := range entries {
+		data, err := json.Marshal(e)
+		if err == nil {
+			err = b.appendRecord(encodeRecord(data))
+		}
+		if err != nil {
+			if i > 0 {
+				if werr := rewriteDeadLetters(b.DeadLetterPath, i); werr != nil {
+					return i, fmt.Errorf("%w; dead-letter file not updated, %d entries will be requeued again: %v", err, i, werr)
+				}
+			}
+			return i, err
+		}
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	return len(entries), os.Remove(b.DeadLetterPath)
+}
+
+// rewriteDeadLetters drops the first n records of the dead-letter file at
+// path, keeping the rest as they are
+func rewriteDeadLetters(path string, n int) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmpPath := path + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+	seen := 0
+	for scanner.Scan() {
+		// count records the way WalkDeadLetters does, skipping unreadable lines
+		var e Entry
+		if seen < n && json.Unmarshal(scanner.Bytes(), &e) == nil {
+			seen++
+			continue
+		}
+		w.Write(scanner.Bytes())
+		w.WriteByte('\n')
+	}
+	err = scanner.Err()
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
+
+// filter rewrites every segment keeping only entries for which keep returns
+// true. It blocks appends and drains, also those of other processes, for its
+// duration, and returns ErrLocked while another process drains.
+func (b *Buffer) filter(keep func(Entry) bool) (int, error) {
+	release, err := b.lockExclusive()
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	unlock, err := b.lockDir()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	segs, err := b.segments()
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, seg := range segs {
+		path := filepath.Join(b.dir, seg)
+		in, err := os.Open(path)
+		if err != nil {
+			return removed, err
+		}
+		tmpPath := path + ".tmp"
+		out, err := os.Create(tmpPath)
+		if err != nil {
+			in.Close()
+			return removed, err
+		}
+		w := bufio.NewWriter(out)
+		kept := 0
+		err = readRecords(in, func(line []byte, e Entry, rerr error) error {
+			if rerr != nil || !keep(e) {
+				removed++
+				return nil
+			}
+			kept++
+			_, err := w.Write(line)
+			return err
+		})
+		in.Close()
+		if err == nil {
+			err = w.Flush()
+		}
+		if err == nil {
+			err = out.Sync()
+		}
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			if kept == 0 {
+				os.Remove(tmpPath)
+				err = os.Remove(path)
+			} else {
+				err = os.Rename(tmpPath, path)
+			}
+		}
+		if err != nil {
+			os.Remove(tmpPath)
+			return removed, err
+		}
+	}
+	// the active segment may be gone or shorter now
+	b.active, b.activeSize = "", 0
+	return removed, nil
+}
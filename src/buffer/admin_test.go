@@ -0,0 +1,124 @@
+package buffer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStatsPurgeAndRequeue(t *testing.T) {
+	b := New(t.TempDir())
+	b.MaxAttempts = 1
+	old := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	recent := time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC)
+	for _, e := range []Entry{
+		{CapturedAt: old, Format: FormatHomeWizardV1, Payload: json.RawMessage(`{"a":1}`)},
+		{CapturedAt: recent, Format: FormatTelegram, Payload: json.RawMessage(`"poison"`)},
+		{CapturedAt: recent.Add(time.Hour), Format: FormatHomeWizardV1, Payload: json.RawMessage(`{"a":2}`)},
+	} {
+		if err := b.Append(e); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	st, err := b.Stats()
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if st.Entries != 3 || st.ByFormat[FormatHomeWizardV1] != 2 || !st.Oldest.Equal(old) || !st.Newest.Equal(recent.Add(time.Hour)) || st.Bytes == 0 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+
+	n, err := b.Purge(recent)
+	if err != nil || n != 1 {
+		t.Fatalf("purge: removed %d, %v", n, err)
+	}
+
+	// dead-letter the poisoned entry, then requeue it
+	if _, err := b.DrainEntries(context.Background(), func(ctx context.Context, e Entry) error {
+		if e.Format == FormatTelegram {
+			return errors.New("cannot parse")
+		}
+		return ErrUnavailable
+	}); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("drain: expected ErrUnavailable, got %v", err)
+	}
+	if st, _ := b.Stats(); st.Entries != 1 || st.DeadLettered != 1 {
+		t.Fatalf("expected 1 entry and 1 dead letter, got %+v", st)
+	}
+
+	n, err = b.Requeue()
+	if err != nil || n != 1 {
+		t.Fatalf("requeue: %d, %v", n, err)
+	}
+	entries := walkEntries(t, b)
+	if len(entries) != 2 || entries[1].Format != FormatTelegram || entries[1].Attempts != 0 {
+		t.Errorf("expected requeued entry appended with reset attempts, got %+v", entries)
+	}
+	if st, _ := b.Stats(); st.DeadLettered != 0 {
+		t.Errorf("expected empty dead-letter file, got %d", st.DeadLettered)
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	b := New(t.TempDir())
+	e := Entry{Format: FormatHomeWizardV1, Payload: json.RawMessage(`{"a":1}`), LastError: "invalid meter payload"}
+	if err := b.DeadLetter(e); err != nil {
+		t.Fatalf("dead-letter: %v", err)
+	}
+	st, err := b.Stats()
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if st.Entries != 0 || st.DeadLettered != 1 {
+		t.Fatalf("expected only a dead letter, got %+v", st)
+	}
+	if n, err := b.Requeue(); err != nil || n != 1 {
+		t.Fatalf("requeue: %d, %v", n, err)
+	}
+	if entries := walkEntries(t, b); len(entries) != 1 || entries[0].LastError != "" {
+		t.Errorf("expected the entry back in the buffer, got %+v", entries)
+	}
+}
+
+func TestRequeueKeepsUnmovedDeadLetters(t *testing.T) {
+	b := New(t.TempDir())
+	for i := 1; i <= 3; i++ {
+		e := Entry{Format: FormatHomeWizardV1, Payload: json.RawMessage(fmt.Sprintf(`{"a":%d}`, i)), Attempts: 5}
+		if err := b.DeadLetter(e); err != nil {
+			t.Fatalf("dead-letter: %v", err)
+		}
+	}
+	// room for the first entry only
+	data, _ := json.Marshal(Entry{Format: FormatHomeWizardV1, Payload: json.RawMessage(`{"a":1}`)})
+	b.MaxSize = int64(len(encodeRecord(data))) + 1
+	b.Overflow = OverflowRejectNew
+
+	n, err := b.Requeue()
+	if !errors.Is(err, ErrFull) || n != 1 {
+		t.Fatalf("requeue: expected 1 moved and ErrFull, got %d, %v", n, err)
+	}
+	var left []Entry
+	b.WalkDeadLetters(func(e Entry) error {
+		left = append(left, e)
+		return nil
+	})
+	if len(left) != 2 || string(left[0].Payload) != `{"a":2}` || left[0].Attempts != 5 {
+		t.Fatalf("expected the 2 unmoved entries to stay dead-lettered, got %+v", left)
+	}
+	if entries := walkEntries(t, b); len(entries) != 1 {
+		t.Fatalf("expected 1 requeued entry, got %+v", entries)
+	}
+
+	// a second requeue does not duplicate the moved entry
+	b.MaxSize = 0
+	if n, err := b.Requeue(); err != nil || n != 2 {
+		t.Fatalf("requeue: %d, %v", n, err)
+	}
+	if entries := walkEntries(t, b); len(entries) != 3 {
+		t.Errorf("expected 3 entries, got %+v", entries)
+	}
+}
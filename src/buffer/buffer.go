@@ -4,80 +4,627 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"path/filepath"
 	"sync"
+	"time"
 )
 
-// Buffer persists items to a JSON-lines file for later draining
+// Defaults applied by New
+const (
+	DefaultMaxAttempts   = 5
+	DefaultSegmentSize   = 4 << 20
+	DefaultMaxSize       = 256 << 20
+	DefaultFsyncInterval = time.Second
+)
+
+// ErrUnavailable marks a persist error caused by the destination being down
+// rather than by the entry itself. Drain stops at such an error without
+// counting it against the entry, so an outage cannot dead-letter the backlog.
+var ErrUnavailable = errors.New("destination unavailable")
+
+// ErrFull is returned by Append under OverflowRejectNew when the buffer has
+// reached MaxSize
+var ErrFull = errors.New("buffer full")
+
+// FsyncPolicy decides when appended records are flushed to stable storage
+type FsyncPolicy string
+
+const (
+	// FsyncAlways syncs after every append (default)
+	FsyncAlways FsyncPolicy = "always"
+	// FsyncInterval syncs on append when FsyncInterval has passed since the
+	// last sync, bounding the loss on power failure to that window
+	FsyncInterval FsyncPolicy = "interval"
+	// FsyncNever leaves flushing to the OS
+	FsyncNever FsyncPolicy = "never"
+)
+
+// OverflowPolicy decides what Append does when the buffer reaches MaxSize
+type OverflowPolicy string
+
+const (
+	// OverflowDropOldest deletes the oldest segments to make room (default)
+	OverflowDropOldest OverflowPolicy = "drop_oldest"
+	// OverflowRejectNew keeps the backlog and fails the append with ErrFull
+	OverflowRejectNew OverflowPolicy = "reject_new"
+)
+
+// Payload formats recorded in an Entry
+const (
+	FormatHomeWizardV1 = "homewizard_v1"
+	FormatHomeWizardV2 = "homewizard_v2"
+	FormatTelegram     = "dsmr_telegram"
+)
+
+// Entry is one buffered reading. The envelope keeps the capture time so a
+// drained reading is stored with the time it was measured, not drained.
+type Entry struct {
+	CapturedAt time.Time       `json:"captured_at"`
+	Source     string          `json:"source,omitempty"`
+	Format     string          `json:"format"`
+	Payload    json.RawMessage `json:"payload"`
+	// Attempts and LastError record failed drains of this entry
+	Attempts  int    `json:"attempts,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// DrainSummary reports what a drain did with each entry it attempted
+type DrainSummary struct {
+	Persisted    int
+	Retried      int
+	DeadLettered int
+	// Corrupt counts records dropped for a checksum mismatch or a torn write
+	Corrupt int
+}
+
+func (s DrainSummary) String() string {
+	out := fmt.Sprintf("%d persisted, %d kept for retry, %d dead-lettered", s.Persisted, s.Retried, s.DeadLettered)
+	if s.Corrupt > 0 {
+		out += fmt.Sprintf(", %d corrupt dropped", s.Corrupt)
+	}
+	return out
+}
+
+// Buffer is a segmented write-ahead log of readings that could not be
+// persisted. Records are appended to the newest segment file in Dir, which is
+// rotated once it reaches SegmentSize. Each record carries a checksum, so a
+// torn write after a crash is detected and skipped instead of poisoning the
+// log. Draining streams one segment at a time and never holds the whole
+// backlog in memory. Several processes may share Dir: OS file locks in it
+// keep a drain or purge in one from losing appends made by another.
 type Buffer struct {
-	path string
-	mu   sync.Mutex
+	dir string
+
+	// SegmentSize is the size at which a new segment file is started
+	SegmentSize int64
+	// MaxSize caps the total size of all segments; 0 means unlimited
+	MaxSize int64
+	// Overflow decides what happens when MaxSize is reached
+	Overflow OverflowPolicy
+	// Fsync and FsyncInterval control durability of appends
+	Fsync         FsyncPolicy
+	FsyncInterval time.Duration
+	// MaxAttempts is how often an entry may fail before it is dead-lettered
+	MaxAttempts int
+	// DeadLetterPath receives entries that exceeded MaxAttempts
+	DeadLetterPath string
+
+	mu         sync.Mutex // guards segment files and the active segment, with the directory lock
+	drainMu    sync.Mutex // serializes drains, with the drain lock file
+	deadMu     sync.Mutex // guards the dead-letter file
+	active     string
+	activeSize int64
+	lastSync   time.Time
 }
 
-func New(path string) *Buffer {
-	return &Buffer{path: path}
+// New returns a Buffer storing its segments in dir with default settings. The
+// directory is created on first append.
+func New(dir string) *Buffer {
+	return &Buffer{
+		dir:            dir,
+		SegmentSize:    DefaultSegmentSize,
+		MaxSize:        DefaultMaxSize,
+		Overflow:       OverflowDropOldest,
+		Fsync:          FsyncAlways,
+		FsyncInterval:  DefaultFsyncInterval,
+		MaxAttempts:    DefaultMaxAttempts,
+		DeadLetterPath: filepath.Join(dir, "dead-letter.jsonl"),
+	}
 }
 
-// Append writes an arbitrary JSON-serializable object as a line
+// Dir is the directory holding the segment files
+func (b *Buffer) Dir() string {
+	return b.dir
+}
+
+// Append writes an arbitrary JSON-serializable object as a record
 func (b *Buffer) Append(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	rec := encodeRecord(data)
+
 	b.mu.Lock()
 	defer b.mu.Unlock()
-
-	f, err := os.OpenFile(b.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	unlock, err := b.lockDir()
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	defer unlock()
+	return b.appendRecord(rec)
+}
 
-	enc := json.NewEncoder(f)
-	if err := enc.Encode(v); err != nil {
+// appendRecord writes rec to the active segment; callers hold b.mu and the
+// directory lock
+func (b *Buffer) appendRecord(rec []byte) error {
+	// resolve the active segment first so makeRoom knows which one is
+	// about to be written
+	if err := b.ensureActive(int64(len(rec))); err != nil {
 		return err
 	}
-	return nil
+	if err := b.makeRoom(int64(len(rec))); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(b.dir, b.active), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(rec); err != nil {
+		f.Close()
+		return err
+	}
+	if b.shouldSync() {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+		b.lastSync = time.Now()
+	}
+	b.activeSize += int64(len(rec))
+	return f.Close()
 }
 
-// Drain reads all entries and attempts to persist them by calling persistFn.
-// On success, the buffer file is truncated. If persistFn returns error for an
-// entry, the entry is kept (not retried individually) and Drain returns error.
-func (b *Buffer) Drain(ctx context.Context, persistFn func(context.Context, json.RawMessage) error) error {
+// Sync flushes the active segment to stable storage. With FsyncInterval or
+// FsyncNever recent appends may still be in the page cache; call Sync before
+// the process exits.
+func (b *Buffer) Sync() error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-
-	f, err := os.OpenFile(b.path, os.O_RDONLY, 0)
+	if b.active == "" || b.activeSize == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(filepath.Join(b.dir, b.active), os.O_WRONLY, 0)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil // nothing to do
+			return nil
 		}
 		return err
 	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	b.lastSync = time.Now()
+	return f.Close()
+}
+
+// AppendEntry buffers payload in an Entry envelope
+func (b *Buffer) AppendEntry(e Entry) error {
+	if e.CapturedAt.IsZero() {
+		e.CapturedAt = time.Now().UTC()
+	}
+	return b.Append(e)
+}
+
+// ImportFile appends every line of a JSON-lines buffer file written by
+// earlier versions and removes the file. It returns the number of entries
+// imported; a missing file imports nothing.
+func (b *Buffer) ImportFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
 	defer f.Close()
 
+	n := 0
 	scanner := bufio.NewScanner(f)
-	var lines [][]byte
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
 	for scanner.Scan() {
-		line := append([]byte(nil), scanner.Bytes()...)
-		lines = append(lines, line)
+		e, err := decodeRecord(scanner.Bytes())
+		if err != nil {
+			continue
+		}
+		if err := b.Append(e); err != nil {
+			return n, err
+		}
+		n++
 	}
 	if err := scanner.Err(); err != nil {
+		return n, err
+	}
+	f.Close()
+	return n, os.Remove(path)
+}
+
+// Drain reads all entries and attempts to persist them by calling persistFn
+// with the raw payload of each entry. See DrainEntries.
+func (b *Buffer) Drain(ctx context.Context, persistFn func(context.Context, json.RawMessage) error) (DrainSummary, error) {
+	return b.DrainEntries(ctx, func(ctx context.Context, e Entry) error {
+		return persistFn(ctx, e.Payload)
+	})
+}
+
+// DrainEntries streams all entries, oldest first, and attempts to persist
+// each one by calling persistFn. Lines written before the envelope existed
+// are passed as an Entry with only Payload set.
+//
+// Persisted entries are removed. A failing entry stays in the buffer with its
+// attempt counter and last error updated, and once it has failed MaxAttempts
+// times it is moved to the dead-letter file instead. When persistFn returns
+// an error wrapping ErrUnavailable, or ctx is done, the drain stops and the
+// remaining entries are kept unchanged; the error is returned together with
+// the summary so far.
+//
+// The active segment is sealed when the drain starts, so appends made while
+// draining go to a new segment and are left for the next drain. A crash
+// mid-segment may persist an entry twice; the store's conflict policy makes
+// that harmless.
+func (b *Buffer) DrainEntries(ctx context.Context, persistFn func(context.Context, Entry) error) (DrainSummary, error) {
+	var summary DrainSummary
+	release, err := b.lockExclusive()
+	if err != nil {
+		return summary, err
+	}
+	defer release()
+
+	b.mu.Lock()
+	sealed, err := b.seal()
+	b.mu.Unlock()
+	if err != nil {
+		return summary, err
+	}
+	for _, seg := range sealed {
+		if err := b.drainSegment(ctx, seg, persistFn, &summary); err != nil {
+			return summary, err
+		}
+	}
+	return summary, nil
+}
+
+// Walk calls fn for every record in the buffer, oldest first, without
+// modifying it. Undecodable records are passed with a non-nil error.
+func (b *Buffer) Walk(fn func(segment string, e Entry, err error) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	segs, err := b.segments()
+	if err != nil {
+		return err
+	}
+	for _, seg := range segs {
+		f, err := os.Open(filepath.Join(b.dir, seg))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		err = readRecords(f, func(_ []byte, e Entry, rerr error) error {
+			return fn(seg, e, rerr)
+		})
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drainSegment persists the records of one sealed segment and rewrites it
+// with the entries that have to stay
+func (b *Buffer) drainSegment(ctx context.Context, seg string, persistFn func(context.Context, Entry) error, summary *DrainSummary) error {
+	path := filepath.Join(b.dir, seg)
+	in, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // evicted meanwhile
+		}
+		return err
+	}
+	defer in.Close()
+
+	tmpPath := path + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
 		return err
 	}
+	w := bufio.NewWriter(out)
+	kept := 0
+	var dead []Entry
+	var stopErr error
 
-	// attempt to persist all lines
-	for _, l := range lines {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
+	err = readRecords(in, func(line []byte, e Entry, rerr error) error {
+		if rerr != nil {
+			log.Printf("buffer: dropping record in %s: %v\n", seg, rerr)
+			summary.Corrupt++
+			return nil
 		}
-		if err := persistFn(ctx, json.RawMessage(l)); err != nil {
-			return fmt.Errorf("persist line: %w", err)
+		if stopErr == nil {
+			stopErr = ctx.Err()
 		}
+		if stopErr == nil {
+			perr := persistFn(ctx, e)
+			switch {
+			case perr == nil:
+				summary.Persisted++
+				return nil
+			case errors.Is(perr, ErrUnavailable):
+				stopErr = fmt.Errorf("persist entry: %w", perr)
+			default:
+				e.Attempts++
+				e.LastError = perr.Error()
+				if b.MaxAttempts > 0 && e.Attempts >= b.MaxAttempts {
+					summary.DeadLettered++
+					dead = append(dead, e)
+					return nil
+				}
+				summary.Retried++
+				data, err := json.Marshal(e)
+				if err != nil {
+					return err
+				}
+				line = encodeRecord(data)
+			}
+		}
+		kept++
+		_, err := w.Write(line)
+		return err
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	in.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
 	}
 
-	// truncate file on success
-	if err := os.Truncate(b.path, 0); err != nil {
+	b.deadMu.Lock()
+	defer b.deadMu.Unlock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	unlock, err := b.lockDir()
+	if err != nil {
+		os.Remove(tmpPath)
 		return err
 	}
+	defer unlock()
+
+	// dead-letter first: a crash in between duplicates an entry, never loses it
+	if len(dead) > 0 {
+		if err := appendLines(b.DeadLetterPath, dead); err != nil {
+			os.Remove(tmpPath)
+			return fmt.Errorf("write dead-letter file: %w", err)
+		}
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		// evicted while draining; do not resurrect it
+		return os.Remove(tmpPath)
+	}
+	if kept == 0 {
+		os.Remove(tmpPath)
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		return stopErr
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return stopErr
+}
+
+// seal starts a new active segment if the current one holds records and
+// returns every segment before it. The new segment is created right away, so
+// other processes append to it as well. Callers hold b.mu.
+func (b *Buffer) seal() ([]string, error) {
+	unlock, err := b.lockDir()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	segs, err := b.segments()
+	if err != nil || len(segs) == 0 {
+		return nil, err
+	}
+	if err := b.ensureActive(0); err != nil {
+		return nil, err
+	}
+	if b.activeSize > 0 {
+		b.rotate()
+		f, err := os.OpenFile(filepath.Join(b.dir, b.active), os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+	}
+	var sealed []string
+	for _, s := range segs {
+		if s < b.active {
+			sealed = append(sealed, s)
+		}
+	}
+	return sealed, nil
+}
+
+// ensureActive picks the segment the next record of size n is appended to,
+// rotating when it would exceed SegmentSize or its last write was torn
+func (b *Buffer) ensureActive(n int64) error {
+	if b.active == "" {
+		segs, err := b.segments()
+		if err != nil {
+			return err
+		}
+		if len(segs) == 0 {
+			b.active, b.activeSize = segmentName(1), 0
+			return nil
+		}
+		b.active = segs[len(segs)-1]
+		torn, size, err := tornTail(filepath.Join(b.dir, b.active))
+		if err != nil {
+			return err
+		}
+		b.activeSize = size
+		if torn {
+			b.rotate()
+		}
+	}
+	if b.activeSize > 0 && b.activeSize+n > b.SegmentSize && b.SegmentSize > 0 {
+		b.rotate()
+	}
 	return nil
 }
+
+// rotate switches appends to the next segment, syncing the previous one
+// unless fsync is disabled
+func (b *Buffer) rotate() {
+	if b.Fsync != FsyncNever && b.activeSize > 0 {
+		if f, err := os.OpenFile(filepath.Join(b.dir, b.active), os.O_WRONLY, 0); err == nil {
+			f.Sync()
+			f.Close()
+		}
+	}
+	b.active, b.activeSize = segmentName(segmentSeq(b.active)+1), 0
+}
+
+// makeRoom applies the overflow policy so a record of n bytes fits MaxSize.
+// drop_oldest evicts whole segments, oldest first; when only the active
+// segment is left, e.g. with SegmentSize above MaxSize, appends move on to a
+// new segment so it can be evicted too. A record larger than MaxSize is
+// refused.
+func (b *Buffer) makeRoom(n int64) error {
+	if b.MaxSize <= 0 {
+		return nil
+	}
+	segs, err := b.segments()
+	if err != nil {
+		return err
+	}
+	sizes := make([]int64, len(segs))
+	var total int64
+	for i, s := range segs {
+		if fi, err := os.Stat(filepath.Join(b.dir, s)); err == nil {
+			sizes[i] = fi.Size()
+			total += sizes[i]
+		}
+	}
+	if total+n <= b.MaxSize {
+		return nil
+	}
+	if b.Overflow == OverflowRejectNew {
+		return fmt.Errorf("%w: %d of %d bytes used", ErrFull, total, b.MaxSize)
+	}
+	for i, s := range segs {
+		if total+n <= b.MaxSize {
+			break
+		}
+		if s == b.active {
+			b.rotate()
+		}
+		if err := os.Remove(filepath.Join(b.dir, s)); err != nil {
+			log.Printf("buffer: evict %s: %v\n", s, err)
+			continue
+		}
+		log.Printf("buffer: size cap %d bytes reached, evicted oldest segment %s (%d bytes)\n", b.MaxSize, s, sizes[i])
+		total -= sizes[i]
+	}
+	if total+n > b.MaxSize {
+		return fmt.Errorf("%w: record of %d bytes exceeds %d bytes", ErrFull, n, b.MaxSize)
+	}
+	return nil
+}
+
+func (b *Buffer) shouldSync() bool {
+	switch b.Fsync {
+	case FsyncNever:
+		return false
+	case FsyncInterval:
+		return time.Since(b.lastSync) >= b.FsyncInterval
+	default:
+		return true
+	}
+}
+
+// segments lists the segment file names in dir, oldest first
+func (b *Buffer) segments() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(b.dir, "*"+segmentExt))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(matches))
+	for i, m := range matches {
+		names[i] = filepath.Base(m)
+	}
+	// zero-padded names sort in sequence order; Glob returns them sorted
+	return names, nil
+}
+
+// tornTail reports whether the file at path ends in an incomplete record
+func tornTail(path string) (bool, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, 0, nil
+		}
+		return false, 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.Size() == 0 {
+		return false, 0, err
+	}
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, fi.Size()-1); err != nil && err != io.EOF {
+		return false, 0, err
+	}
+	return last[0] != '\n', fi.Size(), nil
+}
+
+func appendLines(path string, entries []Entry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
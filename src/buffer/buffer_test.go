@@ -3,15 +3,19 @@ package buffer
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestAppendAndDrain(t *testing.T) {
 	tmp := filepath.Join(os.TempDir(), "p1-buffer-test.jsonl")
-	os.Remove(tmp)
-	defer os.Remove(tmp)
+	os.RemoveAll(tmp)
+	defer os.RemoveAll(tmp)
 
 	b := New(tmp)
 	sample := map[string]interface{}{"a": 1}
@@ -25,10 +29,177 @@ func TestAppendAndDrain(t *testing.T) {
 		return nil
 	}
 
-	if err := b.Drain(context.Background(), persistFn); err != nil {
+	if _, err := b.Drain(context.Background(), persistFn); err != nil {
 		t.Fatalf("drain: %v", err)
 	}
 	if called != 1 {
 		t.Fatalf("expected called once, got %d", called)
 	}
 }
+
+func TestDrainEntries_EnvelopeAndLegacy(t *testing.T) {
+	b := New(t.TempDir())
+
+	captured := time.Date(2025, 6, 2, 3, 15, 0, 0, time.UTC)
+	if err := b.AppendEntry(Entry{CapturedAt: captured, Source: "http://meter/api/v1/data", Format: FormatHomeWizardV1, Payload: json.RawMessage(`{"a":1}`)}); err != nil {
+		t.Fatalf("append entry: %v", err)
+	}
+	// a line written before the envelope existed
+	if err := b.Append(map[string]interface{}{"a": 2}); err != nil {
+		t.Fatalf("append legacy: %v", err)
+	}
+
+	var got []Entry
+	if _, err := b.DrainEntries(context.Background(), func(ctx context.Context, e Entry) error {
+		got = append(got, e)
+		return nil
+	}); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(got))
+	}
+	if !got[0].CapturedAt.Equal(captured) || got[0].Format != FormatHomeWizardV1 || string(got[0].Payload) != `{"a":1}` {
+		t.Errorf("unexpected envelope entry: %+v", got[0])
+	}
+	if !got[1].CapturedAt.IsZero() || got[1].Format != "" || string(got[1].Payload) != `{"a":2}` {
+		t.Errorf("unexpected legacy entry: %+v", got[1])
+	}
+}
+
+func TestDrainEntries_PartialAndDeadLetter(t *testing.T) {
+	b := New(t.TempDir())
+	b.MaxAttempts = 2
+	for _, p := range []string{`"good"`, `"poison"`, `"good"`} {
+		if err := b.AppendEntry(Entry{Format: FormatTelegram, Payload: json.RawMessage(p)}); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	persist := func(ctx context.Context, e Entry) error {
+		if string(e.Payload) == `"poison"` {
+			return errors.New("cannot parse")
+		}
+		return nil
+	}
+
+	summary, err := b.DrainEntries(context.Background(), persist)
+	if err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	if summary != (DrainSummary{Persisted: 2, Retried: 1}) {
+		t.Fatalf("unexpected first summary: %+v", summary)
+	}
+	entries := walkEntries(t, b)
+	if len(entries) != 1 || entries[0].Attempts != 1 || entries[0].LastError != "cannot parse" {
+		t.Fatalf("expected poisoned entry kept with retry state, got %+v", entries)
+	}
+
+	summary, err = b.DrainEntries(context.Background(), persist)
+	if err != nil {
+		t.Fatalf("second drain: %v", err)
+	}
+	if summary != (DrainSummary{DeadLettered: 1}) {
+		t.Fatalf("unexpected second summary: %+v", summary)
+	}
+	if entries := walkEntries(t, b); len(entries) != 0 {
+		t.Errorf("expected empty buffer, got %+v", entries)
+	}
+	dead, err := os.ReadFile(b.DeadLetterPath)
+	if err != nil || !strings.Contains(string(dead), `"attempts":2`) {
+		t.Errorf("expected entry in dead-letter file, got %q (%v)", dead, err)
+	}
+}
+
+func TestDrainEntries_StopsWhenUnavailable(t *testing.T) {
+	b := New(t.TempDir())
+	for i := 0; i < 3; i++ {
+		if err := b.AppendEntry(Entry{Format: FormatTelegram, Payload: json.RawMessage(`"x"`)}); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	calls := 0
+	summary, err := b.DrainEntries(context.Background(), func(ctx context.Context, e Entry) error {
+		calls++
+		if calls == 2 {
+			return fmt.Errorf("%w: connection refused", ErrUnavailable)
+		}
+		return nil
+	})
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("expected ErrUnavailable, got %v", err)
+	}
+	if summary.Persisted != 1 || calls != 2 {
+		t.Errorf("expected drain to stop after first failure, summary %+v, calls %d", summary, calls)
+	}
+	entries := walkEntries(t, b)
+	if len(entries) != 2 || entries[0].Attempts != 0 {
+		t.Errorf("expected 2 untouched entries, got %+v", entries)
+	}
+}
+
+func walkEntries(t *testing.T, b *Buffer) []Entry {
+	t.Helper()
+	var entries []Entry
+	if err := b.Walk(func(_ string, e Entry, err error) error {
+		if err != nil {
+			t.Errorf("unexpected bad record: %v", err)
+		}
+		entries = append(entries, e)
+		return nil
+	}); err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+	return entries
+}
+
+func TestSync_FlushesDeferredAppends(t *testing.T) {
+	b := New(t.TempDir())
+	b.Fsync = FsyncNever
+	if err := b.Sync(); err != nil {
+		t.Fatalf("sync empty buffer: %v", err)
+	}
+	if err := b.Append(map[string]int{"a": 1}); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	if err := b.Sync(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if n := len(walkEntries(t, b)); n != 1 {
+		t.Fatalf("expected 1 entry after sync, got %d", n)
+	}
+}
+
+// Two Buffers on one directory stand in for two processes: the locks are
+// taken on separately opened files, which conflict like those of another
+// process would.
+func TestDrainEntries_KeepsAppendsOfAnotherProcess(t *testing.T) {
+	dir := t.TempDir()
+	collector, cli := New(dir), New(dir)
+	if err := collector.AppendEntry(Entry{Format: FormatHomeWizardV1, Payload: json.RawMessage(`{"n":1}`)}); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+
+	var drained []string
+	summary, err := cli.DrainEntries(context.Background(), func(ctx context.Context, e Entry) error {
+		drained = append(drained, string(e.Payload))
+		// the collector buffers a reading while the other process drains
+		if err := collector.AppendEntry(Entry{Format: FormatHomeWizardV1, Payload: json.RawMessage(`{"n":2}`)}); err != nil {
+			t.Fatalf("append while draining: %v", err)
+		}
+		// and cannot drain at the same time
+		if _, err := collector.DrainEntries(ctx, func(context.Context, Entry) error { return nil }); !errors.Is(err, ErrLocked) {
+			t.Errorf("expected ErrLocked for a concurrent drain, got %v", err)
+		}
+		if _, err := collector.Purge(time.Now()); !errors.Is(err, ErrLocked) {
+			t.Errorf("expected ErrLocked for a concurrent purge, got %v", err)
+		}
+		return nil
+	})
+	if err != nil || summary.Persisted != 1 || len(drained) != 1 {
+		t.Fatalf("drain: %+v, %v (drained %v)", summary, err, drained)
+	}
+	entries := walkEntries(t, cli)
+	if len(entries) != 1 || string(entries[0].Payload) != `{"n":2}` {
+		t.Fatalf("expected the append made during the drain to survive, got %+v", entries)
+	}
+}
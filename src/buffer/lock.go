@@ -0,0 +1,71 @@
+package buffer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// ErrLocked is returned by drains, Purge and Requeue while another process
+// drains or rewrites the same buffer directory
+var ErrLocked = errors.New("buffer is being drained or rewritten by another process")
+
+// Lock files in the buffer directory, shared by every process using it
+const (
+	// dirLockName is held briefly while segments are appended to, sealed,
+	// evicted or replaced
+	dirLockName = ".lock"
+	// drainLockName is held for a whole drain, purge or requeue
+	drainLockName = ".drain.lock"
+)
+
+// lockDir takes the directory lock, waiting for other processes, and returns
+// its release function. Another process may have sealed or evicted the
+// active segment meanwhile, so it is resolved again on the next append.
+// Callers hold b.mu.
+func (b *Buffer) lockDir() (func(), error) {
+	release, _, err := b.lockFile(dirLockName, true)
+	if err != nil {
+		return nil, err
+	}
+	b.active, b.activeSize = "", 0
+	return release, nil
+}
+
+// lockExclusive serializes drains and rewrites within the process and, through
+// the drain lock file, with other processes; it returns ErrLocked when another
+// process holds it
+func (b *Buffer) lockExclusive() (func(), error) {
+	b.drainMu.Lock()
+	release, got, err := b.lockFile(drainLockName, false)
+	if err == nil && !got {
+		err = ErrLocked
+	}
+	if err != nil {
+		b.drainMu.Unlock()
+		return nil, err
+	}
+	return func() {
+		release()
+		b.drainMu.Unlock()
+	}, nil
+}
+
+func (b *Buffer) lockFile(name string, wait bool) (func(), bool, error) {
+	if err := os.MkdirAll(b.dir, 0755); err != nil {
+		return nil, false, err
+	}
+	f, err := os.OpenFile(filepath.Join(b.dir, name), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+	got, err := lockFile(f, wait)
+	if err != nil || !got {
+		f.Close()
+		return nil, false, err
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, true, nil
+}
@@ -0,0 +1,103 @@
+package buffer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	segmentExt = ".wal"
+	// maxRecordSize bounds a single line when importing legacy files
+	maxRecordSize = 1 << 20
+)
+
+// ErrCorruptRecord is reported for a record whose checksum does not match
+var ErrCorruptRecord = errors.New("corrupt buffer record")
+
+// ErrTornRecord is reported for a final record without its trailing newline,
+// the result of a crash during the write
+var ErrTornRecord = errors.New("torn buffer record")
+
+// encodeRecord frames data as one WAL line: the CRC-32 of data in hex, a
+// space, the JSON and a newline
+func encodeRecord(data []byte) []byte {
+	rec := make([]byte, 0, len(data)+10)
+	rec = append(rec, fmt.Sprintf("%08x ", crc32.ChecksumIEEE(data))...)
+	rec = append(rec, data...)
+	return append(rec, '\n')
+}
+
+// decodeRecord verifies and decodes a line without its newline. Lines
+// without a checksum prefix were written by the single-file buffer and are
+// accepted if they are valid JSON.
+func decodeRecord(line []byte) (Entry, error) {
+	line = bytes.TrimRight(line, "\r\n")
+	if len(line) > 9 && line[8] == ' ' {
+		if sum, err := strconv.ParseUint(string(line[:8]), 16, 32); err == nil {
+			data := line[9:]
+			if crc32.ChecksumIEEE(data) != uint32(sum) {
+				return Entry{}, ErrCorruptRecord
+			}
+			return decodeEntry(data), nil
+		}
+	}
+	if !json.Valid(line) {
+		return Entry{}, ErrCorruptRecord
+	}
+	return decodeEntry(line), nil
+}
+
+// decodeEntry unwraps an envelope, or wraps a legacy raw payload
+func decodeEntry(data []byte) Entry {
+	var e Entry
+	if err := json.Unmarshal(data, &e); err == nil && len(e.Payload) > 0 {
+		return e
+	}
+	return Entry{Payload: json.RawMessage(append([]byte(nil), data...))}
+}
+
+// readRecords streams the records of r to fn together with the raw line
+// (including its newline). Undecodable records are passed with a non-nil
+// error; fn decides whether to skip them.
+func readRecords(r io.Reader, fn func(line []byte, e Entry, err error) error) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			var e Entry
+			var derr error
+			if err == io.EOF {
+				derr = ErrTornRecord
+			} else if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			} else {
+				e, derr = decodeRecord(line)
+			}
+			if ferr := fn(line, e, derr); ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func segmentName(seq uint64) string {
+	return fmt.Sprintf("%016d%s", seq, segmentExt)
+}
+
+func segmentSeq(name string) uint64 {
+	seq, _ := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+	return seq
+}
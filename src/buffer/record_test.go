@@ -0,0 +1,162 @@
+package buffer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDecodeRecord(t *testing.T) {
+	rec := encodeRecord([]byte(`{"format":"dsmr_telegram","payload":"x"}`))
+	e, err := decodeRecord(rec)
+	if err != nil || e.Format != FormatTelegram {
+		t.Fatalf("decode valid record: %+v, %v", e, err)
+	}
+
+	rec[12] ^= 0x01
+	if _, err := decodeRecord(rec); err != ErrCorruptRecord {
+		t.Errorf("expected ErrCorruptRecord for flipped byte, got %v", err)
+	}
+
+	// a line from the single-file buffer, without checksum
+	e, err = decodeRecord([]byte(`{"active_power_w":120}`))
+	if err != nil || string(e.Payload) != `{"active_power_w":120}` {
+		t.Errorf("expected legacy line accepted, got %+v, %v", e, err)
+	}
+}
+
+func TestDrainEntries_TornTailAndCorruptRecord(t *testing.T) {
+	b := New(t.TempDir())
+	for _, p := range []string{`"a"`, `"b"`, `"c"`} {
+		if err := b.AppendEntry(Entry{Format: FormatTelegram, Payload: json.RawMessage(p)}); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	seg := filepath.Join(b.Dir(), segmentName(1))
+	data, _ := os.ReadFile(seg)
+	// corrupt the second record and simulate a crash halfway through a fourth
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	lines[1][20] ^= 0x01
+	torn := encodeRecord([]byte(`{"format":"dsmr_telegram","payload":"d"}`))
+	data = append(bytes.Join(lines, nil), torn[:len(torn)/2]...)
+	if err := os.WriteFile(seg, data, 0644); err != nil {
+		t.Fatalf("write segment: %v", err)
+	}
+
+	// a fresh Buffer must not append after the torn record
+	b = New(b.Dir())
+	if err := b.AppendEntry(Entry{Format: FormatTelegram, Payload: json.RawMessage(`"e"`)}); err != nil {
+		t.Fatalf("append after crash: %v", err)
+	}
+
+	var got []string
+	summary, err := b.DrainEntries(context.Background(), func(ctx context.Context, e Entry) error {
+		got = append(got, string(e.Payload))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	if summary.Persisted != 3 || summary.Corrupt != 2 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+	if len(got) != 3 || got[0] != `"a"` || got[1] != `"c"` || got[2] != `"e"` {
+		t.Errorf("unexpected drained payloads: %v", got)
+	}
+}
+
+func TestAppend_RotatesAndEvictsOldest(t *testing.T) {
+	b := New(t.TempDir())
+	rec := encodeRecord(mustMarshal(t, Entry{Format: FormatTelegram, Payload: json.RawMessage(`"xxxxxxxxxxxxxxxx"`)}))
+	b.SegmentSize = int64(len(rec)) * 2
+	b.MaxSize = int64(len(rec)) * 5
+	for i := 0; i < 8; i++ {
+		// fixed capture time keeps every record the same size
+		if err := b.Append(Entry{Format: FormatTelegram, Payload: json.RawMessage(`"xxxxxxxxxxxxxxxx"`)}); err != nil {
+			t.Fatalf("append %d: %v", i, err)
+		}
+	}
+	segs, _ := b.segments()
+	if len(segs) != 2 || segs[0] != segmentName(3) {
+		t.Fatalf("expected the two oldest segments evicted, got %v", segs)
+	}
+
+	b.Overflow = OverflowRejectNew
+	b.MaxSize = int64(len(rec)) * 4
+	if err := b.Append(Entry{Format: FormatTelegram, Payload: json.RawMessage(`"xxxxxxxxxxxxxxxx"`)}); !errors.Is(err, ErrFull) {
+		t.Errorf("expected ErrFull, got %v", err)
+	}
+}
+
+func TestAppend_SegmentLargerThanCap(t *testing.T) {
+	dir := t.TempDir()
+	b := New(dir)
+	entry := func(i int) Entry {
+		return Entry{Format: FormatTelegram, Payload: json.RawMessage(fmt.Sprintf(`"record-%d"`, i))}
+	}
+	rec := encodeRecord(mustMarshal(t, entry(0)))
+	b.SegmentSize = int64(len(rec)) * 10
+	b.MaxSize = int64(len(rec)) * 3
+	for i := 0; i < 5; i++ {
+		if err := b.Append(entry(i)); err != nil {
+			t.Fatalf("append %d: %v", i, err)
+		}
+	}
+	checkCap(t, b)
+
+	// a new process resolves the active segment before making room
+	b = New(dir)
+	b.SegmentSize = int64(len(rec)) * 10
+	b.MaxSize = int64(len(rec)) * 3
+	if err := b.Append(entry(5)); err != nil {
+		t.Fatalf("append after reopen: %v", err)
+	}
+
+	checkCap(t, b)
+	var last string
+	if _, err := b.DrainEntries(context.Background(), func(_ context.Context, e Entry) error {
+		last = string(e.Payload)
+		return nil
+	}); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	if last != `"record-5"` {
+		t.Errorf("expected the newest record kept, last drained %s", last)
+	}
+
+	b.MaxSize = int64(len(rec)) / 2
+	if err := b.Append(entry(6)); !errors.Is(err, ErrFull) {
+		t.Errorf("expected ErrFull for a record above the cap, got %v", err)
+	}
+}
+
+// checkCap fails the test when the segments of b exceed MaxSize
+func checkCap(t *testing.T, b *Buffer) {
+	t.Helper()
+	var total int64
+	segs, _ := b.segments()
+	for _, s := range segs {
+		fi, err := os.Stat(filepath.Join(b.Dir(), s))
+		if err != nil {
+			t.Fatalf("stat: %v", err)
+		}
+		total += fi.Size()
+	}
+	if total > b.MaxSize {
+		t.Errorf("buffer holds %d bytes, above the cap of %d", total, b.MaxSize)
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return data
+}
@@ -5,11 +5,60 @@ import (
 	"os"
 )
 
+// StorageConfig selects the persistence backend
+type StorageConfig struct {
+	// Driver is a registered storage driver name ("postgres" by default)
+	Driver string `json:"driver"`
+	// DSN is passed to the driver; for postgres it falls back to db_dsn
+	DSN string `json:"dsn"`
+}
+
+// BufferConfig configures the write-ahead log that holds readings while the
+// database is unreachable. Zero values select the buffer package defaults.
+type BufferConfig struct {
+	// Dir holds the segment files (default ./buffer)
+	Dir              string `json:"dir"`
+	SegmentSizeBytes int64  `json:"segment_size_bytes"`
+	MaxSizeBytes     int64  `json:"max_size_bytes"`
+	// Fsync is "always", "interval" or "never"
+	Fsync           string `json:"fsync"`
+	FsyncIntervalMs int    `json:"fsync_interval_ms"`
+	// Overflow is "drop_oldest" or "reject_new"
+	Overflow    string `json:"overflow"`
+	MaxAttempts int    `json:"max_attempts"`
+}
+
+// CapacityTariffConfig prices the Belgian capacity tariff for `metercli
+// peaks`. Zero values leave the cost out and bill at least 2.5 kW.
+type CapacityTariffConfig struct {
+	// RatePerKWYear is the grid operator's rate in currency per kW per year
+	RatePerKWYear float64 `json:"rate_per_kw_year"`
+	MinimumKW     float64 `json:"minimum_kw"`
+}
+
 // Config holds runtime configuration for the CLI
 type Config struct {
 	MeterEndpoint string `json:"meter_endpoint"`
 	DBDSN         string `json:"db_dsn"`
 	DataDir       string `json:"data_dir"`
+	// ImportMeterID is the meter id stamped on readings imported from CSV,
+	// which carries none
+	ImportMeterID string `json:"import_meter_id"`
+	// SerialDevice switches ingestion from the HTTP endpoint to a P1 serial
+	// device (e.g. /dev/ttyUSB0) emitting raw DSMR telegrams.
+	SerialDevice string `json:"serial_device"`
+	SerialBaud   int    `json:"serial_baud"`
+	// MeterAPIVersion selects the HomeWizard API. Version 2 uses
+	// MeterEndpoint as the HTTPS base URL and authenticates with MeterToken,
+	// pinning the device certificate to MeterCertSHA256.
+	MeterAPIVersion int    `json:"meter_api_version"`
+	MeterToken      string `json:"meter_token"`
+	MeterCertSHA256 string `json:"meter_cert_sha256"`
+
+	Storage StorageConfig `json:"storage"`
+	Buffer  BufferConfig  `json:"buffer"`
+
+	CapacityTariff CapacityTariffConfig `json:"capacity_tariff"`
 }
 
 // Load reads a JSON config file from path and unmarshals into Config
@@ -24,3 +73,12 @@ func Load(path string) (Config, error) {
 	}
 	return cfg, nil
 }
+
+// Save writes cfg to path as indented JSON
+func Save(path string, cfg Config) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
@@ -6,6 +6,7 @@ import "time"
 type Reading struct {
 	ID                    int64     `db:"id"`
 	CreatedAt             time.Time `db:"created_at"`
+	MeterID               string    `db:"meter_id"` // meter unique id; with CreatedAt the natural key
 	ActiveTariff          int       `db:"active_tariff"`
 	TotalPowerImportKwh   float64   `db:"total_power_import_kwh"`
 	TotalPowerImportT1Kwh float64   `db:"total_power_import_t1_kwh"`
@@ -34,4 +35,102 @@ type Reading struct {
 	LongPowerFailCount    int       `db:"long_power_fail_count"`
 	TotalGasM3            float64   `db:"total_gas_m3"`
 	GasTimestamp          int64     `db:"gas_timestamp"`
+	// GasMeasuredAt is GasTimestamp decoded from Dutch local time; zero
+	// when the source has no gas meter
+	GasMeasuredAt time.Time `db:"gas_measured_at"`
+
+	// Belgian capacity tariff: the average import demand of the current
+	// quarter-hour, and the highest quarter-hour average of the month with
+	// its raw YYMMDDhhmmss timestamp and the decoded time
+	ActivePowerAverageW       float64   `db:"active_power_average_w"`
+	MonthlyPowerPeakW         float64   `db:"monthly_power_peak_w"`
+	MonthlyPowerPeakTimestamp int64     `db:"monthly_power_peak_timestamp"`
+	MonthlyPowerPeakAt        time.Time `db:"monthly_power_peak_at"`
+
+	// Device describes the meter, when the source reports it; stored in
+	// p1.devices keyed by MeterID
+	Device *Device `db:"-"`
+	// External holds the M-Bus sub-meters (gas, water, heat) read with this
+	// reading; stored in p1.external_readings
+	External []ExternalReading `db:"-"`
+}
+
+// Device is the meter metadata matching p1.devices. WifiSSID and
+// WifiStrength are empty for meters read over the serial port.
+type Device struct {
+	UniqueID     string `db:"unique_id"`
+	Model        string `db:"meter_model"`
+	SMRVersion   int    `db:"smr_version"`
+	WifiSSID     string `db:"wifi_ssid"`
+	WifiStrength int    `db:"wifi_strength"` // percent
+}
+
+// ExternalReading is one entry of a meter's external array matching
+// p1.external_readings
+type ExternalReading struct {
+	UniqueID string `db:"unique_id"`
+	// Type is the HomeWizard device type, e.g. "gas_meter" or "water_meter"
+	Type string `db:"type"`
+	// Timestamp is when the sub-meter took the value, as YYMMDDhhmmss like
+	// GasTimestamp
+	Timestamp int64   `db:"timestamp"`
+	Value     float64 `db:"value"`
+	Unit      string  `db:"unit"`
+}
+
+// Kinds of sub-meter, the per-type series of p1.external_readings
+const (
+	KindGas       = "gas"
+	KindWater     = "water"
+	KindHeat      = "heat"
+	KindWarmWater = "warm_water"
+)
+
+// Kind classifies the sub-meter by its type and, for types this version does
+// not know, by its unit. It returns "" when neither identifies it; m3 alone
+// could be gas as well as water.
+func (e ExternalReading) Kind() string {
+	switch e.Type {
+	case "gas_meter":
+		return KindGas
+	case "water_meter":
+		return KindWater
+	case "warm_water_meter":
+		return KindWarmWater
+	case "heat_meter", "inlet_heat_meter":
+		return KindHeat
+	}
+	switch e.Unit {
+	case "GJ", "MJ":
+		return KindHeat
+	}
+	return ""
+}
+
+// Demand is the average import demand of a meter over one quarter-hour,
+// matching p1.quarter_hour_demand
+type Demand struct {
+	MeterID      string    `db:"meter_id"`
+	QuarterStart time.Time `db:"quarter_start"`
+	AverageW     float64   `db:"average_w"`
+}
+
+// PowerPeak is a monthly peak reported by a Belgian meter (1-0:1.6.0)
+type PowerPeak struct {
+	MeterID string    `db:"meter_id"`
+	At      time.Time `db:"monthly_power_peak_at"`
+	PeakW   float64   `db:"monthly_power_peak_w"`
+}
+
+// PeriodCounter is the first and last value of one counter series within a
+// report period. Series is import, export, gas or a sub-meter kind; UniqueID
+// and Unit are only set for sub-meters.
+type PeriodCounter struct {
+	PeriodStart time.Time
+	MeterID     string
+	Series      string
+	UniqueID    string
+	Unit        string
+	First       float64
+	Last        float64
 }
@@ -0,0 +1,226 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule matches activations field by field; each field is a bitset
+type cronSchedule struct {
+	second, minute, hour, dom, month, dow uint64
+	// cron runs a day when either day field matches if both are restricted
+	domStar, dowStar bool
+	loc              *time.Location
+}
+
+type cronField struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	secondField = cronField{min: 0, max: 59}
+	minuteField = cronField{min: 0, max: 59}
+	hourField   = cronField{min: 0, max: 23}
+	domField    = cronField{min: 1, max: 31}
+	monthField  = cronField{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 is accepted for Sunday and folded onto 0
+	dowField = cronField{min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a cron expression with five fields (minute hour
+// day-of-month month day-of-week) or six with leading seconds. Fields
+// accept *, ?, lists, ranges, steps and month and weekday names. A
+// "TZ=Europe/Amsterdam " (or CRON_TZ=) prefix evaluates the expression in
+// that zone instead of the zone of the time passed to Next.
+func ParseCron(expr string) (Schedule, error) {
+	s := &cronSchedule{}
+	spec := strings.TrimSpace(expr)
+	for _, prefix := range []string{"TZ=", "CRON_TZ="} {
+		if rest, ok := strings.CutPrefix(spec, prefix); ok {
+			name, rest, _ := strings.Cut(rest, " ")
+			loc, err := time.LoadLocation(name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+			}
+			s.loc, spec = loc, strings.TrimSpace(rest)
+		}
+	}
+	if d, ok := cronDescriptors[spec]; ok {
+		spec = d
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("invalid cron expression %q: want 5 or 6 fields, got %d", expr, len(fields))
+	}
+
+	var err error
+	parse := func(f string, field cronField) uint64 {
+		if err != nil {
+			return 0
+		}
+		var bits uint64
+		bits, err = parseCronField(f, field)
+		return bits
+	}
+	s.second = parse(fields[0], secondField)
+	s.minute = parse(fields[1], minuteField)
+	s.hour = parse(fields[2], hourField)
+	s.dom = parse(fields[3], domField)
+	s.month = parse(fields[4], monthField)
+	s.dow = parse(fields[5], dowField)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	s.domStar = strings.HasPrefix(fields[3], "*") || strings.HasPrefix(fields[3], "?")
+	s.dowStar = strings.HasPrefix(fields[5], "*") || strings.HasPrefix(fields[5], "?")
+	return s, nil
+}
+
+// parseCronField turns one comma-separated field into a bitset
+func parseCronField(f string, field cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(f, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+			step = n
+		}
+
+		lo, hi := field.min, field.max
+		switch {
+		case rng == "*" || rng == "?":
+		case strings.Contains(rng, "-"):
+			a, b, _ := strings.Cut(rng, "-")
+			var err error
+			if lo, err = field.value(a); err != nil {
+				return 0, err
+			}
+			if hi, err = field.value(b); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		default:
+			v, err := field.value(rng)
+			if err != nil {
+				return 0, err
+			}
+			lo = v
+			// "5/10" means every 10 starting at 5
+			if !hasStep {
+				hi = v
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", v, f.min, f.max)
+	}
+	return v, nil
+}
+
+// Next advances field by field from the largest unit, restarting whenever a
+// unit wraps into the next larger one. It gives up after five years, which
+// only happens for expressions like "0 0 30 2 *" that never match. Hours,
+// minutes and seconds advance by adding absolute time rather than setting
+// clock fields, so the hour that occurs twice when the clocks go back is
+// run through twice instead of resolving to one of its offsets.
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	if s.loc != nil {
+		loc = s.loc
+	}
+	orig := t.Location()
+	t = t.In(loc)
+	t = t.Add(-time.Duration(t.Nanosecond())).Add(time.Second)
+	limit := t.Year() + 5
+
+wrap:
+	for t.Year() <= limit {
+		for s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			if t.Month() == time.January {
+				continue wrap
+			}
+		}
+		for !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			if t.Day() == 1 {
+				continue wrap
+			}
+		}
+		for s.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Add(-time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second).Add(time.Hour)
+			if t.Hour() == 0 {
+				continue wrap
+			}
+		}
+		for s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(-time.Duration(t.Second()) * time.Second).Add(time.Minute)
+			if t.Minute() == 0 {
+				continue wrap
+			}
+		}
+		for s.second&(1<<uint(t.Second())) == 0 {
+			t = t.Add(time.Second)
+			if t.Second() == 0 {
+				continue wrap
+			}
+		}
+		return t.In(orig)
+	}
+	return time.Time{}
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,89 @@
+package scheduler
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCron_Next(t *testing.T) {
+	ams, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skipf("no tzdata: %v", err)
+	}
+	utc := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	cases := []struct {
+		expr, from, want string
+	}{
+		{"*/15 * * * *", "2024-03-01T12:07:30Z", "2024-03-01T12:15:00Z"},
+		{"*/15 * * * *", "2024-03-01T12:15:00Z", "2024-03-01T12:30:00Z"},
+		{"30 */10 * * * *", "2024-03-01T12:00:31Z", "2024-03-01T12:10:30Z"},
+		{"0 0 1 jan *", "2024-03-01T00:00:00Z", "2025-01-01T00:00:00Z"},
+		{"@hourly", "2024-03-01T23:30:00Z", "2024-03-02T00:00:00Z"},
+		{"0 0 29 2 *", "2024-03-01T00:00:00Z", "2028-02-29T00:00:00Z"},
+		// day-of-month or Sunday when both are restricted
+		{"0 0 15 * 0", "2024-03-01T00:00:00Z", "2024-03-03T00:00:00Z"},
+		{"0 0 * * 7", "2024-03-01T00:00:00Z", "2024-03-03T00:00:00Z"},
+		{"0 12 * * 1-5/2", "2024-03-01T13:00:00Z", "2024-03-04T12:00:00Z"},
+		// 07:00 in Amsterdam across the switch to summer time
+		{"TZ=Europe/Amsterdam 0 7 * * *", "2024-03-30T07:00:00Z", "2024-03-31T05:00:00Z"},
+		// the hour that occurs twice when the clocks go back runs twice:
+		// 02:45 CEST is followed by 02:00 CET
+		{"TZ=Europe/Amsterdam */15 * * * *", "2025-10-26T00:00:00Z", "2025-10-26T00:15:00Z"},
+		{"TZ=Europe/Amsterdam */15 * * * *", "2025-10-26T00:45:00Z", "2025-10-26T01:00:00Z"},
+		{"TZ=Europe/Amsterdam 0 * * * *", "2025-10-26T00:00:00Z", "2025-10-26T01:00:00Z"},
+		{"TZ=Europe/Amsterdam 0 7 * * *", "2025-10-25T05:00:00Z", "2025-10-26T06:00:00Z"},
+	}
+	for _, tc := range cases {
+		s, err := ParseCron(tc.expr)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", tc.expr, err)
+		}
+		if got := s.Next(utc(tc.from)); !got.Equal(utc(tc.want)) {
+			t.Errorf("%q after %s: got %s, want %s", tc.expr, tc.from, got.UTC().Format(time.RFC3339), tc.want)
+		}
+	}
+
+	// every quarter-hour of the repeated hour, in both offsets
+	s, err := ParseCron("*/15 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := time.Date(2025, 10, 26, 1, 45, 0, 0, ams)
+	var got []string
+	for i := 0; i < 9; i++ {
+		next = s.Next(next)
+		got = append(got, next.Format("15:04 MST"))
+	}
+	want := "02:00 CEST 02:15 CEST 02:30 CEST 02:45 CEST 02:00 CET 02:15 CET 02:30 CET 02:45 CET 03:00 CET"
+	if strings.Join(got, " ") != want {
+		t.Errorf("across the repeated hour: got %v, want %s", got, want)
+	}
+
+	// quarter hours stay aligned on the local clock through the DST change
+	s = Aligned(15 * time.Minute)
+	from := time.Date(2024, 3, 31, 1, 50, 0, 0, ams)
+	if got := s.Next(from); got.Hour() != 3 || got.Minute() != 0 {
+		t.Errorf("aligned across DST: got %v", got)
+	}
+}
+
+func TestParseCron_Invalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * * 13 *", "5-1 * * * *", "*/0 * * * *", "TZ=Nowhere/City * * * * *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q): expected error", expr)
+		}
+	}
+	if s, _ := ParseCron("0 0 30 2 *"); !s.Next(time.Now()).IsZero() {
+		t.Errorf("expected no activation for February 30")
+	}
+	if _, err := ParseSchedule("@every 7m"); err == nil {
+		t.Errorf("@every must divide a day")
+	}
+}
@@ -0,0 +1,191 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RunStatus is the outcome of a run
+type RunStatus string
+
+const (
+	RunSucceeded RunStatus = "succeeded"
+	RunFailed    RunStatus = "failed"
+	RunTimedOut  RunStatus = "timed_out"
+	RunLockLost  RunStatus = "lock_lost"
+	// RunSkipped means another instance held the job's lock
+	RunSkipped RunStatus = "skipped"
+)
+
+// RunRecord describes one execution of a job
+type RunRecord struct {
+	Job string
+	// Instance identifies the process (hostname:pid by default)
+	Instance    string
+	ScheduledAt time.Time
+	StartedAt   time.Time
+	FinishedAt  time.Time
+	Status      RunStatus
+	Error       string
+	// Rows is the number of rows the run reported with AddRows
+	Rows int64
+	// Failures counts consecutive failures up to and including this run
+	Failures int
+}
+
+type rowsKey struct{}
+
+// AddRows adds n to the rows written by the scheduled run ctx belongs to. It
+// does nothing outside a run.
+func AddRows(ctx context.Context, n int64) {
+	if c, ok := ctx.Value(rowsKey{}).(*atomic.Int64); ok {
+		c.Add(n)
+	}
+}
+
+func withRowCounter(ctx context.Context) (context.Context, *atomic.Int64) {
+	c := new(atomic.Int64)
+	return context.WithValue(ctx, rowsKey{}, c), c
+}
+
+// Duration is how long the run took
+func (r RunRecord) Duration() time.Duration {
+	return r.FinishedAt.Sub(r.StartedAt)
+}
+
+// History records finished runs
+type History interface {
+	Record(ctx context.Context, r RunRecord) error
+}
+
+// DefaultHistoryLimit is the number of runs MemoryHistory keeps per job when
+// Limit is zero
+const DefaultHistoryLimit = 100
+
+// MemoryHistory keeps the most recent runs of every job in memory
+type MemoryHistory struct {
+	Limit int
+
+	mu   sync.Mutex
+	runs map[string][]RunRecord
+}
+
+func (h *MemoryHistory) Record(ctx context.Context, r RunRecord) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.runs == nil {
+		h.runs = map[string][]RunRecord{}
+	}
+	limit := h.Limit
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+	runs := append(h.runs[r.Job], r)
+	if len(runs) > limit {
+		runs = runs[len(runs)-limit:]
+	}
+	h.runs[r.Job] = runs
+	return nil
+}
+
+// Runs returns the recorded runs of job, oldest first
+func (h *MemoryHistory) Runs(job string) []RunRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]RunRecord(nil), h.runs[job]...)
+}
+
+// FailureStreak is a job's run of failures since its last success
+type FailureStreak struct {
+	Job       string
+	Failures  int
+	Since     time.Time
+	LastError string
+}
+
+// DBHistory records runs in p1.job_runs
+type DBHistory struct {
+	DB *sql.DB
+}
+
+func (h *DBHistory) Record(ctx context.Context, r RunRecord) error {
+	var scheduled any
+	if !r.ScheduledAt.IsZero() {
+		scheduled = r.ScheduledAt
+	}
+	var runErr any
+	if r.Error != "" {
+		runErr = r.Error
+	}
+	_, err := h.DB.ExecContext(ctx, `INSERT INTO p1.job_runs
+	(job, instance, scheduled_at, started_at, finished_at, status, error, rows_written, failures)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		r.Job, r.Instance, scheduled, r.StartedAt, r.FinishedAt, string(r.Status), runErr, r.Rows, r.Failures)
+	return err
+}
+
+// Recent returns up to limit runs, newest first, of job or of all jobs when
+// job is empty. Skipped runs are left out unless withSkipped is set.
+func (h *DBHistory) Recent(ctx context.Context, job string, limit int, withSkipped bool) ([]RunRecord, error) {
+	rows, err := h.DB.QueryContext(ctx, `SELECT job, instance, scheduled_at, started_at, finished_at, status, COALESCE(error, ''), rows_written, failures
+FROM p1.job_runs
+WHERE ($1 = '' OR job = $1) AND ($2 OR status <> 'skipped')
+ORDER BY started_at DESC, id DESC
+LIMIT $3`, job, withSkipped, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []RunRecord
+	for rows.Next() {
+		var r RunRecord
+		var scheduled sql.NullTime
+		var status string
+		if err := rows.Scan(&r.Job, &r.Instance, &scheduled, &r.StartedAt, &r.FinishedAt, &status, &r.Error, &r.Rows, &r.Failures); err != nil {
+			return nil, err
+		}
+		r.ScheduledAt, r.Status = scheduled.Time, RunStatus(status)
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// Streaks returns, per job, the failed runs since its last successful run.
+// Jobs whose latest run succeeded are not listed.
+func (h *DBHistory) Streaks(ctx context.Context) ([]FailureStreak, error) {
+	rows, err := h.DB.QueryContext(ctx, `SELECT r.job, count(*), min(r.started_at),
+	(array_agg(COALESCE(r.error, '') ORDER BY r.started_at DESC))[1]
+FROM p1.job_runs r
+WHERE r.status NOT IN ('succeeded', 'skipped')
+  AND r.started_at > COALESCE((SELECT max(s.started_at) FROM p1.job_runs s
+	WHERE s.job = r.job AND s.status = 'succeeded'), '-infinity')
+GROUP BY r.job
+ORDER BY r.job`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var streaks []FailureStreak
+	for rows.Next() {
+		var s FailureStreak
+		if err := rows.Scan(&s.Job, &s.Failures, &s.Since, &s.LastError); err != nil {
+			return nil, err
+		}
+		streaks = append(streaks, s)
+	}
+	return streaks, rows.Err()
+}
+
+// Prune deletes runs that started before cutoff
+func (h *DBHistory) Prune(ctx context.Context, cutoff time.Time) (int64, error) {
+	res, err := h.DB.ExecContext(ctx, "DELETE FROM p1.job_runs WHERE started_at < $1", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
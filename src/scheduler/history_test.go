@@ -0,0 +1,137 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestTryRunOnce_RecordsRowsAndSkips(t *testing.T) {
+	hist := &MemoryHistory{}
+	dir := t.TempDir()
+	held := &FileLock{Path: dir + "/lock"}
+	s := &Scheduler{Name: "poll", Instance: "host:1", History: hist, Locker: &FileLock{Path: dir + "/lock"}}
+	ctx := withScheduledTime(context.Background(), at(12, 0))
+
+	if _, err := s.tryRunOnce(ctx, func(ctx context.Context) error {
+		AddRows(ctx, 2)
+		AddRows(ctx, 1)
+		return nil
+	}); err != nil {
+		t.Fatalf("tryRunOnce: %v", err)
+	}
+
+	// another instance holds the lock
+	if got, err := held.TryLock(ctx); err != nil || !got {
+		t.Fatalf("lock: got=%v err=%v", got, err)
+	}
+	defer held.Unlock(ctx)
+	if _, err := s.tryRunOnce(ctx, func(ctx context.Context) error {
+		t.Errorf("job ran while the lock was held")
+		return nil
+	}); err != nil {
+		t.Fatalf("tryRunOnce: %v", err)
+	}
+
+	runs := hist.Runs("poll")
+	if len(runs) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(runs))
+	}
+	if r := runs[0]; r.Status != RunSucceeded || r.Rows != 3 || r.Instance != "host:1" || !r.ScheduledAt.Equal(at(12, 0)) {
+		t.Errorf("unexpected run record %+v", r)
+	}
+	if r := runs[1]; r.Status != RunSkipped || r.Rows != 0 {
+		t.Errorf("unexpected skipped record %+v", r)
+	}
+	// AddRows outside a run is a no-op
+	AddRows(context.Background(), 1)
+}
+
+// failingHistory fails every write and counts them
+type failingHistory struct {
+	calls       int
+	hadDeadline bool
+}
+
+func (h *failingHistory) Record(ctx context.Context, r RunRecord) error {
+	h.calls++
+	_, h.hadDeadline = ctx.Deadline()
+	return errors.New("connection refused")
+}
+
+func TestTryRunOnce_HistoryDownSkipsRecording(t *testing.T) {
+	hist := &failingHistory{}
+	clock := newFakeClock(at(12, 0))
+	s := &Scheduler{Name: "poll", History: hist, Clock: clock, Locker: fileLocker(t, "lock")}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // recording still happens during a shutdown
+	job := func(ctx context.Context) error { return nil }
+
+	s.tryRunOnce(ctx, job)
+	if hist.calls != 1 || !hist.hadDeadline {
+		t.Fatalf("expected 1 write with a deadline, got %d (deadline %v)", hist.calls, hist.hadDeadline)
+	}
+	clock.AdvanceTo(at(12, 0).Add(historyRetry / 2))
+	s.tryRunOnce(ctx, job)
+	if hist.calls != 1 {
+		t.Errorf("expected no write while the history is down, got %d", hist.calls)
+	}
+	clock.AdvanceTo(at(12, 0).Add(historyRetry))
+	s.tryRunOnce(ctx, job)
+	if hist.calls != 2 {
+		t.Errorf("expected a retry after %s, got %d writes", historyRetry, hist.calls)
+	}
+}
+
+func TestDBHistory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+	h := &DBHistory{DB: db}
+	ctx := context.Background()
+	started := at(12, 0)
+	finished := started.Add(1500 * time.Millisecond)
+
+	mock.ExpectExec("INSERT INTO p1.job_runs").
+		WithArgs("poll", "host:1", nil, started, finished, "failed", "db down", int64(0), 3).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	if err := h.Record(ctx, RunRecord{Job: "poll", Instance: "host:1", StartedAt: started, FinishedAt: finished, Status: RunFailed, Error: "db down", Failures: 3}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+
+	mock.ExpectQuery("SELECT job, instance, scheduled_at").WithArgs("poll", false, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"job", "instance", "scheduled_at", "started_at", "finished_at", "status", "error", "rows_written", "failures"}).
+			AddRow("poll", "host:1", started, started, finished, "succeeded", "", int64(1), 0).
+			AddRow("poll", "host:1", nil, started, finished, "failed", "db down", int64(0), 3))
+	runs, err := h.Recent(ctx, "poll", 10, false)
+	if err != nil {
+		t.Fatalf("Recent: %v", err)
+	}
+	if len(runs) != 2 || runs[0].Rows != 1 || runs[1].Status != RunFailed || !runs[1].ScheduledAt.IsZero() || runs[1].Duration() != 1500*time.Millisecond {
+		t.Fatalf("unexpected runs %+v", runs)
+	}
+
+	mock.ExpectQuery("SELECT r.job, count").
+		WillReturnRows(sqlmock.NewRows([]string{"job", "count", "min", "error"}).AddRow("poll", 4, started, "db down"))
+	streaks, err := h.Streaks(ctx)
+	if err != nil {
+		t.Fatalf("Streaks: %v", err)
+	}
+	if len(streaks) != 1 || streaks[0].Failures != 4 || streaks[0].LastError != "db down" {
+		t.Fatalf("unexpected streaks %+v", streaks)
+	}
+
+	cutoff := at(0, 0)
+	mock.ExpectExec("DELETE FROM p1.job_runs").WithArgs(cutoff).WillReturnResult(sqlmock.NewResult(0, 42))
+	if n, err := h.Prune(ctx, cutoff); err != nil || n != 42 {
+		t.Fatalf("Prune: n=%d err=%v", n, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
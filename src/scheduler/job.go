@@ -0,0 +1,150 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrJobTimeout is the cause of a run cancelled after its Timeout
+var ErrJobTimeout = errors.New("job timed out")
+
+// Backoff spaces out runs after consecutive failures: the n-th failure in a
+// row postpones the next run until Initial*Multiplier^(n-1) has passed,
+// capped at Max, skipping the activations in between. The zero Backoff
+// keeps the schedule.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	// Multiplier defaults to 2
+	Multiplier float64
+}
+
+// Delay returns the pause after the given number of consecutive failures
+func (b Backoff) Delay(failures int) time.Duration {
+	if b.Initial <= 0 || failures <= 0 {
+		return 0
+	}
+	m := b.Multiplier
+	if m < 1 {
+		m = 2
+	}
+	d := float64(b.Initial) * math.Pow(m, float64(failures-1))
+	if b.Max > 0 && d > float64(b.Max) {
+		return b.Max
+	}
+	if d > math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(d)
+}
+
+// Job is a named job for a Group. Each job has its own schedule, timeout,
+// backoff and lock, so a slow job never delays another.
+type Job struct {
+	Name     string
+	Run      Runner
+	Interval time.Duration
+	// Schedule, when set, replaces Interval
+	Schedule Schedule
+	Jitter   time.Duration
+	Missed   MissedPolicy
+	Timeout  time.Duration
+	Backoff  Backoff
+	// LockKey is the job's advisory lock key; Locker overrides it
+	LockKey int64
+	Locker  Locker
+	// FailFast makes Group.Run return when the first run fails, e.g. for a
+	// collector that cannot reach its meter at all. Other jobs retry on
+	// their schedule.
+	FailFast bool
+}
+
+// Group runs several named jobs concurrently, each on its own Scheduler
+type Group struct {
+	DB      *sql.DB
+	Clock   Clock
+	History History
+	// Instance identifies this process in the run history (hostname:pid
+	// when empty)
+	Instance string
+	// ShutdownGrace is passed to every job's Scheduler
+	ShutdownGrace time.Duration
+
+	jobs []groupJob
+}
+
+type groupJob struct {
+	s   *Scheduler
+	run Runner
+}
+
+// Add registers a job and returns its Scheduler, which can be configured
+// further (e.g. with a Drain) before Run
+func (g *Group) Add(j Job) (*Scheduler, error) {
+	if j.Name == "" || j.Run == nil {
+		return nil, errors.New("job needs a name and a runner")
+	}
+	if j.Locker == nil && (g.DB == nil || j.LockKey == 0) {
+		return nil, fmt.Errorf("job %s needs a lock key or a locker", j.Name)
+	}
+	for _, other := range g.jobs {
+		if other.s.Name == j.Name {
+			return nil, fmt.Errorf("job %s registered twice", j.Name)
+		}
+		if j.Locker == nil && other.s.Locker == nil && other.s.LockKey == j.LockKey {
+			return nil, fmt.Errorf("jobs %s and %s share lock key %d", other.s.Name, j.Name, j.LockKey)
+		}
+	}
+	instance := g.Instance
+	if instance == "" {
+		instance = DefaultHolderID()
+	}
+	s := &Scheduler{
+		Name:          j.Name,
+		DB:            g.DB,
+		LockKey:       j.LockKey,
+		Locker:        j.Locker,
+		Interval:      j.Interval,
+		Schedule:      j.Schedule,
+		Jitter:        j.Jitter,
+		Missed:        j.Missed,
+		Timeout:       j.Timeout,
+		Backoff:       j.Backoff,
+		Clock:         g.Clock,
+		History:       g.History,
+		Instance:      instance,
+		ShutdownGrace: g.ShutdownGrace,
+		tolerant:      !j.FailFast,
+	}
+	g.jobs = append(g.jobs, groupJob{s, j.Run})
+	return s, nil
+}
+
+// Run runs all jobs until ctx is cancelled or a job stops with an error, in
+// which case the other jobs are cancelled and that error is returned
+func (g *Group) Run(ctx context.Context) error {
+	if len(g.jobs) == 0 {
+		return errors.New("no jobs registered")
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var wg sync.WaitGroup
+	for _, j := range g.jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := j.s.Run(ctx, j.run); err != nil && ctx.Err() == nil {
+				cancel(fmt.Errorf("job %s: %w", j.s.Name, err))
+			}
+		}()
+	}
+	wg.Wait()
+	return context.Cause(ctx)
+}
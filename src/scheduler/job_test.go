@@ -0,0 +1,149 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: 10 * time.Second}
+	for failures, want := range []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second} {
+		if got := b.Delay(failures); got != want {
+			t.Errorf("Delay(%d) = %v, want %v", failures, got, want)
+		}
+	}
+	if got := (Backoff{}).Delay(3); got != 0 {
+		t.Errorf("zero Backoff delayed by %v", got)
+	}
+	if got := (Backoff{Initial: time.Second, Multiplier: 3}).Delay(3); got != 9*time.Second {
+		t.Errorf("multiplier 3: got %v", got)
+	}
+}
+
+// startGroup runs g until the test ends
+func startGroup(t *testing.T, g *Group) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- g.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Errorf("group stopped with %v", err)
+		}
+	})
+}
+
+func fileLocker(t *testing.T, name string) Locker {
+	return &FileLock{Path: filepath.Join(t.TempDir(), name)}
+}
+
+func TestGroup_BackoffAfterConsecutiveFailures(t *testing.T) {
+	clock := newFakeClock(at(12, 0))
+	hist := &MemoryHistory{}
+	g := &Group{Clock: clock, History: hist}
+	ran := make(chan time.Time, 10)
+	fail := errors.New("meter unreachable")
+	results := []error{fail, fail, fail, nil, nil}
+	if _, err := g.Add(Job{
+		Name:     "poll",
+		Schedule: Aligned(time.Minute),
+		Backoff:  Backoff{Initial: 2 * time.Minute, Max: 8 * time.Minute},
+		Locker:   fileLocker(t, "poll"),
+		Run: func(ctx context.Context) error {
+			err := results[0]
+			results = results[1:]
+			ran <- clock.Now()
+			return err
+		},
+	}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	startGroup(t, g)
+
+	<-ran
+	// failures 1, 2 and 3 postpone the next run by 2, 4 and 8 minutes
+	for _, want := range []time.Time{at(12, 2), at(12, 6), at(12, 14), at(12, 15)} {
+		d := clock.wait(t)
+		if got := clock.Now().Add(d); !got.Equal(want) {
+			t.Fatalf("expected next run at %v, got %v", want, got)
+		}
+		clock.AdvanceTo(want)
+		<-ran
+	}
+
+	runs := hist.Runs("poll")
+	if len(runs) != 5 {
+		t.Fatalf("expected 5 recorded runs, got %d", len(runs))
+	}
+	if r := runs[2]; r.Status != RunFailed || r.Failures != 3 || r.Error != "meter unreachable" || !r.ScheduledAt.Equal(at(12, 6)) {
+		t.Errorf("unexpected third run record %+v", r)
+	}
+	if r := runs[3]; r.Status != RunSucceeded || r.Failures != 0 {
+		t.Errorf("unexpected fourth run record %+v", r)
+	}
+}
+
+func TestGroup_JobsRunIndependently(t *testing.T) {
+	clock := newFakeClock(at(12, 0))
+	g := &Group{Clock: clock}
+	polls := make(chan time.Time, 10)
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	g.Add(Job{Name: "poll", Interval: time.Minute, Locker: fileLocker(t, "poll"), Run: func(ctx context.Context) error {
+		polls <- clock.Now()
+		return nil
+	}})
+	g.Add(Job{Name: "rollup", Interval: 15 * time.Minute, Locker: fileLocker(t, "rollup"), Run: func(ctx context.Context) error {
+		close(blocked)
+		<-release
+		return nil
+	}})
+	startGroup(t, g)
+	defer close(release)
+
+	<-blocked
+	<-polls
+	// poll keeps its schedule while the rollup is still running
+	if d := clock.wait(t); d != time.Minute {
+		t.Fatalf("expected poll to wait a minute, got %v", d)
+	}
+	clock.AdvanceTo(at(12, 1))
+	if got := <-polls; !got.Equal(at(12, 1)) {
+		t.Fatalf("poll ran at %v", got)
+	}
+}
+
+func TestScheduler_TimeoutRecorded(t *testing.T) {
+	hist := &MemoryHistory{}
+	s := &Scheduler{Name: "rollup", Timeout: 10 * time.Millisecond, History: hist, Locker: fileLocker(t, "lock")}
+	_, err := s.tryRunOnce(context.Background(), func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if !errors.Is(err, ErrJobTimeout) {
+		t.Fatalf("expected ErrJobTimeout, got %v", err)
+	}
+	runs := hist.Runs("rollup")
+	if len(runs) != 1 || runs[0].Status != RunTimedOut || !strings.Contains(runs[0].Error, "timed out") {
+		t.Fatalf("unexpected history %+v", runs)
+	}
+}
+
+func TestGroup_AddValidates(t *testing.T) {
+	g := &Group{}
+	noop := func(context.Context) error { return nil }
+	if _, err := g.Add(Job{Name: "a", Run: noop}); err == nil {
+		t.Errorf("expected error for a job without a lock")
+	}
+	if _, err := g.Add(Job{Name: "a", Run: noop, Locker: fileLocker(t, "a")}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if _, err := g.Add(Job{Name: "a", Run: noop, Locker: fileLocker(t, "b")}); err == nil {
+		t.Errorf("expected error for a duplicate name")
+	}
+}
@@ -0,0 +1,246 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultLeaseTTL is the lease lifetime used when Elector.TTL is zero
+const DefaultLeaseTTL = 30 * time.Second
+
+// ErrNoLease is returned by ReadLease when nobody has held the lease yet
+var ErrNoLease = errors.New("lease has never been held")
+
+// LeaseStatus is a row of p1.leases
+type LeaseStatus struct {
+	Name       string
+	Holder     string
+	AcquiredAt time.Time
+	RenewedAt  time.Time
+	ExpiresAt  time.Time
+	// Expired is evaluated by the database clock
+	Expired bool
+}
+
+// Elector campaigns for a named lease in p1.leases so that one of several
+// collectors is the leader and the others stand by. The leader renews the
+// lease every RenewInterval; if it dies, the lease expires after TTL and a
+// standby takes over at its next renewal attempt, i.e. within TTL +
+// RenewInterval. Expiry is judged by the database clock, so collectors on
+// hosts with skewed clocks still agree.
+//
+// Elector is a Locker: set it as Scheduler.Locker and only the leader runs
+// jobs. Unlock keeps the lease, so leadership is sticky across ticks, and a
+// leader that can no longer renew steps down before its lease can expire,
+// cancelling a running job.
+type Elector struct {
+	DB   *sql.DB
+	Name string
+	// HolderID identifies this instance (hostname:pid when empty)
+	HolderID string
+	TTL      time.Duration
+	// RenewInterval defaults to TTL/3
+	RenewInterval time.Duration
+	// Clock defaults to SystemClock
+	Clock Clock
+
+	mu         sync.Mutex
+	leader     bool
+	validUntil time.Time
+	lost       chan struct{}
+}
+
+// DefaultHolderID is hostname:pid
+func DefaultHolderID() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+// Run campaigns until ctx is done, then releases the lease if held
+func (e *Elector) Run(ctx context.Context) error {
+	for {
+		if err := e.Campaign(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("leader election %q: %v\n", e.Name, err)
+		}
+		timer := e.clock().NewTimer(e.renewInterval())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			e.release()
+			return ctx.Err()
+		case <-timer.C():
+		}
+	}
+}
+
+// Campaign makes one attempt to acquire or renew the lease
+func (e *Elector) Campaign(ctx context.Context) error {
+	attempt := e.now()
+	var holder string
+	err := e.DB.QueryRowContext(ctx, `INSERT INTO p1.leases (name, holder, acquired_at, renewed_at, expires_at)
+VALUES ($1, $2, now(), now(), now() + $3 * interval '1 millisecond')
+ON CONFLICT (name) DO UPDATE SET
+	holder = EXCLUDED.holder,
+	acquired_at = CASE WHEN p1.leases.holder = EXCLUDED.holder THEN p1.leases.acquired_at ELSE now() END,
+	renewed_at = now(),
+	expires_at = EXCLUDED.expires_at
+WHERE p1.leases.holder = EXCLUDED.holder OR p1.leases.expires_at < now()
+RETURNING holder`, e.Name, e.holderID(), e.ttl().Milliseconds()).Scan(&holder)
+
+	switch {
+	case err == nil:
+		e.setLeader(true, attempt.Add(e.ttl()))
+		return nil
+	case errors.Is(err, sql.ErrNoRows):
+		// held by another instance
+		e.setLeader(false, time.Time{})
+		return nil
+	default:
+		// keep leading only while our lease is certainly still valid
+		e.mu.Lock()
+		expired := e.leader && !e.now().Before(e.validUntil)
+		e.mu.Unlock()
+		if expired {
+			e.setLeader(false, time.Time{})
+		}
+		return fmt.Errorf("renew lease: %w", err)
+	}
+}
+
+// IsLeader reports whether this instance currently holds the lease
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader && e.now().Before(e.validUntil)
+}
+
+// TryLock reports leadership without blocking
+func (e *Elector) TryLock(ctx context.Context) (bool, error) {
+	return e.IsLeader(), nil
+}
+
+// Unlock is a no-op: the leader keeps its lease between runs
+func (e *Elector) Unlock(ctx context.Context) error {
+	return nil
+}
+
+// Held reports whether this instance is the leader
+func (e *Elector) Held() bool {
+	return e.IsLeader()
+}
+
+// Watch reports when this instance steps down
+func (e *Elector) Watch(ctx context.Context) <-chan error {
+	out := make(chan error, 1)
+	e.mu.Lock()
+	lost := e.lost
+	validUntil := e.validUntil
+	leader := e.leader
+	e.mu.Unlock()
+	if !leader || lost == nil {
+		out <- errors.New("not the leader")
+		return out
+	}
+	go func() {
+		// also fire at expiry in case Campaign stopped running
+		timer := e.clock().NewTimer(validUntil.Sub(e.now()))
+		defer func() { timer.Stop() }()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-lost:
+				out <- errors.New("lost leadership")
+				return
+			case <-timer.C():
+				if !e.IsLeader() {
+					out <- errors.New("lease expired")
+					return
+				}
+				e.mu.Lock()
+				validUntil = e.validUntil
+				e.mu.Unlock()
+				timer = e.clock().NewTimer(validUntil.Sub(e.now()))
+			}
+		}
+	}()
+	return out
+}
+
+// ReadLease reads the current holder of the named lease
+func ReadLease(ctx context.Context, db *sql.DB, name string) (LeaseStatus, error) {
+	st := LeaseStatus{Name: name}
+	err := db.QueryRowContext(ctx, `SELECT holder, acquired_at, renewed_at, expires_at, expires_at < now()
+FROM p1.leases WHERE name = $1`, name).Scan(&st.Holder, &st.AcquiredAt, &st.RenewedAt, &st.ExpiresAt, &st.Expired)
+	if errors.Is(err, sql.ErrNoRows) {
+		return st, ErrNoLease
+	}
+	return st, err
+}
+
+// setLeader records the campaign result and logs transitions
+func (e *Elector) setLeader(leader bool, validUntil time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	was := e.leader
+	e.leader, e.validUntil = leader, validUntil
+	switch {
+	case leader && !was:
+		e.lost = make(chan struct{})
+		log.Printf("leader election %q: %s became leader\n", e.Name, e.holderID())
+	case !leader && was:
+		close(e.lost)
+		log.Printf("leader election %q: %s stepped down to standby\n", e.Name, e.holderID())
+	}
+}
+
+// release gives up the lease on shutdown so a standby can take over at once
+func (e *Elector) release() {
+	if !e.IsLeader() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := e.DB.ExecContext(ctx, "DELETE FROM p1.leases WHERE name = $1 AND holder = $2", e.Name, e.holderID()); err != nil {
+		log.Printf("leader election %q: release lease: %v\n", e.Name, err)
+	}
+	e.setLeader(false, time.Time{})
+}
+
+func (e *Elector) holderID() string {
+	if e.HolderID == "" {
+		return DefaultHolderID()
+	}
+	return e.HolderID
+}
+
+func (e *Elector) ttl() time.Duration {
+	if e.TTL <= 0 {
+		return DefaultLeaseTTL
+	}
+	return e.TTL
+}
+
+func (e *Elector) renewInterval() time.Duration {
+	if e.RenewInterval > 0 {
+		return e.RenewInterval
+	}
+	return e.ttl() / 3
+}
+
+func (e *Elector) now() time.Time {
+	return e.clock().Now()
+}
+
+func (e *Elector) clock() Clock {
+	if e.Clock == nil {
+		return SystemClock{}
+	}
+	return e.Clock
+}
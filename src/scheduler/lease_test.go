@@ -0,0 +1,141 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const leaseUpsert = "INSERT INTO p1.leases"
+
+func TestElector_AcquireStandbyAndTakeover(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	e := &Elector{DB: db, Name: "collector", HolderID: "b", TTL: 30 * time.Second}
+	ctx := context.Background()
+
+	// another instance holds an unexpired lease: the upsert updates nothing
+	mock.ExpectQuery(leaseUpsert).WithArgs("collector", "b", int64(30000)).WillReturnRows(sqlmock.NewRows([]string{"holder"}))
+	if err := e.Campaign(ctx); err != nil {
+		t.Fatalf("campaign: %v", err)
+	}
+	if ok, _ := e.TryLock(ctx); ok {
+		t.Fatalf("standby must not get the lock")
+	}
+
+	// the leader died and its lease expired
+	mock.ExpectQuery(leaseUpsert).WithArgs("collector", "b", int64(30000)).WillReturnRows(sqlmock.NewRows([]string{"holder"}).AddRow("b"))
+	if err := e.Campaign(ctx); err != nil {
+		t.Fatalf("campaign: %v", err)
+	}
+	if ok, _ := e.TryLock(ctx); !ok || !e.Held() {
+		t.Fatalf("expected to take over the lease")
+	}
+	if err := e.Unlock(ctx); err != nil || !e.IsLeader() {
+		t.Fatalf("leadership must survive Unlock")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestElector_StepsDownWhenRenewalFails(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	advance := func(d time.Duration) { clock.AdvanceTo(clock.Now().Add(d)) }
+	e := &Elector{DB: db, Name: "collector", HolderID: "a", TTL: 30 * time.Second, Clock: clock}
+	ctx := context.Background()
+
+	mock.ExpectQuery(leaseUpsert).WillReturnRows(sqlmock.NewRows([]string{"holder"}).AddRow("a"))
+	if err := e.Campaign(ctx); err != nil {
+		t.Fatalf("campaign: %v", err)
+	}
+	lost := e.Watch(ctx)
+
+	// a failed renewal within the TTL keeps the lease
+	advance(10 * time.Second)
+	mock.ExpectQuery(leaseUpsert).WillReturnError(errors.New("connection refused"))
+	if err := e.Campaign(ctx); err == nil {
+		t.Fatalf("expected renewal error")
+	}
+	if !e.IsLeader() {
+		t.Fatalf("leader should keep the lease until it expires")
+	}
+
+	// once the TTL has passed without renewal another instance may hold it
+	advance(25 * time.Second)
+	if e.IsLeader() {
+		t.Fatalf("leadership must end when the lease expires")
+	}
+	mock.ExpectQuery(leaseUpsert).WillReturnError(errors.New("connection refused"))
+	e.Campaign(ctx)
+	select {
+	case <-lost:
+	case <-time.After(time.Second):
+		t.Fatalf("Watch did not report the lost leadership")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestScheduler_StandbySkipsRun(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	e := &Elector{DB: db, Name: "collector", HolderID: "b"}
+	mock.ExpectQuery(leaseUpsert).WillReturnRows(sqlmock.NewRows([]string{"holder"}))
+	e.Campaign(context.Background())
+
+	s := &Scheduler{Locker: e}
+	called := false
+	if _, err := s.tryRunOnce(context.Background(), func(ctx context.Context) error {
+		called = true
+		return nil
+	}); err != nil {
+		t.Fatalf("tryRunOnce: %v", err)
+	}
+	if called {
+		t.Fatalf("standby must not run the job")
+	}
+}
+
+func TestReadLease(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	ts := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT holder, acquired_at, renewed_at, expires_at").WithArgs("collector").
+		WillReturnRows(sqlmock.NewRows([]string{"holder", "acquired_at", "renewed_at", "expires_at", "expired"}).
+			AddRow("host:1", ts, ts, ts.Add(30*time.Second), false))
+	st, err := ReadLease(context.Background(), db, "collector")
+	if err != nil {
+		t.Fatalf("ReadLease: %v", err)
+	}
+	if st.Holder != "host:1" || st.Expired || !st.ExpiresAt.Equal(ts.Add(30*time.Second)) {
+		t.Fatalf("unexpected status %+v", st)
+	}
+
+	mock.ExpectQuery("SELECT holder").WithArgs("other").WillReturnRows(sqlmock.NewRows([]string{"holder"}))
+	if _, err := ReadLease(context.Background(), db, "other"); !errors.Is(err, ErrNoLease) {
+		t.Fatalf("expected ErrNoLease, got %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrLockLost is the cause of a job context cancelled because its lock was
+// lost while the job ran
+var ErrLockLost = errors.New("lock lost")
+
+// DefaultLockCheckInterval is how often a held advisory lock's connection is
+// checked while a job runs
+const DefaultLockCheckInterval = 5 * time.Second
+
+// Locker provides the single-runner guarantee for a scheduled job. TryLock
+// must not block: it reports false when another instance holds the lock.
+// Held reports whether this Locker currently owns the lock.
+type Locker interface {
+	TryLock(ctx context.Context) (bool, error)
+	Unlock(ctx context.Context) error
+	Held() bool
+}
+
+// LossDetector is implemented by Lockers whose lock can disappear while held,
+// like a session-level advisory lock whose connection drops. Watch returns a
+// channel that receives an error when the lock is lost; it stops watching
+// when ctx is done.
+type LossDetector interface {
+	Watch(ctx context.Context) <-chan error
+}
+
+// AdvisoryLock is a Locker backed by pg_try_advisory_lock. Advisory locks
+// belong to a database session, so the lock is taken and released on a
+// dedicated connection that is kept out of the pool while the lock is held.
+type AdvisoryLock struct {
+	DB  *sql.DB
+	Key int64
+	// CheckInterval is how often Watch pings the lock connection
+	// (DefaultLockCheckInterval when zero)
+	CheckInterval time.Duration
+
+	mu    sync.Mutex
+	conn  *sql.Conn
+	since time.Time
+}
+
+func (l *AdvisoryLock) TryLock(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn != nil {
+		return false, errors.New("advisory lock already held by this instance")
+	}
+
+	conn, err := l.DB.Conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("advisory lock connection: %w", err)
+	}
+	var got bool
+	row := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", l.Key)
+	if err := row.Scan(&got); err != nil {
+		conn.Close()
+		return false, fmt.Errorf("advisory lock check: %w", err)
+	}
+	if !got {
+		conn.Close()
+		return false, nil
+	}
+	l.conn, l.since = conn, time.Now()
+	return true, nil
+}
+
+// Unlock releases the lock on the connection that took it and returns the
+// connection to the pool. If the session is already gone, so is the lock.
+func (l *AdvisoryLock) Unlock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn == nil {
+		return nil
+	}
+	_, err := l.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", l.Key)
+	l.conn.Close()
+	l.conn = nil
+	return err
+}
+
+func (l *AdvisoryLock) Held() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.conn != nil
+}
+
+// HeldSince returns when the lock was acquired, or the zero time
+func (l *AdvisoryLock) HeldSince() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn == nil {
+		return time.Time{}
+	}
+	return l.since
+}
+
+// Watch pings the lock connection every CheckInterval. A failed ping means
+// the session, and with it the lock, may be gone and another instance can
+// take over, so the error is reported.
+func (l *AdvisoryLock) Watch(ctx context.Context) <-chan error {
+	lost := make(chan error, 1)
+	l.mu.Lock()
+	conn := l.conn
+	l.mu.Unlock()
+	if conn == nil {
+		lost <- errors.New("advisory lock not held")
+		return lost
+	}
+
+	interval := l.CheckInterval
+	if interval <= 0 {
+		interval = DefaultLockCheckInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := conn.PingContext(ctx); err != nil && ctx.Err() == nil {
+					lost <- fmt.Errorf("advisory lock connection: %w", err)
+					return
+				}
+			}
+		}
+	}()
+	return lost
+}
+
+// FileLock is a Locker backed by an exclusive, non-blocking OS file lock
+// (flock on Unix, LockFileEx on Windows). It replaces the advisory lock for
+// storage backends without one, such as SQLite. The lock is released by the
+// OS if the process dies.
+type FileLock struct {
+	Path string
+	f    *os.File
+}
+
+func (l *FileLock) TryLock(ctx context.Context) (bool, error) {
+	f, err := os.OpenFile(l.Path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return false, fmt.Errorf("open lock file: %w", err)
+	}
+	got, err := tryLockFile(f)
+	if err != nil || !got {
+		f.Close()
+		return false, err
+	}
+	l.f = f
+	return true, nil
+}
+
+func (l *FileLock) Held() bool {
+	return l.f != nil
+}
+
+func (l *FileLock) Unlock(ctx context.Context) error {
+	if l.f == nil {
+		return nil
+	}
+	err := unlockFile(l.f)
+	l.f.Close()
+	l.f = nil
+	return err
+}
@@ -0,0 +1,154 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFileLock_Exclusive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "p1.db.lock")
+	ctx := context.Background()
+
+	first := &FileLock{Path: path}
+	second := &FileLock{Path: path}
+
+	got, err := first.TryLock(ctx)
+	if err != nil || !got {
+		t.Fatalf("first TryLock: got=%v err=%v", got, err)
+	}
+	got, err = second.TryLock(ctx)
+	if err != nil {
+		t.Fatalf("second TryLock: %v", err)
+	}
+	if got {
+		t.Fatalf("expected second lock to fail while first is held")
+	}
+
+	if err := first.Unlock(ctx); err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+	got, err = second.TryLock(ctx)
+	if err != nil || !got {
+		t.Fatalf("expected lock after release: got=%v err=%v", got, err)
+	}
+	second.Unlock(ctx)
+}
+
+func TestScheduler_FileLockRunsJob(t *testing.T) {
+	s := &Scheduler{Locker: &FileLock{Path: filepath.Join(t.TempDir(), "lock")}}
+	called := false
+	if _, err := s.tryRunOnce(context.Background(), func(ctx context.Context) error {
+		called = true
+		return nil
+	}); err != nil {
+		t.Fatalf("tryRunOnce: %v", err)
+	}
+	if !called {
+		t.Fatalf("expected runner to be called")
+	}
+}
+
+func TestScheduler_DrainsAfterRecovery(t *testing.T) {
+	dir := t.TempDir()
+	s := &Scheduler{
+		Interval:    10 * time.Millisecond,
+		Locker:      &FileLock{Path: filepath.Join(dir, "lock")},
+		DrainLocker: &FileLock{Path: filepath.Join(dir, "drain.lock")},
+	}
+	var drains atomic.Int32
+	s.Drain = func(ctx context.Context) error {
+		drains.Add(1)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runs := 0
+	// ok (startup drain), fail, fail, ok (recovery drain), ok, ok
+	results := []error{nil, errors.New("db down"), errors.New("db down"), nil, nil, nil}
+	err := s.Run(ctx, func(ctx context.Context) error {
+		if runs == len(results)-1 {
+			cancel()
+		}
+		err := results[runs]
+		runs++
+		return err
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if got := drains.Load(); got != 2 {
+		t.Errorf("expected 2 drains (startup and recovery), got %d", got)
+	}
+}
+
+func TestScheduler_NoDrainAfterSkippedRun(t *testing.T) {
+	dir := t.TempDir()
+	held := &FileLock{Path: filepath.Join(dir, "lock")}
+	if got, err := held.TryLock(context.Background()); err != nil || !got {
+		t.Fatalf("lock: got=%v err=%v", got, err)
+	}
+
+	var drains atomic.Int32
+	s := &Scheduler{
+		Locker:      &FileLock{Path: filepath.Join(dir, "lock")},
+		DrainLocker: &FileLock{Path: filepath.Join(dir, "drain.lock")},
+		Drain: func(ctx context.Context) error {
+			drains.Add(1)
+			return nil
+		},
+	}
+	s.drainPending.Store(true)
+	ctx := context.Background()
+	job := func(ctx context.Context) error { return nil }
+
+	// another instance holds the job lock: the run is skipped
+	ran, err := s.tryRunOnce(ctx, job)
+	if ran || err != nil {
+		t.Fatalf("expected a skipped run, got ran=%v err=%v", ran, err)
+	}
+	s.afterRun(ctx, ran, err)
+	s.drains.Wait()
+	if got := drains.Load(); got != 0 {
+		t.Fatalf("expected no drain after a skipped run, got %d", got)
+	}
+
+	held.Unlock(ctx)
+	ran, err = s.tryRunOnce(ctx, job)
+	s.afterRun(ctx, ran, err)
+	s.drains.Wait()
+	if got := drains.Load(); got != 1 {
+		t.Errorf("expected the pending drain after the first real run, got %d", got)
+	}
+}
+
+func TestScheduler_DrainSkippedWhileLocked(t *testing.T) {
+	dir := t.TempDir()
+	held := &FileLock{Path: filepath.Join(dir, "drain.lock")}
+	if got, err := held.TryLock(context.Background()); err != nil || !got {
+		t.Fatalf("lock: got=%v err=%v", got, err)
+	}
+	defer held.Unlock(context.Background())
+
+	s := &Scheduler{
+		Interval:    10 * time.Millisecond,
+		Locker:      &FileLock{Path: filepath.Join(dir, "lock")},
+		DrainLocker: &FileLock{Path: filepath.Join(dir, "drain.lock")},
+		Drain: func(ctx context.Context) error {
+			t.Errorf("drain ran while another instance held the drain lock")
+			return nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	runs := 0
+	s.Run(ctx, func(ctx context.Context) error {
+		if runs++; runs == 3 {
+			cancel()
+		}
+		return nil
+	})
+}
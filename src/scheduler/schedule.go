@@ -0,0 +1,92 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schedule decides when a job runs. Next returns the first activation
+// strictly after t, or the zero time if there is none.
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// MissedPolicy decides what happens to activations that passed while a run
+// was still busy or the host was suspended
+type MissedPolicy string
+
+const (
+	// MissedSkip runs once for the latest missed activation and skips the
+	// others, like a time.Ticker
+	MissedSkip MissedPolicy = "skip"
+	// MissedCatchUp runs every missed activation in order, up to
+	// Scheduler.MaxCatchUp of the most recent ones
+	MissedCatchUp MissedPolicy = "catch-up"
+)
+
+// DefaultMaxCatchUp bounds the runs replayed by MissedCatchUp
+const DefaultMaxCatchUp = 96
+
+// Every runs at a fixed interval counted from the previous activation,
+// regardless of the wall clock
+func Every(d time.Duration) Schedule {
+	return everySchedule(d)
+}
+
+type everySchedule time.Duration
+
+func (s everySchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
+
+// Aligned runs on wall-clock boundaries: every multiple of d counted from
+// local midnight, e.g. at :00, :15, :30 and :45 for 15 minutes. d should
+// divide a day; use a cron expression for anything else.
+func Aligned(d time.Duration) Schedule {
+	return alignedSchedule(d)
+}
+
+type alignedSchedule time.Duration
+
+func (s alignedSchedule) Next(t time.Time) time.Time {
+	d := time.Duration(s)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	next := midnight.Add((t.Sub(midnight)/d + 1) * d)
+	// the next day starts a new sequence, also on days with a DST change
+	if tomorrow := midnight.AddDate(0, 0, 1); !next.Before(tomorrow) {
+		return tomorrow
+	}
+	return next
+}
+
+// ParseSchedule accepts a cron expression (see ParseCron), a descriptor
+// like @hourly, or "@every <duration>" for a wall-clock aligned interval.
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if rest, ok := strings.CutPrefix(spec, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+		if d <= 0 || (24*time.Hour)%d != 0 {
+			return nil, fmt.Errorf("invalid schedule %q: interval must divide 24h", spec)
+		}
+		return Aligned(d), nil
+	}
+	return ParseCron(spec)
+}
+
+type scheduledTimeKey struct{}
+
+// ScheduledTime returns the activation a run belongs to. It differs from
+// the current time for jitter and for missed activations run late.
+func ScheduledTime(ctx context.Context) (time.Time, bool) {
+	t, ok := ctx.Value(scheduledTimeKey{}).(time.Time)
+	return t, ok
+}
+
+func withScheduledTime(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, scheduledTimeKey{}, t)
+}
@@ -5,66 +5,421 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Runner is the function executed on schedule
 type Runner func(ctx context.Context) error
 
-// Scheduler runs a Runner at fixed intervals while respecting an advisory lock
+// ErrShutdown is the cause of a run cancelled because it was still busy
+// when the shutdown grace period ended
+var ErrShutdown = errors.New("shutdown grace period exceeded")
+
+// historyTimeout bounds recording a run, so a history database that is down
+// does not hold up the job or shutdown; after a failed write runs are not
+// recorded for historyRetry
+const (
+	historyTimeout = 5 * time.Second
+	historyRetry   = time.Minute
+)
+
+// ErrScheduleExhausted is returned by Run when the schedule has no future
+// activation
+var ErrScheduleExhausted = errors.New("schedule has no future activation")
+
+// Scheduler runs a Runner on a schedule while respecting an advisory lock
 type Scheduler struct {
+	// Name identifies the job in logs and in the run history
+	Name     string
 	DB       *sql.DB
 	LockKey  int64 // advisory lock key
 	Interval time.Duration
+	// Schedule, when set, replaces Interval, e.g. Aligned(15*time.Minute)
+	// or a cron expression from ParseSchedule
+	Schedule Schedule
+	// Jitter delays every activation by a random duration below Jitter so
+	// collectors sharing a schedule do not hit the database at once
+	Jitter time.Duration
+	// Missed decides what happens to activations that passed during a long
+	// run or a suspend; the default is MissedSkip
+	Missed     MissedPolicy
+	MaxCatchUp int
+	// Timeout bounds a single run; zero means no limit
+	Timeout time.Duration
+	// Backoff postpones runs after consecutive failures
+	Backoff Backoff
+	// History, when set, records every run
+	History History
+	// Instance identifies this process in the run history
+	Instance string
+	// OnRun, when set, is called after every run with its record, e.g. to
+	// report progress to a service manager
+	OnRun func(RunRecord)
+	// ShutdownGrace lets a run that is in progress when ctx is cancelled
+	// finish for up to this long before its context is cancelled too; no new
+	// runs start once ctx is done
+	ShutdownGrace time.Duration
+	// Clock defaults to SystemClock
+	Clock Clock
+	// Locker overrides the Postgres advisory lock, e.g. with a FileLock for
+	// storage backends without advisory locks. DB is not needed when set.
+	Locker Locker
+
+	// Drain, when set, is started in the background after the first
+	// successful run and whenever a run succeeds after failed runs, so
+	// buffered readings are persisted once the database is reachable again.
+	// It never delays the next scheduled run and holds its own lock
+	// (DrainLocker, or the advisory lock DrainLockKey) with the same
+	// semantics as the job lock: if another instance holds it, the drain is
+	// skipped. A failed drain is retried after the next successful run.
+	Drain        Runner
+	DrainLockKey int64
+	DrainLocker  Locker
+
+	lockInit      sync.Once
+	drainLockInit sync.Once
+	drainPending  atomic.Bool
+	draining      atomic.Bool
+	drains        sync.WaitGroup
+
+	// failures counts consecutive failed runs
+	failures int
+	// historyFailedAt is when recording a run last failed
+	historyFailedAt time.Time
+	// tolerant keeps the loop going when the first run fails
+	tolerant bool
 }
 
-// Run starts the scheduler loop until ctx is cancelled
+// Run starts the scheduler loop until ctx is cancelled. The job runs once
+// immediately and then at every activation of the schedule; the job can
+// read the activation it belongs to with ScheduledTime. If the first run
+// fails, Run returns its error, except for jobs of a Group that are not
+// FailFast.
 func (s *Scheduler) Run(ctx context.Context, run Runner) error {
-	if s.DB == nil {
-		return errors.New("db required for scheduler")
+	if s.DB == nil && s.Locker == nil {
+		return errors.New("db or locker required for scheduler")
 	}
 	if s.Interval <= 0 {
 		s.Interval = time.Minute
 	}
+	sched := s.Schedule
+	if sched == nil {
+		sched = Every(s.Interval)
+	}
+	clock := s.clock()
+
+	// let a background drain finish before returning
+	defer s.drains.Wait()
 
-	ticker := time.NewTicker(s.Interval)
-	defer ticker.Stop()
+	// leftovers from an earlier process are drained after the first success
+	s.drainPending.Store(true)
 
 	// Run immediately once
-	if err := s.tryRunOnce(ctx, run); err != nil {
+	start := clock.Now()
+	ran, err := s.tryRunOnce(withScheduledTime(ctx, start), run)
+	if err != nil && !s.tolerant {
 		return err
 	}
+	s.afterRun(ctx, ran, err)
 
+	next := s.backOff(sched, sched.Next(start))
 	for {
+		if next.IsZero() {
+			return ErrScheduleExhausted
+		}
+		timer := clock.NewTimer(next.Sub(clock.Now()) + s.jitter())
 		select {
 		case <-ctx.Done():
+			timer.Stop()
 			return ctx.Err()
-		case <-ticker.C:
-			if err := s.tryRunOnce(ctx, run); err != nil {
-				// continue loop but surface error
-				fmt.Printf("scheduler run error: %v\n", err)
+		case <-timer.C():
+		}
+
+		due, following := s.dueActivations(sched, next, clock.Now())
+		for _, at := range due {
+			ran, err := s.tryRunOnce(withScheduledTime(ctx, at), run)
+			s.afterRun(ctx, ran, err)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil {
+				// stop catching up while backing off
+				break
 			}
 		}
+		next = s.backOff(sched, following)
+	}
+}
+
+// backOff moves next past the backoff delay after consecutive failures
+func (s *Scheduler) backOff(sched Schedule, next time.Time) time.Time {
+	delay := s.Backoff.Delay(s.failures)
+	if delay <= 0 {
+		return next
+	}
+	notBefore := s.clock().Now().Add(delay)
+	for !next.IsZero() && next.Before(notBefore) {
+		next = sched.Next(next)
+	}
+	log.Printf("%s: backing off after %d failures in a row, next run at %s\n", s.name(), s.failures, next.Format(time.RFC3339))
+	return next
+}
+
+// dueActivations lists the activations from next up to now that should run
+// according to the missed policy, and returns the first one after now
+func (s *Scheduler) dueActivations(sched Schedule, next, now time.Time) ([]time.Time, time.Time) {
+	limit := 1
+	if s.Missed == MissedCatchUp {
+		limit = s.MaxCatchUp
+		if limit <= 0 {
+			limit = DefaultMaxCatchUp
+		}
+	}
+	due := []time.Time{next}
+	skipped := 0
+	for {
+		next = sched.Next(next)
+		if next.IsZero() || next.After(now) {
+			break
+		}
+		// keep the most recent activations
+		due = append(due, next)
+		if len(due) > limit {
+			due = due[1:]
+			skipped++
+		}
+	}
+	if skipped > 0 {
+		log.Printf("%s: skipped %d missed activations\n", s.name(), skipped)
+	}
+	return due, next
+}
+
+func (s *Scheduler) name() string {
+	if s.Name == "" {
+		return "scheduler"
+	}
+	return s.Name
+}
+
+func (s *Scheduler) clock() Clock {
+	if s.Clock == nil {
+		return SystemClock{}
+	}
+	return s.Clock
+}
+
+func (s *Scheduler) jitter() time.Duration {
+	if s.Jitter <= 0 {
+		return 0
+	}
+	return rand.N(s.Jitter)
+}
+
+// afterRun tracks failed runs and starts the background drain once a run
+// succeeds while a drain is pending. A run skipped because another instance
+// held the lock proves nothing about the database and leaves the drain
+// pending.
+func (s *Scheduler) afterRun(ctx context.Context, ran bool, runErr error) {
+	if s.Drain == nil {
+		return
+	}
+	if runErr != nil {
+		s.drainPending.Store(true)
+		return
+	}
+	if !ran || !s.drainPending.Load() || !s.draining.CompareAndSwap(false, true) {
+		return
+	}
+	s.drainPending.Store(false)
+
+	s.drains.Add(1)
+	go func() {
+		defer s.drains.Done()
+		defer s.draining.Store(false)
+		if err := s.tryRunLocked(ctx, s.drainLocker(), s.Drain); err != nil {
+			s.drainPending.Store(true)
+			log.Printf("%s: drain: %v\n", s.name(), err)
+		}
+	}()
+}
+
+// locker returns the configured Locker, defaulting to the advisory lock
+func (s *Scheduler) locker() Locker {
+	s.lockInit.Do(func() {
+		if s.Locker == nil {
+			s.Locker = &AdvisoryLock{DB: s.DB, Key: s.LockKey}
+		}
+	})
+	return s.Locker
+}
+
+// drainLocker returns the Locker guarding the background drain
+func (s *Scheduler) drainLocker() Locker {
+	s.drainLockInit.Do(func() {
+		if s.DrainLocker == nil {
+			s.DrainLocker = &AdvisoryLock{DB: s.DB, Key: s.DrainLockKey}
+		}
+	})
+	return s.DrainLocker
+}
+
+// Holding reports whether this instance currently holds the job lock, i.e.
+// a run is in progress here
+func (s *Scheduler) Holding() bool {
+	return s.locker().Held()
+}
+
+// tryRunOnce attempts to acquire advisory lock and run the job within its
+// timeout, then counts failures and records the run, including runs skipped
+// because another instance held the lock. It reports whether the job ran.
+func (s *Scheduler) tryRunOnce(ctx context.Context, run Runner) (bool, error) {
+	clock := s.clock()
+	rec := RunRecord{Job: s.name(), Instance: s.Instance, StartedAt: clock.Now(), Status: RunSucceeded}
+	rec.ScheduledAt, _ = ScheduledTime(ctx)
+	ctx, rows := withRowCounter(ctx)
+
+	ran, err := s.runLocked(ctx, s.locker(), s.withTimeout(run))
+	rec.FinishedAt, rec.Rows = clock.Now(), rows.Load()
+	switch {
+	case err == nil && !ran:
+		rec.Status = RunSkipped
+	case err == nil:
+		s.failures = 0
+	case !ran:
+		s.failures++
+		rec.Status, rec.Error = RunFailed, "acquire lock: "+err.Error()
+		log.Printf("%s: acquire lock (%d failures in a row): %v\n", s.name(), s.failures, err)
+	default:
+		s.failures++
+		rec.Status, rec.Error = RunFailed, err.Error()
+		switch {
+		case errors.Is(err, ErrJobTimeout):
+			rec.Status = RunTimedOut
+		case errors.Is(err, ErrLockLost):
+			rec.Status = RunLockLost
+		}
+		log.Printf("%s: run failed (%d in a row): %v\n", s.name(), s.failures, err)
+	}
+	rec.Failures = s.failures
+	s.recordRun(ctx, rec)
+	if s.OnRun != nil {
+		s.OnRun(rec)
 	}
+	return ran, err
 }
 
-// tryRunOnce attempts to acquire advisory lock and run the job
-func (s *Scheduler) tryRunOnce(ctx context.Context, run Runner) error {
-	// acquire advisory lock
-	var got bool
-	row := s.DB.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", s.LockKey)
-	if err := row.Scan(&got); err != nil {
-		return fmt.Errorf("advisory lock check: %w", err)
+// recordRun passes rec to History within historyTimeout, also when ctx is
+// cancelled by a shutdown. After a failed write it skips recording for
+// historyRetry.
+func (s *Scheduler) recordRun(ctx context.Context, rec RunRecord) {
+	if s.History == nil {
+		return
+	}
+	now := s.clock().Now()
+	if !s.historyFailedAt.IsZero() && now.Sub(s.historyFailedAt) < historyRetry {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), historyTimeout)
+	defer cancel()
+	if err := s.History.Record(ctx, rec); err != nil {
+		s.historyFailedAt = now
+		log.Printf("%s: record run, not recording runs for %s: %v\n", s.name(), historyRetry, err)
+		return
+	}
+	s.historyFailedAt = time.Time{}
+}
+
+// withTimeout bounds run by Timeout
+func (s *Scheduler) withTimeout(run Runner) Runner {
+	if s.Timeout <= 0 {
+		return run
+	}
+	return func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeoutCause(ctx, s.Timeout, ErrJobTimeout)
+		defer cancel()
+		err := run(ctx)
+		if err != nil && errors.Is(context.Cause(ctx), ErrJobTimeout) {
+			return fmt.Errorf("%w after %s: %v", ErrJobTimeout, s.Timeout, err)
+		}
+		return err
+	}
+}
+
+// tryRunLocked runs the job only if lock can be acquired without waiting
+func (s *Scheduler) tryRunLocked(ctx context.Context, lock Locker, run Runner) error {
+	_, err := s.runLocked(ctx, lock, run)
+	return err
+}
+
+// runLocked reports whether the lock was acquired and the job ran
+func (s *Scheduler) runLocked(ctx context.Context, lock Locker, run Runner) (bool, error) {
+	got, err := lock.TryLock(ctx)
+	if err != nil {
+		return false, err
 	}
 	if !got {
 		// another instance is running
-		return nil
+		return false, nil
 	}
 	defer func() {
 		// release lock, best effort
-		s.DB.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", s.LockKey)
+		lock.Unlock(context.Background())
 	}()
 
+	// cancel the job if the lock is lost while it runs
+	graceCtx, stopGrace := withGrace(ctx, s.ShutdownGrace, s.clock())
+	defer stopGrace()
+	jobCtx, cancel := context.WithCancelCause(graceCtx)
+	defer cancel(nil)
+	if ld, ok := lock.(LossDetector); ok {
+		lost := ld.Watch(jobCtx)
+		go func() {
+			select {
+			case err := <-lost:
+				cancel(fmt.Errorf("%w: %v", ErrLockLost, err))
+			case <-jobCtx.Done():
+			}
+		}()
+	}
+
 	// run the function
-	return run(ctx)
+	err = run(jobCtx)
+	if cause := context.Cause(jobCtx); errors.Is(cause, ErrLockLost) {
+		return true, cause
+	}
+	return true, err
+}
+
+// WithGrace returns a context that is cancelled, with cause ErrShutdown,
+// grace after parent is done, so work in progress can finish on shutdown.
+// Values of parent are kept.
+func WithGrace(parent context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	return withGrace(parent, grace, SystemClock{})
+}
+
+func withGrace(parent context.Context, grace time.Duration, clock Clock) (context.Context, context.CancelFunc) {
+	if grace <= 0 {
+		return context.WithCancel(parent)
+	}
+	ctx, cancel := context.WithCancelCause(context.WithoutCancel(parent))
+	go func() {
+		select {
+		case <-parent.Done():
+		case <-ctx.Done():
+			return
+		}
+		timer := clock.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case <-timer.C():
+			cancel(ErrShutdown)
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() { cancel(context.Canceled) }
 }
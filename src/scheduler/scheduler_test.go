@@ -2,7 +2,11 @@ package scheduler
 
 import (
 	"context"
+	"errors"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 )
@@ -21,7 +25,7 @@ func TestTryRunOnce_LockAcquired(t *testing.T) {
 	mock.ExpectExec("SELECT pg_advisory_unlock\\(\\$1\\)").WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
 
 	called := false
-	err = s.tryRunOnce(context.Background(), func(ctx context.Context) error {
+	_, err = s.tryRunOnce(context.Background(), func(ctx context.Context) error {
 		called = true
 		return nil
 	})
@@ -49,7 +53,7 @@ func TestTryRunOnce_LockNotAcquired(t *testing.T) {
 	mock.ExpectQuery("SELECT pg_try_advisory_lock\\(\\$1\\)").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_lock"}).AddRow(false))
 
 	called := false
-	err = s.tryRunOnce(context.Background(), func(ctx context.Context) error {
+	_, err = s.tryRunOnce(context.Background(), func(ctx context.Context) error {
 		called = true
 		return nil
 	})
@@ -63,3 +67,327 @@ func TestTryRunOnce_LockNotAcquired(t *testing.T) {
 		t.Fatalf("unmet expectations: %v", err)
 	}
 }
+
+func TestTryRunOnce_OnRun(t *testing.T) {
+	var got []RunRecord
+	s := &Scheduler{
+		Name:   "poll",
+		Locker: &FileLock{Path: filepath.Join(t.TempDir(), "lock")},
+		OnRun:  func(r RunRecord) { got = append(got, r) },
+	}
+
+	s.tryRunOnce(context.Background(), func(ctx context.Context) error {
+		AddRows(ctx, 1)
+		return nil
+	})
+	s.tryRunOnce(context.Background(), func(ctx context.Context) error {
+		return errors.New("meter unreachable")
+	})
+
+	if len(got) != 2 {
+		t.Fatalf("OnRun called %d times, want 2", len(got))
+	}
+	if got[0].Job != "poll" || got[0].Status != RunSucceeded || got[0].Rows != 1 {
+		t.Errorf("first run: %+v", got[0])
+	}
+	if got[1].Status != RunFailed || got[1].Error != "meter unreachable" || got[1].Failures != 1 {
+		t.Errorf("second run: %+v", got[1])
+	}
+}
+
+func TestTryRunOnce_LockConnectionLost(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	lock := &AdvisoryLock{DB: db, Key: 1, CheckInterval: 10 * time.Millisecond}
+	s := &Scheduler{DB: db, Locker: lock}
+
+	mock.ExpectQuery("SELECT pg_try_advisory_lock\\(\\$1\\)").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_lock"}).AddRow(true))
+	mock.ExpectPing().WillReturnError(errors.New("connection reset by peer"))
+	mock.ExpectExec("SELECT pg_advisory_unlock\\(\\$1\\)").WithArgs(1).WillReturnError(errors.New("connection reset by peer"))
+
+	_, err = s.tryRunOnce(context.Background(), func(ctx context.Context) error {
+		if !s.Holding() {
+			t.Errorf("expected lock to be reported as held during the run")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+			t.Errorf("job context was not cancelled after the lock connection failed")
+			return nil
+		}
+	})
+	if !errors.Is(err, ErrLockLost) {
+		t.Fatalf("expected ErrLockLost, got %v", err)
+	}
+	if s.Holding() {
+		t.Errorf("expected lock released after the run")
+	}
+}
+
+// fakeClock is a Clock that only moves when Advance is called. Timers fire
+// once their deadline is reached; waits reports every timer created so a
+// test can wait until the scheduler is idle.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+	waits  chan time.Duration
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now, waits: make(chan time.Duration, 100)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	t := &fakeTimer{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.c <- c.now
+	} else {
+		c.timers = append(c.timers, t)
+	}
+	c.mu.Unlock()
+	c.waits <- d
+	return t
+}
+
+// AdvanceTo moves the clock to t and fires the timers that are due
+func (c *fakeClock) AdvanceTo(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+	kept := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.at.After(t) {
+			kept = append(kept, timer)
+			continue
+		}
+		timer.c <- t
+	}
+	c.timers = kept
+}
+
+// wait returns the duration of the next timer the code under test creates
+func (c *fakeClock) wait(t *testing.T) time.Duration {
+	t.Helper()
+	select {
+	case d := <-c.waits:
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no timer created")
+		return 0
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool { return true }
+
+type activation struct {
+	scheduled, ran time.Time
+}
+
+// runScheduler starts s with a job that reports each run's scheduled time
+// and the fake time it ran at
+func runScheduler(t *testing.T, s *Scheduler, clock *fakeClock) chan activation {
+	t.Helper()
+	s.Clock = clock
+	s.Locker = &FileLock{Path: filepath.Join(t.TempDir(), "lock")}
+	runs := make(chan activation, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(ctx, func(ctx context.Context) error {
+			at, _ := ScheduledTime(ctx)
+			runs <- activation{at, clock.Now()}
+			return nil
+		})
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return runs
+}
+
+func nextRun(t *testing.T, runs chan activation) activation {
+	t.Helper()
+	select {
+	case a := <-runs:
+		return a
+	case <-time.After(5 * time.Second):
+		t.Fatalf("job did not run")
+		return activation{}
+	}
+}
+
+func at(hh, mm int) time.Time {
+	return time.Date(2024, 3, 1, hh, mm, 0, 0, time.UTC)
+}
+
+func TestRun_AlignedToQuarterHours(t *testing.T) {
+	start := at(12, 7).Add(30 * time.Second)
+	clock := newFakeClock(start)
+	runs := runScheduler(t, &Scheduler{Schedule: Aligned(15 * time.Minute)}, clock)
+
+	if a := nextRun(t, runs); !a.ran.Equal(start) || !a.scheduled.Equal(start) {
+		t.Fatalf("first run should be immediate, got %+v", a)
+	}
+	if d := clock.wait(t); d != 7*time.Minute+30*time.Second {
+		t.Fatalf("expected to wait until 12:15, waiting %v", d)
+	}
+	for _, want := range []time.Time{at(12, 15), at(12, 30), at(12, 45)} {
+		clock.AdvanceTo(want)
+		if a := nextRun(t, runs); !a.ran.Equal(want) || !a.scheduled.Equal(want) {
+			t.Fatalf("expected run at %v, got %+v", want, a)
+		}
+		if d := clock.wait(t); d != 15*time.Minute {
+			t.Fatalf("expected to wait a quarter hour, waiting %v", d)
+		}
+	}
+}
+
+func TestRun_MissedActivations(t *testing.T) {
+	cases := []struct {
+		name   string
+		policy MissedPolicy
+		want   []time.Time
+	}{
+		{"skip", MissedSkip, []time.Time{at(13, 0)}},
+		{"catch-up keeps the most recent", MissedCatchUp, []time.Time{at(12, 30), at(12, 45), at(13, 0)}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := newFakeClock(at(12, 0))
+			s := &Scheduler{Schedule: Aligned(15 * time.Minute), Missed: tc.policy, MaxCatchUp: 3}
+			runs := runScheduler(t, s, clock)
+			nextRun(t, runs)
+			clock.wait(t)
+
+			// suspended from before 12:15 until 13:05
+			clock.AdvanceTo(at(13, 5))
+			for _, want := range tc.want {
+				if a := nextRun(t, runs); !a.scheduled.Equal(want) || !a.ran.Equal(at(13, 5)) {
+					t.Fatalf("expected activation %v run at 13:05, got %+v", want, a)
+				}
+			}
+			if d := clock.wait(t); d != 10*time.Minute {
+				t.Fatalf("expected to wait until 13:15, waiting %v", d)
+			}
+			select {
+			case a := <-runs:
+				t.Fatalf("unexpected run %+v", a)
+			default:
+			}
+		})
+	}
+}
+
+func TestRun_Jitter(t *testing.T) {
+	clock := newFakeClock(at(12, 0))
+	s := &Scheduler{Schedule: Aligned(15 * time.Minute), Jitter: time.Minute}
+	runs := runScheduler(t, s, clock)
+	nextRun(t, runs)
+
+	for i := 1; i <= 20; i++ {
+		d := clock.wait(t)
+		due := at(12, 0).Add(time.Duration(i) * 15 * time.Minute)
+		if wait := due.Sub(clock.Now()); d < wait || d >= wait+time.Minute {
+			t.Fatalf("wait %v outside [%v, %v)", d, wait, wait+time.Minute)
+		}
+		clock.AdvanceTo(clock.Now().Add(d))
+		if a := nextRun(t, runs); !a.scheduled.Equal(due) {
+			t.Fatalf("jitter must not shift the activation: got %v want %v", a.scheduled, due)
+		}
+	}
+}
+
+func TestRun_CronSchedule(t *testing.T) {
+	sched, err := ParseSchedule("0 9-17/4 * * mon-fri")
+	if err != nil {
+		t.Fatalf("ParseSchedule: %v", err)
+	}
+	// Friday afternoon
+	clock := newFakeClock(time.Date(2024, 3, 1, 14, 0, 0, 0, time.UTC))
+	runs := runScheduler(t, &Scheduler{Schedule: sched}, clock)
+	nextRun(t, runs)
+
+	for _, want := range []time.Time{
+		time.Date(2024, 3, 1, 17, 0, 0, 0, time.UTC),
+		time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC),
+		time.Date(2024, 3, 4, 13, 0, 0, 0, time.UTC),
+	} {
+		if d := clock.wait(t); !clock.Now().Add(d).Equal(want) {
+			t.Fatalf("expected next activation %v, got %v", want, clock.Now().Add(d))
+		}
+		clock.AdvanceTo(want)
+		nextRun(t, runs)
+	}
+}
+
+func TestRun_ShutdownGrace(t *testing.T) {
+	clock := newFakeClock(at(12, 0))
+	s := &Scheduler{Interval: time.Minute, ShutdownGrace: 10 * time.Second, Clock: clock,
+		Locker: &FileLock{Path: filepath.Join(t.TempDir(), "lock")}}
+	started := make(chan struct{})
+	stopped := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Run(ctx, func(ctx context.Context) error {
+			close(started)
+			<-ctx.Done()
+			stopped <- context.Cause(ctx)
+			return ctx.Err()
+		})
+	}()
+
+	<-started
+	cancel()
+	// the run outlives the cancelled scheduler context for the grace period
+	if d := clock.wait(t); d != 10*time.Second {
+		t.Fatalf("expected a 10s grace timer, got %v", d)
+	}
+	select {
+	case err := <-stopped:
+		t.Fatalf("run cancelled before the grace period ended: %v", err)
+	default:
+	}
+	clock.AdvanceTo(at(12, 0).Add(10 * time.Second))
+	if cause := <-stopped; !errors.Is(cause, ErrShutdown) {
+		t.Fatalf("expected cause ErrShutdown, got %v", cause)
+	}
+	if err := <-done; err == nil {
+		t.Fatalf("expected the interrupted first run to be reported")
+	}
+}
+
+func TestWithGrace_FinishesWithinGrace(t *testing.T) {
+	parent, cancel := context.WithCancel(context.Background())
+	ctx, stop := WithGrace(parent, time.Hour)
+	cancel()
+	if ctx.Err() != nil {
+		t.Fatalf("grace context cancelled with its parent")
+	}
+	stop()
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		t.Fatalf("expected stop to cancel, got %v", ctx.Err())
+	}
+}
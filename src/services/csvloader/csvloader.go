@@ -2,7 +2,9 @@ package csvloader
 
 import (
 	"encoding/csv"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -27,6 +29,19 @@ type MergedReading struct {
 	L2MaxW      float64
 	L3MaxW      float64
 	TotalGasM3  float64
+	// External holds the sub-meter counters read from the optional
+	// subMeterFiles at this time
+	External []models.ExternalReading
+}
+
+// subMeterFiles are the optional exports of sub-meters read through the
+// P1 meter, in the two-column layout of gas-15m.csv
+var subMeterFiles = []struct {
+	name, typ, unit string
+}{
+	{"water-15m.csv", "water_meter", "m3"},
+	{"warm-water-15m.csv", "warm_water_meter", "m3"},
+	{"heat-15m.csv", "heat_meter", "GJ"},
 }
 
 // LoadAndMerge reads both CSV files and merges them in memory
@@ -69,9 +84,39 @@ func (l *CSVLoader) LoadAndMerge() ([]MergedReading, error) {
 		}
 	}
 
+	if err := l.mergeSubMeters(merged); err != nil {
+		return nil, err
+	}
 	return merged, nil
 }
 
+// mergeSubMeters adds the counters of the sub-meter exports present in
+// DataDir to the merged rows with the same time. Rows without a matching
+// power row are skipped.
+func (l *CSVLoader) mergeSubMeters(merged []MergedReading) error {
+	byTime := make(map[time.Time]int, len(merged))
+	for i, m := range merged {
+		byTime[m.Time] = i
+	}
+	for _, f := range subMeterFiles {
+		records, err := l.readCounterCSV(filepath.Join(l.DataDir, f.name), f.name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("read %s: %w", f.name, err)
+		}
+		for _, rec := range records {
+			i, ok := byTime[rec.Time]
+			if !ok {
+				continue
+			}
+			merged[i].External = append(merged[i].External, models.ExternalReading{Type: f.typ, Value: rec.Value, Unit: f.unit})
+		}
+	}
+	return nil
+}
+
 // powerRecord represents a single row from power-15m.csv
 type powerRecord struct {
 	Time        time.Time
@@ -172,6 +217,26 @@ func (l *CSVLoader) readPowerCSV(path string) ([]powerRecord, error) {
 }
 
 func (l *CSVLoader) readGasCSV(path string) ([]gasRecord, error) {
+	counters, err := l.readCounterCSV(path, "gas CSV")
+	if err != nil {
+		return nil, err
+	}
+	records := make([]gasRecord, len(counters))
+	for i, c := range counters {
+		records[i] = gasRecord{Time: c.Time, TotalGasM3: c.Value}
+	}
+	return records, nil
+}
+
+// counterRecord is a row of a two-column export: time and meter counter
+type counterRecord struct {
+	Time  time.Time
+	Value float64
+}
+
+// readCounterCSV reads a two-column export such as gas-15m.csv; name is
+// used in errors
+func (l *CSVLoader) readCounterCSV(path, name string) ([]counterRecord, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -185,16 +250,16 @@ func (l *CSVLoader) readGasCSV(path string) ([]gasRecord, error) {
 	}
 
 	if len(rows) == 0 {
-		return nil, fmt.Errorf("gas CSV is empty")
+		return nil, fmt.Errorf("%s is empty", name)
 	}
 
 	// Skip header
 	rows = rows[1:]
-	records := make([]gasRecord, 0, len(rows))
+	records := make([]counterRecord, 0, len(rows))
 
 	for i, row := range rows {
 		if len(row) != 2 {
-			return nil, fmt.Errorf("gas CSV row %d has %d columns, expected 2", i+2, len(row))
+			return nil, fmt.Errorf("%s row %d has %d columns, expected 2", name, i+2, len(row))
 		}
 
 		t, err := time.Parse("2006-01-02 15:04", row[0])
@@ -202,14 +267,14 @@ func (l *CSVLoader) readGasCSV(path string) ([]gasRecord, error) {
 			return nil, fmt.Errorf("parse time at row %d: %w", i+2, err)
 		}
 
-		totalGas, err := strconv.ParseFloat(row[1], 64)
+		value, err := strconv.ParseFloat(row[1], 64)
 		if err != nil {
-			return nil, fmt.Errorf("parse TotalGas at row %d: %w", i+2, err)
+			return nil, fmt.Errorf("parse total at row %d: %w", i+2, err)
 		}
 
-		records = append(records, gasRecord{
-			Time:       t,
-			TotalGasM3: totalGas,
+		records = append(records, counterRecord{
+			Time:  t,
+			Value: value,
 		})
 	}
 
@@ -229,6 +294,7 @@ func (m *MergedReading) ToReading() models.Reading {
 		ActivePowerL2W: m.L2MaxW,
 		ActivePowerL3W: m.L3MaxW,
 		TotalGasM3:     m.TotalGasM3,
+		External:       m.External,
 	}
 }
 
@@ -3,8 +3,11 @@ package csvloader
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
+
+	"github.com/harrybawsac/p1-go/src/models"
 )
 
 // TestLoadPowerCSV tests reading a power CSV file
@@ -128,6 +131,53 @@ func TestLoadAndMerge(t *testing.T) {
 	}
 }
 
+// TestLoadAndMergeSubMeters tests merging the optional water and heat exports
+func TestLoadAndMergeSubMeters(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	files := map[string]string{
+		"power-15m.csv": `time,Import T1 kWh,Import T2 kWh,Export T1 kWh,Export T2 kWh,L1 max W,L2 max W,L3 max W
+2025-06-02 20:30,8293.146,7210.113,1916.077,4181.422,173,1212,67
+2025-06-02 20:45,8293.146,7210.236,1916.077,4181.422,127,48,77`,
+		"gas-15m.csv": `time,Total gas used
+2025-06-02 20:30,3488.524
+2025-06-02 20:45,3488.530`,
+		"water-15m.csv": `time,Total water used
+2025-06-02 20:30,412.108
+2025-06-02 20:45,412.150
+2025-06-02 21:00,412.200`,
+		"heat-15m.csv": `time,Total heat used
+2025-06-02 20:45,31.250`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	loader := &CSVLoader{DataDir: tmpDir}
+	merged, err := loader.LoadAndMerge()
+	if err != nil {
+		t.Fatalf("LoadAndMerge failed: %v", err)
+	}
+
+	if len(merged) != 2 {
+		t.Fatalf("expected 2 merged records, got %d", len(merged))
+	}
+	want0 := []models.ExternalReading{{Type: "water_meter", Value: 412.108, Unit: "m3"}}
+	if !reflect.DeepEqual(merged[0].External, want0) {
+		t.Errorf("unexpected first sub-meter readings: %+v", merged[0].External)
+	}
+	want1 := []models.ExternalReading{
+		{Type: "water_meter", Value: 412.150, Unit: "m3"},
+		{Type: "heat_meter", Value: 31.250, Unit: "GJ"},
+	}
+	r := merged[1].ToReading()
+	if !reflect.DeepEqual(r.External, want1) {
+		t.Errorf("unexpected second sub-meter readings: %+v", r.External)
+	}
+}
+
 // TestLoadAndMergeMismatchedLength tests error handling for mismatched CSV lengths
 func TestLoadAndMergeMismatchedLength(t *testing.T) {
 	tmpDir := t.TempDir()
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/models"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// ErrDuplicateReading is returned under ConflictFail when a reading with the
+// same meter_id and created_at is already stored.
+var ErrDuplicateReading = errors.New("reading already exists for meter and timestamp")
+
+// ConflictPolicy decides what an insert does when a reading with the same
+// (meter_id, created_at) key already exists.
+type ConflictPolicy string
+
+const (
+	// ConflictSkip keeps the stored reading and drops the new one (default)
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictOverwrite replaces the stored values with the new reading
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictFail returns ErrDuplicateReading
+	ConflictFail ConflictPolicy = "fail"
+)
+
+// ParseConflictPolicy validates a policy name from the CLI
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(s); p {
+	case ConflictSkip, ConflictOverwrite, ConflictFail:
+		return p, nil
+	case "":
+		return ConflictSkip, nil
+	default:
+		return "", fmt.Errorf("invalid conflict policy %q (want skip, overwrite or fail)", s)
+	}
+}
+
+// clause returns the ON CONFLICT suffix for an INSERT. The syntax is shared by
+// PostgreSQL and SQLite.
+func (p ConflictPolicy) clause() string {
+	switch p {
+	case ConflictFail:
+		return ""
+	case ConflictOverwrite:
+		var set []string
+		for _, c := range readingColumns {
+			if c == "meter_id" || c == "created_at" {
+				continue
+			}
+			set = append(set, fmt.Sprintf("%s = EXCLUDED.%s", c, c))
+		}
+		return " ON CONFLICT (meter_id, created_at) DO UPDATE SET " + strings.Join(set, ", ")
+	default:
+		return " ON CONFLICT (meter_id, created_at) DO NOTHING"
+	}
+}
+
+// dedupeReadings drops all but the last reading per (meter_id, created_at), as
+// a single statement may not touch the same conflict key twice. Under
+// ConflictFail a duplicate within the batch is an error like one already
+// stored, and readings is returned unchanged.
+func dedupeReadings(readings []models.Reading, policy ConflictPolicy) ([]models.Reading, error) {
+	type key struct {
+		meter string
+		at    int64
+	}
+	last := make(map[key]int, len(readings))
+	for i, r := range readings {
+		if r.CreatedAt.IsZero() {
+			continue
+		}
+		last[key{r.MeterID, r.CreatedAt.UnixNano()}] = i
+	}
+	if len(last) == len(readings) {
+		return readings, nil
+	}
+	if policy == ConflictFail {
+		for i, r := range readings {
+			if !r.CreatedAt.IsZero() && last[key{r.MeterID, r.CreatedAt.UnixNano()}] != i {
+				return readings, fmt.Errorf("%w: meter %q at %s occurs twice in the batch", ErrDuplicateReading, r.MeterID, r.CreatedAt.Format(time.RFC3339Nano))
+			}
+		}
+	}
+	out := make([]models.Reading, 0, len(readings))
+	for i, r := range readings {
+		if !r.CreatedAt.IsZero() && last[key{r.MeterID, r.CreatedAt.UnixNano()}] != i {
+			continue
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// duplicateError maps unique violations from either driver to
+// ErrDuplicateReading so callers can tell them apart from outages
+func duplicateError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return fmt.Errorf("%w: %v", ErrDuplicateReading, err)
+	}
+	var liteErr *sqlite.Error
+	if errors.As(err, &liteErr) && liteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+		return fmt.Errorf("%w: %v", ErrDuplicateReading, err)
+	}
+	return err
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/models"
+)
+
+func TestParseConflictPolicy(t *testing.T) {
+	for in, want := range map[string]ConflictPolicy{"": ConflictSkip, "skip": ConflictSkip, "overwrite": ConflictOverwrite, "fail": ConflictFail} {
+		got, err := ParseConflictPolicy(in)
+		if err != nil || got != want {
+			t.Errorf("ParseConflictPolicy(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseConflictPolicy("replace"); err == nil {
+		t.Errorf("expected error for unknown policy")
+	}
+}
+
+func TestConflictClause(t *testing.T) {
+	if c := ConflictFail.clause(); c != "" {
+		t.Errorf("fail: expected no clause, got %q", c)
+	}
+	if c := ConflictSkip.clause(); c != " ON CONFLICT (meter_id, created_at) DO NOTHING" {
+		t.Errorf("skip: unexpected clause %q", c)
+	}
+	c := ConflictOverwrite.clause()
+	if !strings.Contains(c, "DO UPDATE SET active_tariff = EXCLUDED.active_tariff") || strings.Contains(c, "created_at = ") {
+		t.Errorf("overwrite: unexpected clause %q", c)
+	}
+}
+
+func TestDedupeReadings(t *testing.T) {
+	at := time.Date(2025, 6, 2, 20, 0, 0, 0, time.UTC)
+	batch := []models.Reading{
+		{CreatedAt: at, TotalPowerImportKwh: 1},
+		{CreatedAt: at.Add(time.Minute), TotalPowerImportKwh: 2},
+		{CreatedAt: at, TotalPowerImportKwh: 3},
+		{CreatedAt: at, MeterID: "other", TotalPowerImportKwh: 4},
+	}
+	for _, policy := range []ConflictPolicy{ConflictSkip, ConflictOverwrite} {
+		got, err := dedupeReadings(batch, policy)
+		if err != nil {
+			t.Fatalf("%s: %v", policy, err)
+		}
+		if len(got) != 3 || got[0].TotalPowerImportKwh != 2 || got[1].TotalPowerImportKwh != 3 || got[2].TotalPowerImportKwh != 4 {
+			t.Errorf("%s: unexpected dedupe result: %+v", policy, got)
+		}
+	}
+	if _, err := dedupeReadings(batch, ConflictFail); !errors.Is(err, ErrDuplicateReading) {
+		t.Errorf("fail: expected ErrDuplicateReading, got %v", err)
+	}
+	if got, err := dedupeReadings(batch[:2], ConflictFail); err != nil || len(got) != 2 {
+		t.Errorf("fail without duplicates: got %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/models"
+)
+
+// DemandStore is implemented by stores that aggregate the capacity tariff
+// series in the database, so reports do not load every reading
+type DemandStore interface {
+	// QuarterHourDemand returns the demand of the quarter-hours starting in
+	// [from, to), oldest first
+	QuarterHourDemand(ctx context.Context, from, to time.Time) ([]models.Demand, error)
+	// PowerPeaks returns the distinct monthly peaks reported by the meters in
+	// readings taken in [from, to), oldest first
+	PowerPeaks(ctx context.Context, from, to time.Time) ([]models.PowerPeak, error)
+}
+
+// demandLookahead reaches one quarter-hour past the range so the last quarter
+// in range has the counter it ends at
+const demandLookahead = 15 * time.Minute
+
+func (p *PostgresAdapter) QuarterHourDemand(ctx context.Context, from, to time.Time) ([]models.Demand, error) {
+	rows, err := p.DB.QueryContext(ctx, `SELECT meter_id, quarter_start, average_w
+FROM p1.quarter_hour_demand_between($1, $2)
+WHERE quarter_start >= $1 AND quarter_start < $3
+ORDER BY quarter_start, meter_id`, from, to.Add(demandLookahead), to)
+	if err != nil {
+		return nil, fmt.Errorf("query demand: %w", err)
+	}
+	return scanDemand(rows, func(v any) (time.Time, error) {
+		t, ok := v.(time.Time)
+		if !ok {
+			return time.Time{}, fmt.Errorf("unexpected quarter_start %T", v)
+		}
+		return t, nil
+	})
+}
+
+func (p *PostgresAdapter) PowerPeaks(ctx context.Context, from, to time.Time) ([]models.PowerPeak, error) {
+	return queryPowerPeaks(ctx, p.DB, `SELECT meter_id, monthly_power_peak_at, max(monthly_power_peak_w)
+FROM p1.meter_readings
+WHERE created_at >= $1 AND created_at < $2 AND monthly_power_peak_w > 0 AND monthly_power_peak_at IS NOT NULL
+GROUP BY meter_id, monthly_power_peak_at
+ORDER BY monthly_power_peak_at, meter_id`, from, to)
+}
+
+func (s *SQLiteAdapter) QuarterHourDemand(ctx context.Context, from, to time.Time) ([]models.Demand, error) {
+	q := sqliteDemandSelect(" WHERE created_at >= ? AND created_at < ?")
+	rows, err := s.DB.QueryContext(ctx, `SELECT meter_id, quarter_start, average_w FROM (`+q+`)
+WHERE quarter_start >= ? AND quarter_start < ?
+ORDER BY quarter_start, meter_id`, from.UTC(), to.Add(demandLookahead).UTC(), from.Unix(), to.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("query demand: %w", err)
+	}
+	return scanDemand(rows, func(v any) (time.Time, error) {
+		n, ok := v.(int64)
+		if !ok {
+			return time.Time{}, fmt.Errorf("unexpected quarter_start %T", v)
+		}
+		return time.Unix(n, 0).UTC(), nil
+	})
+}
+
+func (s *SQLiteAdapter) PowerPeaks(ctx context.Context, from, to time.Time) ([]models.PowerPeak, error) {
+	return queryPowerPeaks(ctx, s.DB, `SELECT meter_id, monthly_power_peak_at, max(monthly_power_peak_w)
+FROM meter_readings
+WHERE created_at >= ? AND created_at < ? AND monthly_power_peak_w > 0 AND monthly_power_peak_at IS NOT NULL
+GROUP BY meter_id, monthly_power_peak_at
+ORDER BY monthly_power_peak_at, meter_id`, from.UTC(), to.UTC())
+}
+
+// scanDemand reads (meter_id, quarter_start, average_w) rows, converting
+// quarter_start with start as the drivers return it differently
+func scanDemand(rows *sql.Rows, start func(any) (time.Time, error)) ([]models.Demand, error) {
+	defer rows.Close()
+	var out []models.Demand
+	for rows.Next() {
+		var d models.Demand
+		var at any
+		if err := rows.Scan(&d.MeterID, &at, &d.AverageW); err != nil {
+			return nil, err
+		}
+		t, err := start(at)
+		if err != nil {
+			return nil, err
+		}
+		d.QuarterStart = t
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+func queryPowerPeaks(ctx context.Context, conn *sql.DB, q string, args ...any) ([]models.PowerPeak, error) {
+	rows, err := conn.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query power peaks: %w", err)
+	}
+	defer rows.Close()
+	var out []models.PowerPeak
+	for rows.Next() {
+		var p models.PowerPeak
+		if err := rows.Scan(&p.MeterID, &p.At, &p.PeakW); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,179 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/models"
+)
+
+// wifiSampleInterval is how often an unchanged Wi-Fi quality is recorded
+const wifiSampleInterval = time.Hour
+
+// extrasWriter writes the device metadata and external readings of inserted
+// readings inside the insert transaction. The SQL is shared by PostgreSQL
+// and SQLite; only table names and placeholders differ.
+type extrasWriter struct {
+	tx     *sql.Tx
+	prefix string // table name prefix, "p1." for PostgreSQL
+	ph     func(n int) string
+	policy ConflictPolicy
+}
+
+func (w extrasWriter) table(name string) string {
+	return w.prefix + name
+}
+
+// placeholders returns n placeholders separated by commas
+func (w extrasWriter) placeholders(n int) string {
+	out := ""
+	for i := 1; i <= n; i++ {
+		if i > 1 {
+			out += ", "
+		}
+		out += w.ph(i)
+	}
+	return out
+}
+
+// write stores the extras of every reading that carries some
+func (w extrasWriter) write(ctx context.Context, readings []models.Reading) error {
+	for _, r := range readings {
+		if r.Device != nil && r.Device.UniqueID != "" {
+			if err := w.device(ctx, r.CreatedAt, *r.Device); err != nil {
+				return fmt.Errorf("store device %s: %w", r.Device.UniqueID, err)
+			}
+		}
+		if len(r.External) > 0 {
+			if err := w.external(ctx, r); err != nil {
+				return fmt.Errorf("store external readings: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// device upserts the meter's row, keeping the metadata of the newest
+// reading, and samples its Wi-Fi quality
+func (w extrasWriter) device(ctx context.Context, at time.Time, d models.Device) error {
+	var first, last time.Time
+	err := w.tx.QueryRowContext(ctx, fmt.Sprintf("SELECT first_seen, last_seen FROM %s WHERE unique_id = %s",
+		w.table("devices"), w.ph(1)), d.UniqueID).Scan(&first, &last)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		_, err = w.tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (unique_id, meter_model, smr_version, wifi_ssid, wifi_strength, first_seen, last_seen)
+VALUES (%s) ON CONFLICT (unique_id) DO NOTHING`, w.table("devices"), w.placeholders(7)),
+			d.UniqueID, d.Model, d.SMRVersion, nullString(d.WifiSSID), nullWifiStrength(d), at, at)
+	case err != nil:
+		return err
+	case at.After(last):
+		_, err = w.tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET meter_model = %s, smr_version = %s, wifi_ssid = %s, wifi_strength = %s, last_seen = %s
+WHERE unique_id = %s`, w.table("devices"), w.ph(1), w.ph(2), w.ph(3), w.ph(4), w.ph(5), w.ph(6)),
+			d.Model, d.SMRVersion, nullString(d.WifiSSID), nullWifiStrength(d), at, d.UniqueID)
+	case at.Before(first):
+		// a buffered or imported reading older than any seen so far
+		_, err = w.tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET first_seen = %s WHERE unique_id = %s",
+			w.table("devices"), w.ph(1), w.ph(2)), at, d.UniqueID)
+	}
+	if err != nil || d.WifiSSID == "" {
+		return err
+	}
+	return w.wifi(ctx, at, d)
+}
+
+// wifi records the Wi-Fi quality when it changed since the last sample or
+// that sample is older than wifiSampleInterval
+func (w extrasWriter) wifi(ctx context.Context, at time.Time, d models.Device) error {
+	var last time.Time
+	var ssid string
+	var strength int
+	err := w.tx.QueryRowContext(ctx, fmt.Sprintf(`SELECT recorded_at, COALESCE(wifi_ssid, ''), COALESCE(wifi_strength, 0) FROM %s
+WHERE unique_id = %s ORDER BY recorded_at DESC LIMIT 1`, w.table("device_wifi"), w.ph(1)), d.UniqueID).Scan(&last, &ssid, &strength)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return err
+	case !at.After(last):
+		return nil
+	case ssid == d.WifiSSID && strength == d.WifiStrength && at.Sub(last) < wifiSampleInterval:
+		return nil
+	}
+	_, err = w.tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (unique_id, recorded_at, wifi_ssid, wifi_strength)
+VALUES (%s) ON CONFLICT DO NOTHING`, w.table("device_wifi"), w.placeholders(4)),
+		d.UniqueID, at, d.WifiSSID, d.WifiStrength)
+	return err
+}
+
+// external links the external readings of r to its meter_readings row.
+// Under the overwrite policy they replace those stored with an earlier copy
+// of the reading; otherwise stored ones are kept.
+func (w extrasWriter) external(ctx context.Context, r models.Reading) error {
+	var readingID int64
+	err := w.tx.QueryRowContext(ctx, fmt.Sprintf("SELECT id FROM %s WHERE meter_id = %s AND created_at = %s",
+		w.table("meter_readings"), w.ph(1), w.ph(2)), r.MeterID, r.CreatedAt).Scan(&readingID)
+	if err != nil {
+		return fmt.Errorf("find reading: %w", err)
+	}
+
+	onConflict := " ON CONFLICT (meter_reading_id, type, unique_id) DO NOTHING"
+	if w.policy == ConflictOverwrite {
+		onConflict = ` ON CONFLICT (meter_reading_id, type, unique_id) DO UPDATE SET
+	kind = EXCLUDED.kind, timestamp = EXCLUDED.timestamp, value = EXCLUDED.value, unit = EXCLUDED.unit`
+	}
+	insert := fmt.Sprintf("INSERT INTO %s (meter_reading_id, created_at, unique_id, type, kind, timestamp, value, unit) VALUES (%s)%s",
+		w.table("external_readings"), w.placeholders(8), onConflict)
+	for _, e := range r.External {
+		if _, err := w.tx.ExecContext(ctx, insert, readingID, r.CreatedAt, e.UniqueID, e.Type, e.Kind(), e.Timestamp, e.Value, e.Unit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadExternal attaches the external readings stored with readings, which
+// were read with from <= created_at < to. table is the external_readings
+// table and ph renders placeholders.
+func loadExternal(ctx context.Context, conn *sql.DB, table string, ph func(n int) string, readings []models.Reading, from, to time.Time) error {
+	if len(readings) == 0 {
+		return nil
+	}
+	byID := make(map[int64]int, len(readings))
+	for i, r := range readings {
+		byID[r.ID] = i
+	}
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf(`SELECT meter_reading_id, unique_id, type, COALESCE(timestamp, 0), COALESCE(value, 0), COALESCE(unit, '')
+FROM %s WHERE created_at >= %s AND created_at < %s ORDER BY meter_reading_id, id`, table, ph(1), ph(2)), from, to)
+	if err != nil {
+		return fmt.Errorf("query external readings: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var e models.ExternalReading
+		if err := rows.Scan(&id, &e.UniqueID, &e.Type, &e.Timestamp, &e.Value, &e.Unit); err != nil {
+			return err
+		}
+		if i, ok := byID[id]; ok {
+			readings[i].External = append(readings[i].External, e)
+		}
+	}
+	return rows.Err()
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// nullWifiStrength leaves the strength NULL for meters without Wi-Fi
+func nullWifiStrength(d models.Device) interface{} {
+	if d.WifiSSID == "" {
+		return nil
+	}
+	return d.WifiStrength
+}
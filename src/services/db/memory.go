@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/models"
+)
+
+// MemoryStore is an in-process Store for tests and dry runs. Readings are
+// kept in insertion order and lost when the process exits.
+type MemoryStore struct {
+	mu         sync.Mutex
+	readings   []models.Reading
+	nextID     int64
+	onConflict ConflictPolicy
+}
+
+func init() {
+	Register("memory", func(string) (Store, error) { return NewMemoryStore(), nil })
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{nextID: 1}
+}
+
+func (m *MemoryStore) InsertReading(ctx context.Context, r models.Reading) error {
+	return m.InsertReadingsBatch(ctx, []models.Reading{r})
+}
+
+func (m *MemoryStore) InsertReadingsBatch(ctx context.Context, readings []models.Reading) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := dedupeReadings(readings, m.onConflict); err != nil {
+		return err
+	}
+	for _, r := range readings {
+		if r.CreatedAt.IsZero() {
+			r.CreatedAt = time.Now().UTC()
+		}
+		if i := m.find(r.MeterID, r.CreatedAt); i >= 0 {
+			switch m.onConflict {
+			case ConflictFail:
+				return ErrDuplicateReading
+			case ConflictOverwrite:
+				r.ID = m.readings[i].ID
+				m.readings[i] = r
+			}
+			continue
+		}
+		r.ID = m.nextID
+		m.nextID++
+		m.readings = append(m.readings, r)
+	}
+	return nil
+}
+
+// find returns the index of the reading with the given key, or -1
+func (m *MemoryStore) find(meterID string, at time.Time) int {
+	for i, r := range m.readings {
+		if r.MeterID == meterID && r.CreatedAt.Equal(at) {
+			return i
+		}
+	}
+	return -1
+}
+
+// SetConflictPolicy sets how duplicate readings are handled
+func (m *MemoryStore) SetConflictPolicy(policy ConflictPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onConflict = policy
+}
+
+// QueryRange returns readings with from <= created_at < to, oldest first
+func (m *MemoryStore) QueryRange(ctx context.Context, from, to time.Time) ([]models.Reading, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []models.Reading
+	for _, r := range m.readings {
+		if !r.CreatedAt.Before(from) && r.CreatedAt.Before(to) {
+			out = append(out, r)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+// LatestReading returns the reading with the newest created_at
+func (m *MemoryStore) LatestReading(ctx context.Context) (models.Reading, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.readings) == 0 {
+		return models.Reading{}, ErrNoReadings
+	}
+	latest := m.readings[0]
+	for _, r := range m.readings[1:] {
+		if r.CreatedAt.After(latest.CreatedAt) {
+			latest = r
+		}
+	}
+	return latest, nil
+}
+
+func (m *MemoryStore) Close() error { return nil }
+
+// Readings returns a copy of all stored readings in insertion order
+func (m *MemoryStore) Readings() []models.Reading {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]models.Reading(nil), m.readings...)
+}
@@ -8,10 +8,74 @@ import (
 	"time"
 
 	"github.com/harrybawsac/p1-go/src/models"
+	_ "github.com/lib/pq"
 )
 
+// readingColumns lists the p1.meter_readings columns written for a Reading,
+// in the order used for insert arguments and scans
+var readingColumns = []string{
+	"created_at", "active_tariff",
+	"total_power_import_kwh", "total_power_import_t1_kwh", "total_power_import_t2_kwh",
+	"total_power_export_kwh", "total_power_export_t1_kwh", "total_power_export_t2_kwh",
+	"active_power_w", "active_power_l1_w", "active_power_l2_w", "active_power_l3_w",
+	"active_voltage_l1_v", "active_voltage_l2_v", "active_voltage_l3_v",
+	"active_current_a", "active_current_l1_a", "active_current_l2_a", "active_current_l3_a",
+	"voltage_sag_l1_count", "voltage_sag_l2_count", "voltage_sag_l3_count",
+	"voltage_swell_l1_count", "voltage_swell_l2_count", "voltage_swell_l3_count",
+	"any_power_fail_count", "long_power_fail_count", "total_gas_m3", "gas_timestamp",
+	"meter_id", "gas_measured_at",
+	"active_power_average_w", "monthly_power_peak_w", "monthly_power_peak_timestamp", "monthly_power_peak_at",
+}
+
+// readingArgs returns the insert arguments for r in readingColumns order
+func readingArgs(r models.Reading) []interface{} {
+	return []interface{}{
+		r.CreatedAt, r.ActiveTariff,
+		r.TotalPowerImportKwh, r.TotalPowerImportT1Kwh, r.TotalPowerImportT2Kwh,
+		r.TotalPowerExportKwh, r.TotalPowerExportT1Kwh, r.TotalPowerExportT2Kwh,
+		r.ActivePowerW, r.ActivePowerL1W, r.ActivePowerL2W, r.ActivePowerL3W,
+		r.ActiveVoltageL1V, r.ActiveVoltageL2V, r.ActiveVoltageL3V,
+		r.ActiveCurrentA, r.ActiveCurrentL1A, r.ActiveCurrentL2A, r.ActiveCurrentL3A,
+		r.VoltageSagL1Count, r.VoltageSagL2Count, r.VoltageSagL3Count,
+		r.VoltageSwellL1Count, r.VoltageSwellL2Count, r.VoltageSwellL3Count,
+		r.AnyPowerFailCount, r.LongPowerFailCount, r.TotalGasM3, r.GasTimestamp,
+		r.MeterID, nullTime(r.GasMeasuredAt),
+		r.ActivePowerAverageW, r.MonthlyPowerPeakW, r.MonthlyPowerPeakTimestamp, nullTime(r.MonthlyPowerPeakAt),
+	}
+}
+
+// sqlTime renders t as a SQL literal, NULL for the zero time
+func sqlTime(t time.Time) string {
+	if t.IsZero() {
+		return "NULL"
+	}
+	return fmt.Sprintf("'%s'", t.Format("2006-01-02 15:04:05-07:00"))
+}
+
+// nullTime stores a zero time as NULL
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC()
+}
+
+// PostgresAdapter is the Store implementation backed by PostgreSQL
 type PostgresAdapter struct {
 	DB *sql.DB
+	// OnConflict decides how duplicate (meter_id, created_at) rows are
+	// handled; the zero value skips them.
+	OnConflict ConflictPolicy
+}
+
+func init() {
+	Register("postgres", func(dsn string) (Store, error) {
+		conn, err := sql.Open("postgres", dsn)
+		if err != nil {
+			return nil, err
+		}
+		return &PostgresAdapter{DB: conn}, nil
+	})
 }
 
 func (p *PostgresAdapter) InsertReading(ctx context.Context, r models.Reading) error {
@@ -24,34 +88,14 @@ func (p *PostgresAdapter) InsertReading(ctx context.Context, r models.Reading) e
 			tx.Rollback()
 		}
 	}()
-	cols := []string{
-		"created_at", "active_tariff",
-		"total_power_import_kwh", "total_power_import_t1_kwh", "total_power_import_t2_kwh",
-		"total_power_export_kwh", "total_power_export_t1_kwh", "total_power_export_t2_kwh",
-		"active_power_w", "active_power_l1_w", "active_power_l2_w", "active_power_l3_w",
-		"active_voltage_l1_v", "active_voltage_l2_v", "active_voltage_l3_v",
-		"active_current_a", "active_current_l1_a", "active_current_l2_a", "active_current_l3_a",
-		"voltage_sag_l1_count", "voltage_sag_l2_count", "voltage_sag_l3_count",
-		"voltage_swell_l1_count", "voltage_swell_l2_count", "voltage_swell_l3_count",
-		"any_power_fail_count", "long_power_fail_count", "total_gas_m3", "gas_timestamp",
-	}
+	cols := readingColumns
 
 	// ensure CreatedAt is set (DB default is now() but we include explicit value for reproducibility)
 	if r.CreatedAt.IsZero() {
 		r.CreatedAt = time.Now().UTC()
 	}
 
-	args := []interface{}{
-		r.CreatedAt, r.ActiveTariff,
-		r.TotalPowerImportKwh, r.TotalPowerImportT1Kwh, r.TotalPowerImportT2Kwh,
-		r.TotalPowerExportKwh, r.TotalPowerExportT1Kwh, r.TotalPowerExportT2Kwh,
-		r.ActivePowerW, r.ActivePowerL1W, r.ActivePowerL2W, r.ActivePowerL3W,
-		r.ActiveVoltageL1V, r.ActiveVoltageL2V, r.ActiveVoltageL3V,
-		r.ActiveCurrentA, r.ActiveCurrentL1A, r.ActiveCurrentL2A, r.ActiveCurrentL3A,
-		r.VoltageSagL1Count, r.VoltageSagL2Count, r.VoltageSagL3Count,
-		r.VoltageSwellL1Count, r.VoltageSwellL2Count, r.VoltageSwellL3Count,
-		r.AnyPowerFailCount, r.LongPowerFailCount, r.TotalGasM3, r.GasTimestamp,
-	}
+	args := readingArgs(r)
 
 	// build placeholders
 	ph := make([]string, len(args))
@@ -60,13 +104,21 @@ func (p *PostgresAdapter) InsertReading(ctx context.Context, r models.Reading) e
 	}
 
 	// Insert a new meter_readings row and return its id
-	insert := fmt.Sprintf("INSERT INTO p1.meter_readings (%s) VALUES (%s) RETURNING id",
-		strings.Join(cols, ", "), strings.Join(ph, ","))
+	insert := fmt.Sprintf("INSERT INTO p1.meter_readings (%s) VALUES (%s)%s RETURNING id",
+		strings.Join(cols, ", "), strings.Join(ph, ","), p.OnConflict.clause())
 
 	var readingID int64
-	if err := tx.QueryRowContext(ctx, insert, args...).Scan(&readingID); err != nil {
+	err = tx.QueryRowContext(ctx, insert, args...).Scan(&readingID)
+	if err == sql.ErrNoRows {
+		// skipped by ON CONFLICT DO NOTHING
+		err = nil
+	}
+	if err != nil {
 		tx.Rollback()
-		return fmt.Errorf("insert reading: %w", err)
+		return fmt.Errorf("insert reading: %w", duplicateError(err))
+	}
+	if err = p.extras(tx).write(ctx, []models.Reading{r}); err != nil {
+		return err
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -81,6 +133,11 @@ func (p *PostgresAdapter) InsertReadingsBatch(ctx context.Context, readings []mo
 		return nil
 	}
 
+	deduped, err := dedupeReadings(readings, p.OnConflict)
+	if err != nil {
+		return err
+	}
+
 	tx, err := p.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
@@ -91,25 +148,18 @@ func (p *PostgresAdapter) InsertReadingsBatch(ctx context.Context, readings []mo
 		}
 	}()
 
-	cols := []string{
-		"created_at", "active_tariff",
-		"total_power_import_kwh", "total_power_import_t1_kwh", "total_power_import_t2_kwh",
-		"total_power_export_kwh", "total_power_export_t1_kwh", "total_power_export_t2_kwh",
-		"active_power_w", "active_power_l1_w", "active_power_l2_w", "active_power_l3_w",
-		"active_voltage_l1_v", "active_voltage_l2_v", "active_voltage_l3_v",
-		"active_current_a", "active_current_l1_a", "active_current_l2_a", "active_current_l3_a",
-		"voltage_sag_l1_count", "voltage_sag_l2_count", "voltage_sag_l3_count",
-		"voltage_swell_l1_count", "voltage_swell_l2_count", "voltage_swell_l3_count",
-		"any_power_fail_count", "long_power_fail_count", "total_gas_m3", "gas_timestamp",
-	}
+	cols := readingColumns
 
 	// Build multi-row insert statement
 	var valueStrings []string
 	var valueArgs []interface{}
 
+	// copied, as the creation time is filled in for the extras
+	readings = append([]models.Reading(nil), deduped...)
 	for i, r := range readings {
 		if r.CreatedAt.IsZero() {
 			r.CreatedAt = time.Now().UTC()
+			readings[i].CreatedAt = r.CreatedAt
 		}
 
 		// Create placeholder string for this row
@@ -120,25 +170,18 @@ func (p *PostgresAdapter) InsertReadingsBatch(ctx context.Context, readings []mo
 		valueStrings = append(valueStrings, fmt.Sprintf("(%s)", strings.Join(rowPlaceholders, ",")))
 
 		// Add values for this row
-		valueArgs = append(valueArgs,
-			r.CreatedAt, r.ActiveTariff,
-			r.TotalPowerImportKwh, r.TotalPowerImportT1Kwh, r.TotalPowerImportT2Kwh,
-			r.TotalPowerExportKwh, r.TotalPowerExportT1Kwh, r.TotalPowerExportT2Kwh,
-			r.ActivePowerW, r.ActivePowerL1W, r.ActivePowerL2W, r.ActivePowerL3W,
-			r.ActiveVoltageL1V, r.ActiveVoltageL2V, r.ActiveVoltageL3V,
-			r.ActiveCurrentA, r.ActiveCurrentL1A, r.ActiveCurrentL2A, r.ActiveCurrentL3A,
-			r.VoltageSagL1Count, r.VoltageSagL2Count, r.VoltageSagL3Count,
-			r.VoltageSwellL1Count, r.VoltageSwellL2Count, r.VoltageSwellL3Count,
-			r.AnyPowerFailCount, r.LongPowerFailCount, r.TotalGasM3, r.GasTimestamp,
-		)
-	}
-
-	insert := fmt.Sprintf("INSERT INTO p1.meter_readings (%s) VALUES %s",
-		strings.Join(cols, ", "), strings.Join(valueStrings, ","))
+		valueArgs = append(valueArgs, readingArgs(r)...)
+	}
+
+	insert := fmt.Sprintf("INSERT INTO p1.meter_readings (%s) VALUES %s%s",
+		strings.Join(cols, ", "), strings.Join(valueStrings, ","), p.OnConflict.clause())
 
 	if _, err := tx.ExecContext(ctx, insert, valueArgs...); err != nil {
 		tx.Rollback()
-		return fmt.Errorf("insert batch: %w", err)
+		return fmt.Errorf("insert batch: %w", duplicateError(err))
+	}
+	if err = p.extras(tx).write(ctx, readings); err != nil {
+		return err
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -153,21 +196,13 @@ func (p *PostgresAdapter) GenerateInsertSQL(readings []models.Reading) string {
 		return ""
 	}
 
-	cols := []string{
-		"created_at", "active_tariff",
-		"total_power_import_kwh", "total_power_import_t1_kwh", "total_power_import_t2_kwh",
-		"total_power_export_kwh", "total_power_export_t1_kwh", "total_power_export_t2_kwh",
-		"active_power_w", "active_power_l1_w", "active_power_l2_w", "active_power_l3_w",
-		"active_voltage_l1_v", "active_voltage_l2_v", "active_voltage_l3_v",
-		"active_current_a", "active_current_l1_a", "active_current_l2_a", "active_current_l3_a",
-		"voltage_sag_l1_count", "voltage_sag_l2_count", "voltage_sag_l3_count",
-		"voltage_swell_l1_count", "voltage_swell_l2_count", "voltage_swell_l3_count",
-		"any_power_fail_count", "long_power_fail_count", "total_gas_m3", "gas_timestamp",
-	}
+	cols := readingColumns
 
 	var valueRows []string
 
-	for _, r := range readings {
+	// under ConflictFail duplicates are kept and fail the statement
+	rows, _ := dedupeReadings(readings, p.OnConflict)
+	for _, r := range rows {
 		if r.CreatedAt.IsZero() {
 			r.CreatedAt = time.Now().UTC()
 		}
@@ -202,13 +237,119 @@ func (p *PostgresAdapter) GenerateInsertSQL(readings []models.Reading) string {
 			fmt.Sprintf("%d", r.LongPowerFailCount),
 			fmt.Sprintf("%f", r.TotalGasM3),
 			fmt.Sprintf("%d", r.GasTimestamp),
+			fmt.Sprintf("'%s'", strings.ReplaceAll(r.MeterID, "'", "''")),
+			sqlTime(r.GasMeasuredAt),
+			fmt.Sprintf("%f", r.ActivePowerAverageW),
+			fmt.Sprintf("%f", r.MonthlyPowerPeakW),
+			fmt.Sprintf("%d", r.MonthlyPowerPeakTimestamp),
+			sqlTime(r.MonthlyPowerPeakAt),
 		}
 
 		valueRows = append(valueRows, fmt.Sprintf("(%s)", strings.Join(values, ", ")))
 	}
 
-	stmt := fmt.Sprintf("INSERT INTO p1.meter_readings (%s) VALUES\n  %s;",
-		strings.Join(cols, ", "), strings.Join(valueRows, ",\n  "))
+	stmt := fmt.Sprintf("INSERT INTO p1.meter_readings (%s) VALUES\n  %s%s;",
+		strings.Join(cols, ", "), strings.Join(valueRows, ",\n  "), p.OnConflict.clause())
 
 	return stmt
 }
+
+// QueryRange returns readings with from <= created_at < to, oldest first,
+// with their external readings
+func (p *PostgresAdapter) QueryRange(ctx context.Context, from, to time.Time) ([]models.Reading, error) {
+	q := fmt.Sprintf("SELECT id, %s FROM p1.meter_readings WHERE created_at >= $1 AND created_at < $2 ORDER BY created_at",
+		selectList())
+	rows, err := p.DB.QueryContext(ctx, q, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("query range: %w", err)
+	}
+	defer rows.Close()
+
+	var out []models.Reading
+	for rows.Next() {
+		r, err := scanReading(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := loadExternal(ctx, p.DB, "p1.external_readings", func(n int) string { return fmt.Sprintf("$%d", n) }, out, from, to); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// LatestReading returns the most recent reading or ErrNoReadings
+func (p *PostgresAdapter) LatestReading(ctx context.Context) (models.Reading, error) {
+	q := fmt.Sprintf("SELECT id, %s FROM p1.meter_readings ORDER BY created_at DESC LIMIT 1", selectList())
+	r, err := scanReading(p.DB.QueryRowContext(ctx, q))
+	if err == sql.ErrNoRows {
+		return models.Reading{}, ErrNoReadings
+	}
+	if err != nil {
+		return models.Reading{}, fmt.Errorf("latest reading: %w", err)
+	}
+	return r, nil
+}
+
+// extras writes devices and external readings in tx
+func (p *PostgresAdapter) extras(tx *sql.Tx) extrasWriter {
+	return extrasWriter{tx: tx, prefix: "p1.", ph: func(n int) string { return fmt.Sprintf("$%d", n) }, policy: p.OnConflict}
+}
+
+// SetConflictPolicy sets how duplicate readings are handled
+func (p *PostgresAdapter) SetConflictPolicy(policy ConflictPolicy) {
+	p.OnConflict = policy
+}
+
+// Close closes the underlying connection pool
+func (p *PostgresAdapter) Close() error {
+	return p.DB.Close()
+}
+
+// selectList returns readingColumns with nullable columns coalesced to zero
+func selectList() string {
+	list := make([]string, len(readingColumns))
+	for i, c := range readingColumns {
+		if c == "created_at" || c == "meter_id" || c == "gas_measured_at" || c == "monthly_power_peak_at" {
+			list[i] = c
+			continue
+		}
+		list[i] = fmt.Sprintf("COALESCE(%s, 0)", c)
+	}
+	return strings.Join(list, ", ")
+}
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanReading scans "id, readingColumns..." into a Reading
+func scanReading(row rowScanner) (models.Reading, error) {
+	var r models.Reading
+	var gasMeasuredAt, peakAt sql.NullTime
+	err := row.Scan(&r.ID,
+		&r.CreatedAt, &r.ActiveTariff,
+		&r.TotalPowerImportKwh, &r.TotalPowerImportT1Kwh, &r.TotalPowerImportT2Kwh,
+		&r.TotalPowerExportKwh, &r.TotalPowerExportT1Kwh, &r.TotalPowerExportT2Kwh,
+		&r.ActivePowerW, &r.ActivePowerL1W, &r.ActivePowerL2W, &r.ActivePowerL3W,
+		&r.ActiveVoltageL1V, &r.ActiveVoltageL2V, &r.ActiveVoltageL3V,
+		&r.ActiveCurrentA, &r.ActiveCurrentL1A, &r.ActiveCurrentL2A, &r.ActiveCurrentL3A,
+		&r.VoltageSagL1Count, &r.VoltageSagL2Count, &r.VoltageSagL3Count,
+		&r.VoltageSwellL1Count, &r.VoltageSwellL2Count, &r.VoltageSwellL3Count,
+		&r.AnyPowerFailCount, &r.LongPowerFailCount, &r.TotalGasM3, &r.GasTimestamp,
+		&r.MeterID, &gasMeasuredAt,
+		&r.ActivePowerAverageW, &r.MonthlyPowerPeakW, &r.MonthlyPowerPeakTimestamp, &peakAt,
+	)
+	if gasMeasuredAt.Valid {
+		r.GasMeasuredAt = gasMeasuredAt.Time
+	}
+	if peakAt.Valid {
+		r.MonthlyPowerPeakAt = peakAt.Time
+	}
+	return r, err
+}
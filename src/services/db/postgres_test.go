@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"database/sql/driver"
 	"strings"
 	"testing"
 	"time"
@@ -51,6 +52,8 @@ func TestInsertReadingsBatch(t *testing.T) {
 			readings[0].VoltageSagL1Count, readings[0].VoltageSagL2Count, readings[0].VoltageSagL3Count,
 			readings[0].VoltageSwellL1Count, readings[0].VoltageSwellL2Count, readings[0].VoltageSwellL3Count,
 			readings[0].AnyPowerFailCount, readings[0].LongPowerFailCount, readings[0].TotalGasM3, readings[0].GasTimestamp,
+			readings[0].MeterID, nil,
+			readings[0].ActivePowerAverageW, readings[0].MonthlyPowerPeakW, readings[0].MonthlyPowerPeakTimestamp, nil,
 			// Second reading
 			readings[1].CreatedAt, readings[1].ActiveTariff,
 			readings[1].TotalPowerImportKwh, readings[1].TotalPowerImportT1Kwh, readings[1].TotalPowerImportT2Kwh,
@@ -61,6 +64,8 @@ func TestInsertReadingsBatch(t *testing.T) {
 			readings[1].VoltageSagL1Count, readings[1].VoltageSagL2Count, readings[1].VoltageSagL3Count,
 			readings[1].VoltageSwellL1Count, readings[1].VoltageSwellL2Count, readings[1].VoltageSwellL3Count,
 			readings[1].AnyPowerFailCount, readings[1].LongPowerFailCount, readings[1].TotalGasM3, readings[1].GasTimestamp,
+			readings[1].MeterID, nil,
+			readings[1].ActivePowerAverageW, readings[1].MonthlyPowerPeakW, readings[1].MonthlyPowerPeakTimestamp, nil,
 		).
 		WillReturnResult(sqlmock.NewResult(0, 2))
 
@@ -236,3 +241,202 @@ func TestInsertReadingsBatchSetsCreatedAt(t *testing.T) {
 		t.Errorf("unfulfilled expectations: %v", err)
 	}
 }
+
+// TestLatestReading tests scanning the newest reading
+func TestLatestReading(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock db: %v", err)
+	}
+	defer db.Close()
+
+	adapter := &PostgresAdapter{DB: db}
+
+	created := time.Date(2025, 6, 2, 20, 30, 0, 0, time.UTC)
+	cols := append([]string{"id"}, readingColumns...)
+	values := make([]driver.Value, len(cols))
+	for i := range values {
+		values[i] = 0
+	}
+	values[0] = int64(7)
+	values[1] = created
+	values[3] = 16152.335
+	for i, c := range cols {
+		if c == "gas_measured_at" || c == "monthly_power_peak_at" {
+			values[i] = nil
+		}
+	}
+	mock.ExpectQuery("SELECT id, created_at, .* FROM p1.meter_readings ORDER BY created_at DESC LIMIT 1").
+		WillReturnRows(sqlmock.NewRows(cols).AddRow(values...))
+
+	r, err := adapter.LatestReading(context.Background())
+	if err != nil {
+		t.Fatalf("LatestReading failed: %v", err)
+	}
+	if r.ID != 7 || !r.CreatedAt.Equal(created) || r.TotalPowerImportKwh != 16152.335 {
+		t.Errorf("unexpected reading: %+v", r)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+// TestLatestReadingEmpty tests that an empty table maps to ErrNoReadings
+func TestLatestReadingEmpty(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock db: %v", err)
+	}
+	defer db.Close()
+
+	adapter := &PostgresAdapter{DB: db}
+
+	mock.ExpectQuery("SELECT id, .* FROM p1.meter_readings").
+		WillReturnRows(sqlmock.NewRows(append([]string{"id"}, readingColumns...)))
+
+	if _, err := adapter.LatestReading(context.Background()); err != ErrNoReadings {
+		t.Errorf("expected ErrNoReadings, got %v", err)
+	}
+}
+
+// TestQueryRange tests that the range bounds are passed through
+func TestQueryRange(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock db: %v", err)
+	}
+	defer db.Close()
+
+	adapter := &PostgresAdapter{DB: db}
+
+	from := time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+	mock.ExpectQuery("SELECT id, .* FROM p1.meter_readings WHERE created_at >= \\$1 AND created_at < \\$2").
+		WithArgs(from, to).
+		WillReturnRows(sqlmock.NewRows(append([]string{"id"}, readingColumns...)))
+
+	readings, err := adapter.QueryRange(context.Background(), from, to)
+	if err != nil {
+		t.Fatalf("QueryRange failed: %v", err)
+	}
+	if len(readings) != 0 {
+		t.Errorf("expected no readings, got %d", len(readings))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+// TestInsertReadingWritesDeviceAndExternal checks that the device row and the
+// external readings are written in the insert transaction
+func TestInsertReadingWritesDeviceAndExternal(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock db: %v", err)
+	}
+	defer db.Close()
+
+	adapter := &PostgresAdapter{DB: db}
+	at := time.Date(2025, 10, 3, 8, 0, 0, 0, time.UTC)
+	r := models.Reading{
+		CreatedAt: at,
+		MeterID:   "M1",
+		Device:    &models.Device{UniqueID: "M1", Model: "ISK5", SMRVersion: 50},
+		External:  []models.ExternalReading{{UniqueID: "W1", Type: "water_meter", Timestamp: 251003100000, Value: 12.5, Unit: "m3"}},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO p1.meter_readings").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectQuery("SELECT first_seen, last_seen FROM p1.devices").WithArgs("M1").WillReturnRows(sqlmock.NewRows([]string{"first_seen", "last_seen"}))
+	mock.ExpectExec("INSERT INTO p1.devices").WithArgs("M1", "ISK5", 50, nil, nil, at, at).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT id FROM p1.meter_readings").WithArgs("M1", at).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectExec("INSERT INTO p1.external_readings").
+		WithArgs(int64(7), at, "W1", "water_meter", "water", int64(251003100000), 12.5, "m3").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	if err := adapter.InsertReading(context.Background(), r); err != nil {
+		t.Fatalf("InsertReading: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+// TestQuarterHourDemand tests that demand is aggregated by the database
+// function from one quarter-hour before the range
+func TestQuarterHourDemand(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock db: %v", err)
+	}
+	defer db.Close()
+
+	adapter := &PostgresAdapter{DB: db}
+
+	from := time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	mock.ExpectQuery("SELECT meter_id, quarter_start, average_w FROM p1.quarter_hour_demand_between\\(\\$1, \\$2\\) WHERE quarter_start >= \\$1 AND quarter_start < \\$3").
+		WithArgs(from, to.Add(15*time.Minute), to).
+		WillReturnRows(sqlmock.NewRows([]string{"meter_id", "quarter_start", "average_w"}).
+			AddRow("E0044", from, 2000.0))
+	mock.ExpectQuery("SELECT meter_id, monthly_power_peak_at, max\\(monthly_power_peak_w\\) FROM p1.meter_readings").
+		WithArgs(from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"meter_id", "monthly_power_peak_at", "max"}).
+			AddRow("E0044", from.Add(time.Hour), 4321.0))
+
+	demand, err := adapter.QuarterHourDemand(context.Background(), from, to)
+	if err != nil {
+		t.Fatalf("QuarterHourDemand failed: %v", err)
+	}
+	if len(demand) != 1 || demand[0].MeterID != "E0044" || !demand[0].QuarterStart.Equal(from) || demand[0].AverageW != 2000 {
+		t.Errorf("unexpected demand %+v", demand)
+	}
+	peaks, err := adapter.PowerPeaks(context.Background(), from, to)
+	if err != nil {
+		t.Fatalf("PowerPeaks failed: %v", err)
+	}
+	if len(peaks) != 1 || peaks[0].PeakW != 4321 || !peaks[0].At.Equal(from.Add(time.Hour)) {
+		t.Errorf("unexpected peaks %+v", peaks)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+// TestPeriodCounters tests that the counters per period are found by one
+// query over the readings in the bounds, labelled with their period
+func TestPeriodCounters(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock db: %v", err)
+	}
+	defer db.Close()
+
+	adapter := &PostgresAdapter{DB: db}
+
+	bounds := []time.Time{
+		time.Date(2025, 9, 30, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC),
+	}
+	mock.ExpectQuery("WITH bounds\\(i, start_at, end_at\\) AS \\(VALUES \\(0, \\$1::timestamptz, \\$2::timestamptz\\), \\(1, \\$2::timestamptz, \\$3::timestamptz\\)\\), "+
+		"readings AS \\( SELECT \\* FROM p1.meter_readings WHERE created_at >= \\$1::timestamptz AND created_at < \\$3::timestamptz \\)").
+		WithArgs(bounds[0], bounds[1], bounds[2]).
+		WillReturnRows(sqlmock.NewRows([]string{"i", "meter_id", "series", "unique_id", "unit", "first_value", "last_value"}).
+			AddRow(0, "E0044", "import", "", "", 100.0, 101.0).
+			AddRow(1, "E0044", "import", "", "", 101.5, 150.0).
+			AddRow(1, "E0044", "water", "W1", "m3", 5.0, 8.0))
+
+	got, err := adapter.PeriodCounters(context.Background(), bounds)
+	if err != nil {
+		t.Fatalf("PeriodCounters failed: %v", err)
+	}
+	if len(got) != 3 || !got[0].PeriodStart.Equal(bounds[0]) || !got[1].PeriodStart.Equal(bounds[1]) ||
+		got[2].Series != "water" || got[2].UniqueID != "W1" || got[2].Unit != "m3" || got[2].Last != 8 {
+		t.Errorf("unexpected counters %+v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,434 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/models"
+	"github.com/harrybawsac/p1-go/src/services/dsmr"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteMaxRowsPerInsert keeps multi-row inserts below SQLite's bound
+// parameter limit (32766) regardless of the column count.
+const sqliteMaxRowsPerInsert = 500
+
+// sqliteSchema mirrors the p1 tables written by the collector after
+// migrations 001-010. SQLite has no schemas, so the tables live in the main
+// database, e.g. p1.meter_readings as meter_readings.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS meter_readings (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	active_tariff INT,
+	total_power_import_kwh NUMERIC(14, 3),
+	total_power_import_t1_kwh NUMERIC(14, 3),
+	total_power_import_t2_kwh NUMERIC(14, 3),
+	total_power_export_kwh NUMERIC(14, 3),
+	total_power_export_t1_kwh NUMERIC(14, 3),
+	total_power_export_t2_kwh NUMERIC(14, 3),
+	active_power_w NUMERIC(14, 3),
+	active_power_l1_w NUMERIC(14, 3),
+	active_power_l2_w NUMERIC(14, 3),
+	active_power_l3_w NUMERIC(14, 3),
+	active_voltage_l1_v NUMERIC(14, 3),
+	active_voltage_l2_v NUMERIC(14, 3),
+	active_voltage_l3_v NUMERIC(14, 3),
+	active_current_a NUMERIC(14, 3),
+	active_current_l1_a NUMERIC(14, 3),
+	active_current_l2_a NUMERIC(14, 3),
+	active_current_l3_a NUMERIC(14, 3),
+	voltage_sag_l1_count INT,
+	voltage_sag_l2_count INT,
+	voltage_sag_l3_count INT,
+	voltage_swell_l1_count INT,
+	voltage_swell_l2_count INT,
+	voltage_swell_l3_count INT,
+	any_power_fail_count INT,
+	long_power_fail_count INT,
+	total_gas_m3 NUMERIC(14, 3),
+	gas_timestamp BIGINT,
+	meter_id TEXT NOT NULL DEFAULT '',
+	gas_measured_at TIMESTAMP,
+	active_power_average_w NUMERIC(14, 3),
+	monthly_power_peak_w NUMERIC(14, 3),
+	monthly_power_peak_timestamp BIGINT,
+	monthly_power_peak_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS meter_readings_created_at_idx ON meter_readings (created_at);
+
+CREATE TABLE IF NOT EXISTS devices (
+	unique_id TEXT PRIMARY KEY,
+	meter_model TEXT,
+	smr_version INT,
+	wifi_ssid TEXT,
+	wifi_strength INT,
+	first_seen TIMESTAMP NOT NULL,
+	last_seen TIMESTAMP NOT NULL
+);
+CREATE TABLE IF NOT EXISTS device_wifi (
+	unique_id TEXT NOT NULL REFERENCES devices(unique_id) ON DELETE CASCADE,
+	recorded_at TIMESTAMP NOT NULL,
+	wifi_ssid TEXT,
+	wifi_strength INT,
+	PRIMARY KEY (unique_id, recorded_at)
+);
+CREATE TABLE IF NOT EXISTS external_readings (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	meter_reading_id INTEGER NOT NULL REFERENCES meter_readings(id) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL,
+	unique_id TEXT NOT NULL DEFAULT '',
+	type TEXT NOT NULL,
+	kind TEXT NOT NULL DEFAULT '',
+	timestamp BIGINT,
+	value NUMERIC(14, 3),
+	unit TEXT
+);
+CREATE UNIQUE INDEX IF NOT EXISTS external_readings_reading_device_key ON external_readings (meter_reading_id, type, unique_id);
+CREATE INDEX IF NOT EXISTS external_readings_type_created_at_idx ON external_readings (type, created_at);
+`
+
+// sqliteUniqueKey is created after upgrading databases that predate meter_id
+const sqliteUniqueKey = `CREATE UNIQUE INDEX IF NOT EXISTS meter_readings_meter_created_at_key ON meter_readings (meter_id, created_at)`
+
+// sqliteGasIntervals is created after upgrading databases that predate
+// gas_measured_at; like p1.gas_intervals it has one row per gas meter update
+const sqliteGasIntervals = `
+CREATE INDEX IF NOT EXISTS meter_readings_meter_gas_measured_at_idx ON meter_readings (meter_id, gas_measured_at);
+CREATE VIEW IF NOT EXISTS gas_intervals AS
+SELECT meter_id,
+	lag(gas_measured_at) OVER w AS started_at,
+	gas_measured_at AS ended_at,
+	total_gas_m3,
+	total_gas_m3 - lag(total_gas_m3) OVER w AS consumed_m3
+FROM (
+	SELECT meter_id, gas_measured_at, min(total_gas_m3) AS total_gas_m3
+	FROM meter_readings
+	WHERE gas_measured_at IS NOT NULL
+	GROUP BY meter_id, gas_measured_at
+)
+WINDOW w AS (PARTITION BY meter_id ORDER BY gas_measured_at);
+`
+
+// sqliteDemandSelect computes the average import demand per quarter-hour
+// like p1.quarter_hour_demand_between, from the readings matching where,
+// with quarter_start in Unix seconds. min(created_at) makes SQLite take
+// import_kwh from the first reading of each quarter-hour.
+func sqliteDemandSelect(where string) string {
+	return `SELECT meter_id,
+	quarter_start,
+	round((next_import_kwh - import_kwh) * 4000, 3) AS average_w
+FROM (
+	SELECT meter_id,
+		quarter_start,
+		import_kwh,
+		lead(import_kwh) OVER w AS next_import_kwh,
+		lead(quarter_start) OVER w AS next_quarter_start
+	FROM (
+		SELECT meter_id, quarter_start, import_kwh, min(created_at)
+		FROM (
+			SELECT meter_id,
+				created_at,
+				CAST(strftime('%s', substr(created_at, 1, 19)) AS INTEGER) / 900 * 900 AS quarter_start,
+				COALESCE(NULLIF(total_power_import_kwh, 0), total_power_import_t1_kwh + total_power_import_t2_kwh) AS import_kwh
+			FROM meter_readings` + where + `
+		)
+		WHERE import_kwh > 0
+		GROUP BY meter_id, quarter_start
+	)
+	WINDOW w AS (PARTITION BY meter_id ORDER BY quarter_start)
+)
+WHERE next_quarter_start = quarter_start + 900
+	AND next_import_kwh >= import_kwh`
+}
+
+// sqliteQuarterHourDemand mirrors the p1.quarter_hour_demand view
+var sqliteQuarterHourDemand = `CREATE VIEW IF NOT EXISTS quarter_hour_demand AS
+SELECT meter_id, datetime(quarter_start, 'unixepoch') AS quarter_start, average_w
+FROM (` + sqliteDemandSelect("") + `)`
+
+// sqliteSeries is created after upgrading databases that predate
+// external_readings.kind: the per-type series of the sub-meters
+const sqliteSeries = `
+CREATE INDEX IF NOT EXISTS external_readings_kind_created_at_idx ON external_readings (kind, unique_id, created_at);
+CREATE VIEW IF NOT EXISTS gas_series AS SELECT created_at, unique_id, timestamp, value, unit, meter_reading_id FROM external_readings WHERE kind = 'gas';
+CREATE VIEW IF NOT EXISTS water_series AS SELECT created_at, unique_id, timestamp, value, unit, meter_reading_id FROM external_readings WHERE kind = 'water';
+CREATE VIEW IF NOT EXISTS warm_water_series AS SELECT created_at, unique_id, timestamp, value, unit, meter_reading_id FROM external_readings WHERE kind = 'warm_water';
+CREATE VIEW IF NOT EXISTS heat_series AS SELECT created_at, unique_id, timestamp, value, unit, meter_reading_id FROM external_readings WHERE kind = 'heat';
+`
+
+// SQLiteAdapter is a Store backed by a single SQLite file, for small
+// deployments (e.g. a Raspberry Pi next to the meter) without PostgreSQL.
+type SQLiteAdapter struct {
+	DB   *sql.DB
+	Path string
+	// OnConflict decides how duplicate (meter_id, created_at) rows are
+	// handled; the zero value skips them.
+	OnConflict ConflictPolicy
+}
+
+func init() {
+	Register("sqlite", func(dsn string) (Store, error) { return OpenSQLite(dsn) })
+}
+
+// OpenSQLite opens (creating if needed) the database file at path and
+// ensures the schema exists.
+func OpenSQLite(path string) (*SQLiteAdapter, error) {
+	if path == "" {
+		return nil, fmt.Errorf("sqlite storage requires storage.dsn (database file path)")
+	}
+	conn, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// a single writer avoids SQLITE_BUSY between pooled connections
+	conn.SetMaxOpenConns(1)
+	if _, err := conn.Exec(sqliteSchema); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("create sqlite schema: %w", err)
+	}
+	if err := upgradeSQLiteSchema(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("upgrade sqlite schema: %w", err)
+	}
+	return &SQLiteAdapter{DB: conn, Path: path}, nil
+}
+
+// upgradeSQLiteSchema adds meter_id to databases created before it existed,
+// removes duplicates that would violate the unique key, and creates the key.
+// Likewise it adds and fills external_readings.kind before creating the
+// per-type series on it, adds and backfills gas_measured_at, and adds the
+// capacity tariff columns.
+func upgradeSQLiteSchema(conn *sql.DB) error {
+	var n int
+	if err := conn.QueryRow(`SELECT count(*) FROM pragma_table_info('meter_readings') WHERE name = 'meter_id'`).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		if _, err := conn.Exec(`ALTER TABLE meter_readings ADD COLUMN meter_id TEXT NOT NULL DEFAULT ''`); err != nil {
+			return err
+		}
+		if _, err := conn.Exec(`DELETE FROM meter_readings WHERE id NOT IN (SELECT min(id) FROM meter_readings GROUP BY meter_id, created_at)`); err != nil {
+			return err
+		}
+	}
+	if _, err := conn.Exec(sqliteUniqueKey); err != nil {
+		return err
+	}
+
+	if err := conn.QueryRow(`SELECT count(*) FROM pragma_table_info('external_readings') WHERE name = 'kind'`).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		if _, err := conn.Exec(`ALTER TABLE external_readings ADD COLUMN kind TEXT NOT NULL DEFAULT ''`); err != nil {
+			return err
+		}
+		if _, err := conn.Exec(`UPDATE external_readings SET kind = CASE
+	WHEN type = 'gas_meter' THEN 'gas'
+	WHEN type = 'water_meter' THEN 'water'
+	WHEN type = 'warm_water_meter' THEN 'warm_water'
+	WHEN type IN ('heat_meter', 'inlet_heat_meter') THEN 'heat'
+	WHEN unit IN ('GJ', 'MJ') THEN 'heat'
+	ELSE ''
+END`); err != nil {
+			return err
+		}
+	}
+	if _, err := conn.Exec(sqliteSeries); err != nil {
+		return err
+	}
+
+	if err := conn.QueryRow(`SELECT count(*) FROM pragma_table_info('meter_readings') WHERE name = 'gas_measured_at'`).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		if _, err := conn.Exec(`ALTER TABLE meter_readings ADD COLUMN gas_measured_at TIMESTAMP`); err != nil {
+			return err
+		}
+		if err := backfillGasMeasuredAt(conn); err != nil {
+			return err
+		}
+	}
+	if _, err := conn.Exec(sqliteGasIntervals); err != nil {
+		return err
+	}
+
+	if err := conn.QueryRow(`SELECT count(*) FROM pragma_table_info('meter_readings') WHERE name = 'monthly_power_peak_at'`).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		for _, col := range []string{
+			"active_power_average_w NUMERIC(14, 3)",
+			"monthly_power_peak_w NUMERIC(14, 3)",
+			"monthly_power_peak_timestamp BIGINT",
+			"monthly_power_peak_at TIMESTAMP",
+		} {
+			if _, err := conn.Exec(`ALTER TABLE meter_readings ADD COLUMN ` + col); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := conn.Exec(sqliteQuarterHourDemand)
+	return err
+}
+
+// backfillGasMeasuredAt decodes gas_timestamp into gas_measured_at. SQLite
+// has no time zone database, so this is done here rather than in SQL as
+// PostgreSQL migration 009 does. A gas meter updates far less often than
+// the meter is read, so each distinct timestamp is decoded once.
+func backfillGasMeasuredAt(conn *sql.DB) error {
+	rows, err := conn.Query(`SELECT DISTINCT gas_timestamp FROM meter_readings WHERE gas_timestamp > 0`)
+	if err != nil {
+		return err
+	}
+	var stamps []int64
+	for rows.Next() {
+		var n int64
+		if err := rows.Scan(&n); err != nil {
+			rows.Close()
+			return err
+		}
+		stamps = append(stamps, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, n := range stamps {
+		t, err := dsmr.DecodeTimestamp(n)
+		if err != nil {
+			continue // malformed timestamps stay NULL
+		}
+		if _, err := tx.Exec(`UPDATE meter_readings SET gas_measured_at = ? WHERE gas_timestamp = ?`, t.UTC(), n); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteAdapter) InsertReading(ctx context.Context, r models.Reading) error {
+	return s.InsertReadingsBatch(ctx, []models.Reading{r})
+}
+
+// InsertReadingsBatch inserts multiple readings in a single transaction,
+// splitting into several multi-row statements for large batches
+func (s *SQLiteAdapter) InsertReadingsBatch(ctx context.Context, readings []models.Reading) error {
+	if len(readings) == 0 {
+		return nil
+	}
+	deduped, err := dedupeReadings(readings, s.OnConflict)
+	if err != nil {
+		return err
+	}
+	readings = append([]models.Reading(nil), deduped...)
+	for i := range readings {
+		if readings[i].CreatedAt.IsZero() {
+			readings[i].CreatedAt = time.Now()
+		}
+		// stored as text; UTC keeps lexical and chronological order equal
+		readings[i].CreatedAt = readings[i].CreatedAt.UTC()
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	rowPlaceholder := "(" + strings.TrimSuffix(strings.Repeat("?,", len(readingColumns)), ",") + ")"
+	for start := 0; start < len(readings); start += sqliteMaxRowsPerInsert {
+		end := start + sqliteMaxRowsPerInsert
+		if end > len(readings) {
+			end = len(readings)
+		}
+
+		var valueStrings []string
+		var valueArgs []interface{}
+		for _, r := range readings[start:end] {
+			valueStrings = append(valueStrings, rowPlaceholder)
+			valueArgs = append(valueArgs, readingArgs(r)...)
+		}
+
+		insert := fmt.Sprintf("INSERT INTO meter_readings (%s) VALUES %s%s",
+			strings.Join(readingColumns, ", "), strings.Join(valueStrings, ","), s.OnConflict.clause())
+		if _, err := tx.ExecContext(ctx, insert, valueArgs...); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("insert batch: %w", duplicateError(err))
+		}
+	}
+	w := extrasWriter{tx: tx, ph: func(int) string { return "?" }, policy: s.OnConflict}
+	if err := w.write(ctx, readings); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// QueryRange returns readings with from <= created_at < to, oldest first,
+// with their external readings
+func (s *SQLiteAdapter) QueryRange(ctx context.Context, from, to time.Time) ([]models.Reading, error) {
+	q := fmt.Sprintf("SELECT id, %s FROM meter_readings WHERE created_at >= ? AND created_at < ? ORDER BY created_at",
+		selectList())
+	rows, err := s.DB.QueryContext(ctx, q, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("query range: %w", err)
+	}
+	defer rows.Close()
+
+	var out []models.Reading
+	for rows.Next() {
+		r, err := scanReading(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if err := loadExternal(ctx, s.DB, "external_readings", func(int) string { return "?" }, out, from.UTC(), to.UTC()); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// LatestReading returns the most recent reading or ErrNoReadings
+func (s *SQLiteAdapter) LatestReading(ctx context.Context) (models.Reading, error) {
+	q := fmt.Sprintf("SELECT id, %s FROM meter_readings ORDER BY created_at DESC LIMIT 1", selectList())
+	r, err := scanReading(s.DB.QueryRowContext(ctx, q))
+	if err == sql.ErrNoRows {
+		return models.Reading{}, ErrNoReadings
+	}
+	if err != nil {
+		return models.Reading{}, fmt.Errorf("latest reading: %w", err)
+	}
+	return r, nil
+}
+
+// SetConflictPolicy sets how duplicate readings are handled
+func (s *SQLiteAdapter) SetConflictPolicy(policy ConflictPolicy) {
+	s.OnConflict = policy
+}
+
+// Close closes the database file
+func (s *SQLiteAdapter) Close() error {
+	return s.DB.Close()
+}
+
+// LockPath is the file used by the scheduler in place of a Postgres advisory lock
+func (s *SQLiteAdapter) LockPath() string {
+	return s.Path + ".lock"
+}
@@ -0,0 +1,411 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/models"
+)
+
+func TestSQLiteAdapter_InsertAndQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "p1.db")
+	store, err := Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	if _, err := store.LatestReading(ctx); err != ErrNoReadings {
+		t.Fatalf("expected ErrNoReadings on empty database, got %v", err)
+	}
+
+	// enough rows to span several multi-row statements
+	base := time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC)
+	readings := make([]models.Reading, sqliteMaxRowsPerInsert+10)
+	for i := range readings {
+		readings[i] = models.Reading{
+			CreatedAt:             base.Add(time.Duration(i) * 15 * time.Minute),
+			TotalPowerImportT1Kwh: 8293.146 + float64(i),
+			TotalGasM3:            3488.524,
+			GasTimestamp:          250602203000,
+		}
+	}
+	if err := store.InsertReadingsBatch(ctx, readings); err != nil {
+		t.Fatalf("insert batch: %v", err)
+	}
+
+	got, err := store.QueryRange(ctx, base, base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("query range: %v", err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("expected 4 readings in first hour, got %d", len(got))
+	}
+	if !got[1].CreatedAt.Equal(base.Add(15*time.Minute)) || got[1].TotalPowerImportT1Kwh != 8294.146 {
+		t.Errorf("unexpected second reading: %+v", got[1])
+	}
+	if got[0].GasTimestamp != 250602203000 {
+		t.Errorf("expected gas timestamp to round-trip, got %d", got[0].GasTimestamp)
+	}
+
+	latest, err := store.LatestReading(ctx)
+	if err != nil {
+		t.Fatalf("latest: %v", err)
+	}
+	if !latest.CreatedAt.Equal(readings[len(readings)-1].CreatedAt) {
+		t.Errorf("expected latest at %v, got %v", readings[len(readings)-1].CreatedAt, latest.CreatedAt)
+	}
+}
+
+func TestSQLiteAdapter_ConflictPolicy(t *testing.T) {
+	store, err := OpenSQLite(filepath.Join(t.TempDir(), "p1.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	at := time.Date(2025, 6, 2, 20, 0, 0, 0, time.UTC)
+	first := models.Reading{CreatedAt: at, MeterID: "E0044", TotalPowerImportKwh: 1}
+	again := models.Reading{CreatedAt: at, MeterID: "E0044", TotalPowerImportKwh: 2}
+
+	if err := store.InsertReading(ctx, first); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	// skip: the stored reading wins
+	if err := store.InsertReading(ctx, again); err != nil {
+		t.Fatalf("skip: expected no error, got %v", err)
+	}
+	if got, _ := store.LatestReading(ctx); got.TotalPowerImportKwh != 1 {
+		t.Errorf("skip: expected import 1, got %f", got.TotalPowerImportKwh)
+	}
+
+	store.SetConflictPolicy(ConflictFail)
+	if err := store.InsertReading(ctx, again); !errors.Is(err, ErrDuplicateReading) {
+		t.Errorf("fail: expected ErrDuplicateReading, got %v", err)
+	}
+	// duplicates within one batch fail too, and nothing is stored
+	later := models.Reading{CreatedAt: at.Add(time.Minute), MeterID: "E0044"}
+	if err := store.InsertReadingsBatch(ctx, []models.Reading{later, later}); !errors.Is(err, ErrDuplicateReading) {
+		t.Errorf("fail: expected ErrDuplicateReading for a batch, got %v", err)
+	}
+	if got, _ := store.QueryRange(ctx, later.CreatedAt, later.CreatedAt.Add(time.Second)); len(got) != 0 {
+		t.Errorf("fail: expected the batch not stored, got %+v", got)
+	}
+
+	store.SetConflictPolicy(ConflictOverwrite)
+	if err := store.InsertReadingsBatch(ctx, []models.Reading{again, again}); err != nil {
+		t.Fatalf("overwrite: %v", err)
+	}
+	got, err := store.QueryRange(ctx, at, at.Add(time.Second))
+	if err != nil {
+		t.Fatalf("query range: %v", err)
+	}
+	if len(got) != 1 || got[0].TotalPowerImportKwh != 2 || got[0].MeterID != "E0044" {
+		t.Errorf("overwrite: unexpected rows %+v", got)
+	}
+
+	// a different meter at the same instant is not a duplicate
+	if err := store.InsertReading(ctx, models.Reading{CreatedAt: at, MeterID: "E0045"}); err != nil {
+		t.Errorf("other meter: %v", err)
+	}
+}
+
+func TestSQLiteAdapter_DevicesAndExternalReadings(t *testing.T) {
+	store, err := OpenSQLite(filepath.Join(t.TempDir(), "p1.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	base := time.Date(2025, 10, 3, 8, 0, 0, 0, time.UTC)
+	reading := func(at time.Time, strength int, gas float64) models.Reading {
+		return models.Reading{
+			CreatedAt: at,
+			MeterID:   "M1",
+			Device:    &models.Device{UniqueID: "M1", Model: "ISK5", SMRVersion: 50, WifiSSID: "home", WifiStrength: strength},
+			External: []models.ExternalReading{
+				{UniqueID: "G1", Type: "gas_meter", Timestamp: 251003100000, Value: gas, Unit: "m3"},
+				{UniqueID: "W1", Type: "water_meter", Timestamp: 251003100000, Value: 12.5, Unit: "m3"},
+			},
+		}
+	}
+	for _, r := range []models.Reading{
+		reading(base, 80, 3571.7),
+		reading(base.Add(time.Minute), 80, 3571.8), // same Wi-Fi within the hour: no sample
+		reading(base.Add(2*time.Minute), 64, 3571.9),
+		reading(base.Add(-time.Hour), 90, 3570.0), // drained late
+		reading(base, 80, 9999),                   // duplicate, skipped
+	} {
+		if err := store.InsertReading(ctx, r); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+
+	var first, last time.Time
+	var strength int
+	if err := store.DB.QueryRow("SELECT first_seen, last_seen, wifi_strength FROM devices WHERE unique_id = 'M1'").Scan(&first, &last, &strength); err != nil {
+		t.Fatalf("query device: %v", err)
+	}
+	if !first.Equal(base.Add(-time.Hour)) || !last.Equal(base.Add(2*time.Minute)) || strength != 64 {
+		t.Errorf("unexpected device: first %v, last %v, strength %d", first, last, strength)
+	}
+	var samples int
+	store.DB.QueryRow("SELECT count(*) FROM device_wifi").Scan(&samples)
+	if samples != 2 {
+		t.Errorf("expected 2 Wi-Fi samples, got %d", samples)
+	}
+
+	var externals int
+	store.DB.QueryRow("SELECT count(*) FROM external_readings").Scan(&externals)
+	if externals != 8 {
+		t.Errorf("expected 2 external readings for each of 4 readings, got %d", externals)
+	}
+	gas := func() float64 {
+		var v float64
+		if err := store.DB.QueryRow(`SELECT e.value FROM external_readings e JOIN meter_readings r ON r.id = e.meter_reading_id
+WHERE r.created_at = ? AND e.type = 'gas_meter'`, base).Scan(&v); err != nil {
+			t.Fatalf("query gas: %v", err)
+		}
+		return v
+	}
+	if v := gas(); v != 3571.7 {
+		t.Errorf("skipped duplicate changed gas to %v", v)
+	}
+
+	store.SetConflictPolicy(ConflictOverwrite)
+	if err := store.InsertReading(ctx, reading(base, 80, 3571.75)); err != nil {
+		t.Fatalf("overwrite: %v", err)
+	}
+	if v := gas(); v != 3571.75 {
+		t.Errorf("expected overwritten gas 3571.75, got %v", v)
+	}
+
+	var water int
+	store.DB.QueryRow("SELECT count(*) FROM water_series WHERE unique_id = 'W1'").Scan(&water)
+	if water != 4 {
+		t.Errorf("expected 4 rows in water_series, got %d", water)
+	}
+	got, err := store.QueryRange(ctx, base, base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("query range: %v", err)
+	}
+	if len(got) != 3 || len(got[0].External) != 2 || got[0].External[0].Value != 3571.75 || got[0].External[1].Kind() != models.KindWater {
+		t.Errorf("unexpected readings with external readings: %+v", got)
+	}
+}
+
+func TestSQLiteAdapter_GasMeasuredAt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "p1.db")
+	store, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	ctx := context.Background()
+	at := time.Date(2025, 10, 3, 8, 15, 0, 0, time.UTC)
+	measured := time.Date(2025, 10, 3, 8, 10, 3, 0, time.UTC)
+	if err := store.InsertReadingsBatch(ctx, []models.Reading{
+		{CreatedAt: at, TotalGasM3: 3571.732, GasTimestamp: 251003101003, GasMeasuredAt: measured},
+		{CreatedAt: at.Add(time.Minute)},
+	}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	got, err := store.QueryRange(ctx, at, at.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("query range: %v", err)
+	}
+	if len(got) != 2 || !got[0].GasMeasuredAt.Equal(measured) || !got[1].GasMeasuredAt.IsZero() {
+		t.Fatalf("unexpected gas measurement times: %+v", got)
+	}
+
+	// a database from before gas_measured_at is backfilled on open
+	if _, err := store.DB.Exec(`DROP VIEW gas_intervals; DROP INDEX meter_readings_meter_gas_measured_at_idx;
+ALTER TABLE meter_readings DROP COLUMN gas_measured_at`); err != nil {
+		t.Fatalf("drop column: %v", err)
+	}
+	if _, err := store.DB.Exec(`INSERT INTO meter_readings (created_at, gas_timestamp) VALUES (?, 251026023000), (?, 251303101003)`,
+		at.Add(2*time.Minute), at.Add(3*time.Minute)); err != nil {
+		t.Fatalf("insert old rows: %v", err)
+	}
+	store.Close()
+
+	store, err = OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("reopen sqlite: %v", err)
+	}
+	defer store.Close()
+	got, err = store.QueryRange(ctx, at, at.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("query range: %v", err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("expected 4 readings, got %d", len(got))
+	}
+	if !got[0].GasMeasuredAt.Equal(measured) {
+		t.Errorf("expected backfilled %v, got %v", measured, got[0].GasMeasuredAt)
+	}
+	if want := time.Date(2025, 10, 26, 0, 30, 0, 0, time.UTC); !got[2].GasMeasuredAt.Equal(want) {
+		t.Errorf("expected backfilled %v, got %v", want, got[2].GasMeasuredAt)
+	}
+	if !got[3].GasMeasuredAt.IsZero() {
+		t.Errorf("expected a malformed timestamp to stay NULL, got %v", got[3].GasMeasuredAt)
+	}
+
+	var intervals int
+	if err := store.DB.QueryRow(`SELECT count(*) FROM gas_intervals`).Scan(&intervals); err != nil {
+		t.Fatalf("query gas intervals: %v", err)
+	}
+	if intervals != 2 {
+		t.Errorf("expected 2 gas measurements, got %d", intervals)
+	}
+}
+
+func TestSQLiteAdapter_CapacityTariff(t *testing.T) {
+	store, err := OpenSQLite(filepath.Join(t.TempDir(), "p1.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	at := time.Date(2025, 10, 3, 8, 14, 0, 0, time.UTC)
+	peakAt := time.Date(2025, 10, 1, 16, 30, 0, 0, time.UTC)
+	if err := store.InsertReadingsBatch(ctx, []models.Reading{
+		{CreatedAt: at, TotalPowerImportKwh: 100, ActivePowerAverageW: 1234, MonthlyPowerPeakW: 4321,
+			MonthlyPowerPeakTimestamp: 251001183000, MonthlyPowerPeakAt: peakAt},
+		{CreatedAt: at.Add(15 * time.Minute), TotalPowerImportKwh: 100.5},
+		{CreatedAt: at.Add(29 * time.Minute), TotalPowerImportKwh: 101},
+		{CreatedAt: at.Add(time.Hour), TotalPowerImportKwh: 102}, // after a gap
+	}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	got, err := store.QueryRange(ctx, at, at.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("query range: %v", err)
+	}
+	if len(got) != 1 || got[0].ActivePowerAverageW != 1234 || got[0].MonthlyPowerPeakW != 4321 ||
+		got[0].MonthlyPowerPeakTimestamp != 251001183000 || !got[0].MonthlyPowerPeakAt.Equal(peakAt) {
+		t.Fatalf("unexpected capacity tariff fields: %+v", got)
+	}
+
+	rows, err := store.DB.Query(`SELECT quarter_start, average_w FROM quarter_hour_demand ORDER BY quarter_start`)
+	if err != nil {
+		t.Fatalf("query demand: %v", err)
+	}
+	defer rows.Close()
+	var demand []string
+	for rows.Next() {
+		var start string
+		var w float64
+		if err := rows.Scan(&start, &w); err != nil {
+			t.Fatalf("scan demand: %v", err)
+		}
+		demand = append(demand, fmt.Sprintf("%s %.0f", start, w))
+	}
+	want := []string{"2025-10-03 08:00:00 2000", "2025-10-03 08:15:00 2000"}
+	if strings.Join(demand, ", ") != strings.Join(want, ", ") {
+		t.Errorf("expected demand %v, got %v", want, demand)
+	}
+
+	// the same series aggregated for a range
+	var ds DemandStore = store
+	got2, err := ds.QuarterHourDemand(ctx, time.Date(2025, 10, 3, 8, 15, 0, 0, time.UTC), at.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("quarter-hour demand: %v", err)
+	}
+	if len(got2) != 1 || !got2[0].QuarterStart.Equal(time.Date(2025, 10, 3, 8, 15, 0, 0, time.UTC)) || got2[0].AverageW != 2000 {
+		t.Errorf("unexpected demand in range: %+v", got2)
+	}
+	// boundary-aligned readings across a month end: the reading at midnight
+	// ends October's last quarter
+	oct31 := time.Date(2025, 10, 31, 23, 30, 0, 0, time.UTC)
+	if err := store.InsertReadingsBatch(ctx, []models.Reading{
+		{MeterID: "E2", CreatedAt: oct31, TotalPowerImportKwh: 10},
+		{MeterID: "E2", CreatedAt: oct31.Add(15 * time.Minute), TotalPowerImportKwh: 10.25},
+		{MeterID: "E2", CreatedAt: oct31.Add(30 * time.Minute), TotalPowerImportKwh: 10.75},
+		{MeterID: "E2", CreatedAt: oct31.Add(45 * time.Minute), TotalPowerImportKwh: 11},
+	}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	nov := time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		from, to time.Time
+		want     []string
+	}{
+		{oct31.Add(-time.Hour), nov, []string{"23:30 1000", "23:45 2000"}},
+		{nov, nov.Add(time.Hour), []string{"00:00 1000"}},
+	} {
+		got, err := ds.QuarterHourDemand(ctx, tc.from, tc.to)
+		if err != nil {
+			t.Fatalf("quarter-hour demand: %v", err)
+		}
+		var quarters []string
+		for _, d := range got {
+			quarters = append(quarters, fmt.Sprintf("%s %.0f", d.QuarterStart.Format("15:04"), d.AverageW))
+		}
+		if strings.Join(quarters, ", ") != strings.Join(tc.want, ", ") {
+			t.Errorf("demand from %s: expected %v, got %v", tc.from, tc.want, quarters)
+		}
+	}
+
+	peaks, err := ds.PowerPeaks(ctx, at, at.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("power peaks: %v", err)
+	}
+	if len(peaks) != 1 || peaks[0].PeakW != 4321 || !peaks[0].At.Equal(peakAt) {
+		t.Errorf("unexpected power peaks: %+v", peaks)
+	}
+}
+
+func TestSQLiteAdapter_PeriodCounters(t *testing.T) {
+	store, err := OpenSQLite(filepath.Join(t.TempDir(), "p1.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	oct1 := time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC)
+	nov1 := oct1.AddDate(0, 1, 0)
+	water := func(v float64) []models.ExternalReading {
+		return []models.ExternalReading{{UniqueID: "W1", Type: "water_meter", Value: v, Unit: "m3"}}
+	}
+	if err := store.InsertReadingsBatch(ctx, []models.Reading{
+		{MeterID: "E1", CreatedAt: oct1.Add(-time.Hour), TotalPowerImportKwh: 100, TotalGasM3: 10, External: water(5)},
+		{MeterID: "E1", CreatedAt: oct1.Add(time.Hour), TotalPowerImportKwh: 101, TotalGasM3: 11, External: water(5.5)},
+		{MeterID: "E1", CreatedAt: nov1.Add(-time.Hour), TotalPowerImportKwh: 150, TotalGasM3: 20, External: water(8)},
+		// the gas value was measured in October
+		{MeterID: "E1", CreatedAt: nov1.Add(time.Minute), TotalPowerImportKwh: 151, TotalGasM3: 21,
+			GasMeasuredAt: nov1.Add(-5 * time.Minute), External: water(8.1)},
+		// CSV imports only carry the tariff counters
+		{MeterID: "E1", CreatedAt: nov1.Add(2 * time.Hour), TotalPowerImportT1Kwh: 100, TotalPowerImportT2Kwh: 55},
+		{MeterID: "E1", CreatedAt: nov1.AddDate(0, 1, 0), TotalPowerImportKwh: 200}, // after the report
+	}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	var us UsageStore = store
+	got, err := us.PeriodCounters(ctx, []time.Time{oct1.Add(-24 * time.Hour), oct1, nov1, nov1.Add(24 * time.Hour)})
+	if err != nil {
+		t.Fatalf("period counters: %v", err)
+	}
+	var lines []string
+	for _, c := range got {
+		series := strings.TrimSpace(c.Series + " " + c.UniqueID)
+		lines = append(lines, fmt.Sprintf("%s %s %s %g-%g", c.PeriodStart.Format("01-02"), c.MeterID, series, c.First, c.Last))
+	}
+	want := []string{
+		"09-30 E1 gas 10-10", "09-30 E1 import 100-100", "09-30 E1 water W1 5-5",
+		"10-01 E1 gas 11-21", "10-01 E1 import 101-150", "10-01 E1 water W1 5.5-8",
+		"11-01 E1 import 151-155", "11-01 E1 water W1 8.1-8.1",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(lines, "\n"))
+	}
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/models"
+	"github.com/lib/pq"
+)
+
+// ErrNoReadings is returned by LatestReading when the store is empty
+var ErrNoReadings = errors.New("no readings stored")
+
+// Store persists and queries meter readings. PostgresAdapter is the default
+// implementation; others are selected with the storage.driver config key.
+type Store interface {
+	InsertReading(ctx context.Context, r models.Reading) error
+	InsertReadingsBatch(ctx context.Context, readings []models.Reading) error
+	QueryRange(ctx context.Context, from, to time.Time) ([]models.Reading, error)
+	LatestReading(ctx context.Context) (models.Reading, error)
+	// SetConflictPolicy controls inserts of an already stored
+	// (meter_id, created_at) key
+	SetConflictPolicy(policy ConflictPolicy)
+	Close() error
+}
+
+// OpenFunc creates a Store from a driver specific DSN
+type OpenFunc func(dsn string) (Store, error)
+
+var drivers = map[string]OpenFunc{}
+
+// Register makes a storage driver available to Open. It is called from the
+// init functions of the driver implementations.
+func Register(name string, open OpenFunc) {
+	drivers[name] = open
+}
+
+// Open returns the Store for driver, defaulting to "postgres"
+func Open(driver, dsn string) (Store, error) {
+	if driver == "" {
+		driver = "postgres"
+	}
+	open, ok := drivers[driver]
+	if !ok {
+		return nil, fmt.Errorf("unknown storage driver %q (available: %v)", driver, Drivers())
+	}
+	return open(dsn)
+}
+
+// Drivers returns the registered driver names, sorted
+func Drivers() []string {
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsUnavailable reports whether err means the database could not be reached
+// or is shutting down, as opposed to rejecting a particular reading
+func IsUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// 08: connection exception, 57P: operator intervention (shutdown)
+		return strings.HasPrefix(string(pqErr.Code), "08") || strings.HasPrefix(string(pqErr.Code), "57P")
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/models"
+)
+
+func TestOpenUnknownDriver(t *testing.T) {
+	if _, err := Open("nope", ""); err == nil {
+		t.Fatalf("expected error for unknown driver")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store, err := Open("memory", "")
+	if err != nil {
+		t.Fatalf("open memory store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	if _, err := store.LatestReading(ctx); err != ErrNoReadings {
+		t.Fatalf("expected ErrNoReadings on empty store, got %v", err)
+	}
+
+	base := time.Date(2025, 6, 2, 20, 0, 0, 0, time.UTC)
+	readings := []models.Reading{
+		{CreatedAt: base.Add(30 * time.Minute), TotalPowerImportKwh: 3},
+		{CreatedAt: base, TotalPowerImportKwh: 1},
+		{CreatedAt: base.Add(15 * time.Minute), TotalPowerImportKwh: 2},
+	}
+	if err := store.InsertReadingsBatch(ctx, readings); err != nil {
+		t.Fatalf("insert batch: %v", err)
+	}
+
+	latest, err := store.LatestReading(ctx)
+	if err != nil {
+		t.Fatalf("latest: %v", err)
+	}
+	if latest.TotalPowerImportKwh != 3 {
+		t.Errorf("expected latest import 3, got %f", latest.TotalPowerImportKwh)
+	}
+
+	got, err := store.QueryRange(ctx, base, base.Add(30*time.Minute))
+	if err != nil {
+		t.Fatalf("query range: %v", err)
+	}
+	if len(got) != 2 || got[0].TotalPowerImportKwh != 1 || got[1].TotalPowerImportKwh != 2 {
+		t.Errorf("unexpected range result: %+v", got)
+	}
+}
@@ -0,0 +1,120 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/models"
+)
+
+// UsageStore is implemented by stores that find the counters of a
+// consumption report in the database, so reports do not load every reading
+type UsageStore interface {
+	// PeriodCounters returns the first and last value of every counter series
+	// in each period [bounds[i], bounds[i+1]), ordered by period. Series
+	// without a value in a period are absent from it.
+	PeriodCounters(ctx context.Context, bounds []time.Time) ([]models.PeriodCounter, error)
+}
+
+// periodCountersQuery builds the PeriodCounters query for len(bounds)-1
+// periods. table qualifies a table or view name and param renders the n-th
+// bound as a placeholder (from 1); every bound is passed once, in order.
+// Gas is timed at the gas meter's measurement and taken from the gas
+// sub-meter when the reading has no top-level gas value, as report.Summarize
+// does.
+func periodCountersQuery(table func(string) string, param func(n int) string, periods int) string {
+	var values []string
+	for i := 0; i < periods; i++ {
+		values = append(values, fmt.Sprintf("(%d, %s, %s)", i, param(i+1), param(i+2)))
+	}
+	var subMeters []string
+	for _, kind := range []string{models.KindWater, models.KindWarmWater, models.KindHeat} {
+		subMeters = append(subMeters, fmt.Sprintf(`	SELECT r.meter_id, '%[1]s', s.unique_id, COALESCE(s.unit, ''), s.created_at, s.value
+	FROM %[2]s s JOIN readings r ON r.id = s.meter_reading_id`, kind, table(kind+"_series")))
+	}
+	return fmt.Sprintf(`WITH bounds(i, start_at, end_at) AS (VALUES %[1]s),
+readings AS (
+	SELECT * FROM %[2]s WHERE created_at >= %[3]s AND created_at < %[4]s
+),
+counters(meter_id, series, unique_id, unit, at, value) AS (
+	SELECT meter_id, 'import', '', '', created_at,
+		COALESCE(NULLIF(total_power_import_kwh, 0), total_power_import_t1_kwh + total_power_import_t2_kwh)
+	FROM readings
+	UNION ALL
+	SELECT meter_id, 'export', '', '', created_at,
+		COALESCE(NULLIF(total_power_export_kwh, 0), total_power_export_t1_kwh + total_power_export_t2_kwh)
+	FROM readings
+	UNION ALL
+	SELECT meter_id, 'gas', '', '', COALESCE(gas_measured_at, created_at), total_gas_m3
+	FROM readings WHERE total_gas_m3 > 0
+	UNION ALL
+	SELECT r.meter_id, 'gas', '', '', COALESCE(r.gas_measured_at, r.created_at), s.value
+	FROM %[5]s s JOIN readings r ON r.id = s.meter_reading_id
+	WHERE COALESCE(r.total_gas_m3, 0) <= 0
+	UNION ALL
+%[6]s
+)
+SELECT DISTINCT b.i, c.meter_id, c.series, c.unique_id, c.unit,
+	first_value(c.value) OVER w, last_value(c.value) OVER w
+FROM counters c
+JOIN bounds b ON c.at >= b.start_at AND c.at < b.end_at
+WHERE c.value > 0
+WINDOW w AS (PARTITION BY b.i, c.meter_id, c.series, c.unique_id, c.unit ORDER BY c.at
+	ROWS BETWEEN UNBOUNDED PRECEDING AND UNBOUNDED FOLLOWING)
+ORDER BY b.i, c.meter_id, c.series, c.unique_id`,
+		strings.Join(values, ", "), table("meter_readings"), param(1), param(periods+1),
+		table("gas_series"), strings.Join(subMeters, "\n\tUNION ALL\n"))
+}
+
+func (p *PostgresAdapter) PeriodCounters(ctx context.Context, bounds []time.Time) ([]models.PeriodCounter, error) {
+	if len(bounds) < 2 {
+		return nil, nil
+	}
+	q := periodCountersQuery(func(name string) string { return "p1." + name },
+		func(n int) string { return fmt.Sprintf("$%d::timestamptz", n) }, len(bounds)-1)
+	args := make([]any, len(bounds))
+	for i, b := range bounds {
+		args[i] = b
+	}
+	return queryPeriodCounters(ctx, p.DB, q, bounds, args)
+}
+
+func (s *SQLiteAdapter) PeriodCounters(ctx context.Context, bounds []time.Time) ([]models.PeriodCounter, error) {
+	if len(bounds) < 2 {
+		return nil, nil
+	}
+	q := periodCountersQuery(func(name string) string { return name },
+		func(n int) string { return fmt.Sprintf("?%d", n) }, len(bounds)-1)
+	args := make([]any, len(bounds))
+	for i, b := range bounds {
+		args[i] = b.UTC()
+	}
+	return queryPeriodCounters(ctx, s.DB, q, bounds, args)
+}
+
+// queryPeriodCounters runs a periodCountersQuery and labels each row with
+// the start of its period
+func queryPeriodCounters(ctx context.Context, conn *sql.DB, q string, bounds []time.Time, args []any) ([]models.PeriodCounter, error) {
+	rows, err := conn.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query period counters: %w", err)
+	}
+	defer rows.Close()
+	var out []models.PeriodCounter
+	for rows.Next() {
+		var i int
+		var c models.PeriodCounter
+		if err := rows.Scan(&i, &c.MeterID, &c.Series, &c.UniqueID, &c.Unit, &c.First, &c.Last); err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= len(bounds)-1 {
+			return nil, fmt.Errorf("unexpected period %d", i)
+		}
+		c.PeriodStart = bounds[i]
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,70 @@
+package dsmr
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxTelegramSize bounds a single telegram so a noisy line cannot grow the
+// frame buffer forever. DSMR 5 telegrams are typically well below 2 KiB.
+const maxTelegramSize = 16 * 1024
+
+// ErrTelegramTooLarge is returned when no end-of-frame marker is seen within
+// maxTelegramSize bytes of a frame start.
+var ErrTelegramTooLarge = errors.New("dsmr telegram exceeds maximum size")
+
+// Reader assembles complete DSMR telegrams ("/" header up to and including the
+// "!CRC" trailer line) from a byte stream such as a serial port, a pty or a
+// replay file.
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader wraps src in a telegram Reader
+func NewReader(src io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(src)}
+}
+
+// ReadTelegram blocks until a complete telegram is available and returns it
+// including the trailing "!CRC" line. Bytes before the first "/" are discarded,
+// which covers the partial frame usually seen right after opening a port.
+func (t *Reader) ReadTelegram() ([]byte, error) {
+	// skip to start of frame
+	for {
+		b, err := t.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == '/' {
+			break
+		}
+	}
+
+	var frame bytes.Buffer
+	frame.WriteByte('/')
+	for {
+		line, err := t.r.ReadBytes('\n')
+		if len(line) > 0 {
+			// a new header inside a frame means the previous one was cut off
+			if line[0] == '/' {
+				frame.Reset()
+			}
+			frame.Write(line)
+			if frame.Len() > maxTelegramSize {
+				return nil, ErrTelegramTooLarge
+			}
+			if line[0] == '!' {
+				return frame.Bytes(), nil
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("dsmr telegram truncated: %w", io.ErrUnexpectedEOF)
+			}
+			return nil, err
+		}
+	}
+}
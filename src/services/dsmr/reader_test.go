@@ -0,0 +1,67 @@
+package dsmr
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+const sampleTelegram = "/ISk5\\2MT382-1000\r\n" +
+	"\r\n" +
+	"1-3:0.2.8(50)\r\n" +
+	"0-0:1.0.0(101209113020W)\r\n" +
+	"1-0:1.8.1(123456.789*kWh)\r\n" +
+	"1-0:1.8.2(123456.789*kWh)\r\n" +
+	"0-0:96.14.0(0002)\r\n" +
+	"1-0:1.7.0(01.193*kW)\r\n" +
+	"!EF2F\r\n"
+
+func TestReadTelegram_SkipsPartialFrame(t *testing.T) {
+	// stream starts mid-telegram, as it does right after opening a port
+	stream := "8.2(000000.000*kWh)\r\n!1234\r\n" + sampleTelegram + sampleTelegram
+	r := NewReader(strings.NewReader(stream))
+
+	for i := 0; i < 2; i++ {
+		got, err := r.ReadTelegram()
+		if err != nil {
+			t.Fatalf("read telegram %d: %v", i, err)
+		}
+		if string(got) != sampleTelegram {
+			t.Fatalf("telegram %d mismatch:\n%q\nwant\n%q", i, got, sampleTelegram)
+		}
+	}
+
+	if _, err := r.ReadTelegram(); err != io.EOF {
+		t.Fatalf("expected io.EOF at end of stream, got %v", err)
+	}
+}
+
+func TestReadTelegram_RestartsOnNewHeader(t *testing.T) {
+	// first frame is cut off and followed directly by a complete one
+	stream := "/ISk5\\2MT382-1000\r\n1-0:1.8.1(000001.000*kWh)\r\n" + sampleTelegram
+	got, err := NewReader(strings.NewReader(stream)).ReadTelegram()
+	if err != nil {
+		t.Fatalf("read telegram: %v", err)
+	}
+	if string(got) != sampleTelegram {
+		t.Fatalf("expected only the complete telegram, got %q", got)
+	}
+}
+
+func TestReadTelegram_Truncated(t *testing.T) {
+	stream := sampleTelegram[:len(sampleTelegram)-10]
+	_, err := NewReader(strings.NewReader(stream)).ReadTelegram()
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestReadTelegram_TooLarge(t *testing.T) {
+	stream := "/HEADER\r\n" + strings.Repeat("1-0:1.8.1(000001.000*kWh)\r\n", 1000)
+	_, err := NewReader(bytes.NewBufferString(stream)).ReadTelegram()
+	if !errors.Is(err, ErrTelegramTooLarge) {
+		t.Fatalf("expected ErrTelegramTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+package dsmr
+
+import (
+	"io"
+
+	"go.bug.st/serial"
+)
+
+// DefaultBaudRate is the P1 port speed for DSMR 4.x and 5.0 meters
+const DefaultBaudRate = 115200
+
+// OpenSerial opens a P1 serial device such as /dev/ttyUSB0. DSMR 4.x/5.0 meters
+// use 115200 8N1; DSMR 2.2 meters use 9600 7E1, which is selected when baud is
+// 9600. A baud of 0 means DefaultBaudRate.
+func OpenSerial(device string, baud int) (io.ReadCloser, error) {
+	if baud == 0 {
+		baud = DefaultBaudRate
+	}
+	mode := &serial.Mode{
+		BaudRate: baud,
+		DataBits: 8,
+		Parity:   serial.NoParity,
+		StopBits: serial.OneStopBit,
+	}
+	if baud == 9600 {
+		mode.DataBits = 7
+		mode.Parity = serial.EvenParity
+	}
+	return serial.Open(device, mode)
+}
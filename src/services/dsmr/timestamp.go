@@ -0,0 +1,92 @@
+package dsmr
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	// DSMR timestamps are Dutch local time; embed the zone database so they
+	// decode on hosts without one, such as minimal containers
+	_ "time/tzdata"
+)
+
+// Location is the zone of DSMR timestamps. Belgian meters use the same
+// offsets.
+var Location = mustLoadLocation("Europe/Amsterdam")
+
+var (
+	summerTime = time.FixedZone("CEST", 2*60*60)
+	winterTime = time.FixedZone("CET", 1*60*60)
+)
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
+
+// ParseTimestamp decodes a telegram timestamp "YYMMDDhhmmssX" in Dutch local
+// time. The DST flag X is S for summer time (UTC+2) or W for winter time
+// (UTC+1) and settles the hour that occurs twice when the clocks go back.
+// Without a flag the time is resolved like DecodeTimestamp.
+func ParseTimestamp(s string) (time.Time, error) {
+	var zone *time.Location
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'S':
+			zone, s = summerTime, s[:n-1]
+		case 'W':
+			zone, s = winterTime, s[:n-1]
+		}
+	}
+	if len(s) != 12 {
+		return time.Time{}, fmt.Errorf("invalid dsmr timestamp %q", s)
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid dsmr timestamp %q", s)
+	}
+	if zone == nil {
+		return DecodeTimestamp(n)
+	}
+	t, err := localTime(n, zone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.In(Location), nil
+}
+
+// DecodeTimestamp decodes the YYMMDDhhmmss integer used by the meter JSON API
+// (gas_timestamp), which carries no DST flag. In the hour that occurs twice
+// when the clocks go back the summer time reading is assumed, as the earlier
+// of the two; a time skipped when the clocks go forward is moved an hour on.
+func DecodeTimestamp(n int64) (time.Time, error) {
+	summer, err := localTime(n, summerTime)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if _, offset := summer.In(Location).Zone(); offset == 2*60*60 {
+		return summer.In(Location), nil
+	}
+	winter, _ := localTime(n, winterTime)
+	return winter.In(Location), nil
+}
+
+// localTime builds the time n (YYMMDDhhmmss) in zone, rejecting fields out
+// of range instead of normalizing them
+func localTime(n int64, zone *time.Location) (time.Time, error) {
+	if n <= 0 {
+		return time.Time{}, fmt.Errorf("invalid dsmr timestamp %d", n)
+	}
+	year := 2000 + int(n/1e10)
+	month := time.Month(n / 1e8 % 100)
+	day := int(n / 1e6 % 100)
+	hour, min, sec := int(n/1e4%100), int(n/100%100), int(n%100)
+	t := time.Date(year, month, day, hour, min, sec, 0, zone)
+	if t.Month() != month || t.Day() != day || t.Hour() != hour || t.Minute() != min || t.Second() != sec {
+		return time.Time{}, fmt.Errorf("invalid dsmr timestamp %012d", n)
+	}
+	return t, nil
+}
@@ -0,0 +1,64 @@
+package dsmr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string // UTC
+	}{
+		{"251003101003S", "2025-10-03T08:10:03Z"},
+		{"250115120000W", "2025-01-15T11:00:00Z"},
+		// the hour that occurs twice when the clocks go back
+		{"251026023000S", "2025-10-26T00:30:00Z"},
+		{"251026023000W", "2025-10-26T01:30:00Z"},
+		// without a flag: summer time assumed in the repeated hour
+		{"251026023000", "2025-10-26T00:30:00Z"},
+		{"251026033000", "2025-10-26T02:30:00Z"},
+		// skipped when the clocks go forward: an hour on
+		{"250330023000", "2025-03-30T01:30:00Z"},
+	}
+	for _, tt := range tests {
+		got, err := ParseTimestamp(tt.in)
+		if err != nil {
+			t.Errorf("ParseTimestamp(%q): %v", tt.in, err)
+			continue
+		}
+		if got.UTC().Format(time.RFC3339) != tt.want {
+			t.Errorf("ParseTimestamp(%q) = %s, want %s", tt.in, got.UTC().Format(time.RFC3339), tt.want)
+		}
+		if got.Location() != Location {
+			t.Errorf("ParseTimestamp(%q) location %v, want %v", tt.in, got.Location(), Location)
+		}
+	}
+
+	for _, bad := range []string{"", "S", "2510031010", "251303101003S", "250231101003W", "25100310100X"} {
+		if _, err := ParseTimestamp(bad); err == nil {
+			t.Errorf("ParseTimestamp(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestDecodeTimestamp(t *testing.T) {
+	got, err := DecodeTimestamp(251003101003)
+	if err != nil {
+		t.Fatalf("DecodeTimestamp: %v", err)
+	}
+	if want := time.Date(2025, 10, 3, 8, 10, 3, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("DecodeTimestamp = %v, want %v", got, want)
+	}
+	// the repeated hour: summer time, as p1.dsmr_timestamp() in migration 009
+	got, err = DecodeTimestamp(251026023000)
+	if err != nil {
+		t.Fatalf("DecodeTimestamp: %v", err)
+	}
+	if want := time.Date(2025, 10, 26, 0, 30, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("DecodeTimestamp = %v, want %v", got, want)
+	}
+	if _, err := DecodeTimestamp(0); err == nil {
+		t.Error("expected an error for 0")
+	}
+}
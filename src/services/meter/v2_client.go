@@ -0,0 +1,252 @@
+package meter
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrButtonNotPressed is returned by RequestToken while the meter has not
+// been put into pairing mode by pressing its button.
+var ErrButtonNotPressed = errors.New("press the button on the meter to allow pairing")
+
+// ErrCertificateMismatch is returned when the device certificate does not
+// match the pinned fingerprint.
+var ErrCertificateMismatch = errors.New("meter certificate does not match pinned fingerprint")
+
+// V2Client talks to the HomeWizard API v2 over HTTPS. Devices present a
+// self-signed certificate, so instead of CA validation the leaf certificate is
+// pinned by its SHA-256 fingerprint (hex encoded).
+type V2Client struct {
+	BaseURL    string // e.g. https://192.168.101.20
+	Token      string
+	CertSHA256 string
+	Timeout    time.Duration
+
+	// transports are built once per pin so polls reuse the keep-alive
+	// connection instead of leaking one and handshaking each time
+	mu         sync.Mutex
+	transports map[string]*http.Transport
+}
+
+// NewV2Client returns a client for the device at baseURL
+func NewV2Client(baseURL, token, certSHA256 string, timeout time.Duration) *V2Client {
+	return &V2Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		Token:      token,
+		CertSHA256: certSHA256,
+		Timeout:    timeout,
+	}
+}
+
+// GetMeasurement fetches /api/measurement and returns the raw JSON body
+func (c *V2Client) GetMeasurement(ctx context.Context) ([]byte, error) {
+	if c.Token == "" {
+		return nil, errors.New("meter token not set; run metercli pair")
+	}
+	if c.CertSHA256 == "" {
+		return nil, errors.New("meter certificate fingerprint not set; run metercli pair")
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, "/api/measurement", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+
+	resp, err := c.httpClient(c.CertSHA256).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("meter measurement status: %s: %s", resp.Status, apiError(body))
+	}
+	return body, nil
+}
+
+// RequestToken performs one step of the pairing flow by calling POST
+// /api/user. It returns ErrButtonNotPressed until the meter button has been
+// pressed; callers are expected to retry for the duration of the pairing
+// window. The connection must match the pinned certificate when one is set.
+func (c *V2Client) RequestToken(ctx context.Context, name string) (string, error) {
+	payload, err := json.Marshal(map[string]string{"name": name})
+	if err != nil {
+		return "", err
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/user", bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient(c.CertSHA256).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var out struct {
+			Token string `json:"token"`
+		}
+		if err := json.Unmarshal(body, &out); err != nil {
+			return "", fmt.Errorf("decode token response: %w", err)
+		}
+		if out.Token == "" {
+			return "", errors.New("token response did not contain a token")
+		}
+		return out.Token, nil
+	case http.StatusForbidden:
+		return "", ErrButtonNotPressed
+	default:
+		return "", fmt.Errorf("meter pairing status: %s: %s", resp.Status, apiError(body))
+	}
+}
+
+// Pair pins the device certificate on first use (when no fingerprint is set
+// yet) and then polls RequestToken every retry interval until the meter button
+// is pressed or ctx is done. On success c.Token and c.CertSHA256 are set.
+func (c *V2Client) Pair(ctx context.Context, name string, retry time.Duration) error {
+	if c.CertSHA256 == "" {
+		fp, err := c.FetchCertFingerprint(ctx)
+		if err != nil {
+			return fmt.Errorf("fetch certificate: %w", err)
+		}
+		c.CertSHA256 = fp
+	}
+
+	ticker := time.NewTicker(retry)
+	defer ticker.Stop()
+	for {
+		token, err := c.RequestToken(ctx, name)
+		if err == nil {
+			c.Token = token
+			return nil
+		}
+		if !errors.Is(err, ErrButtonNotPressed) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %v", ErrButtonNotPressed, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// FetchCertFingerprint connects to the device without verification and
+// returns the SHA-256 fingerprint of its leaf certificate, for pinning on
+// first use during pairing.
+func (c *V2Client) FetchCertFingerprint(ctx context.Context) (string, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/api", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.httpClient("").Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		return "", errors.New("meter did not present a TLS certificate")
+	}
+	return CertFingerprint(resp.TLS.PeerCertificates[0].Raw), nil
+}
+
+// CertFingerprint returns the hex encoded SHA-256 of a DER certificate
+func CertFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *V2Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Api-Version", "2")
+	return req, nil
+}
+
+// httpClient returns an HTTP client using tlsConfig(pin), sharing one
+// transport per pin across calls
+func (c *V2Client) httpClient(pin string) *http.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tr := c.transports[pin]
+	if tr == nil {
+		if c.transports == nil {
+			c.transports = map[string]*http.Transport{}
+		}
+		tr = &http.Transport{TLSClientConfig: tlsConfig(pin)}
+		c.transports[pin] = tr
+	}
+	return &http.Client{Timeout: c.timeout(), Transport: tr}
+}
+
+// CloseIdleConnections closes the keep-alive connections to the meter
+func (c *V2Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, tr := range c.transports {
+		tr.CloseIdleConnections()
+	}
+}
+
+func (c *V2Client) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return 10 * time.Second
+	}
+	return c.Timeout
+}
+
+// tlsConfig skips CA validation and instead compares the leaf certificate
+// against pin. An empty pin accepts any certificate and is only used to read
+// the fingerprint during pairing.
+func tlsConfig(pin string) *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify: true, // device certificates are self-signed; pinned below
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if pin == "" {
+				return nil
+			}
+			if len(cs.PeerCertificates) == 0 {
+				return ErrCertificateMismatch
+			}
+			if !strings.EqualFold(CertFingerprint(cs.PeerCertificates[0].Raw), pin) {
+				return ErrCertificateMismatch
+			}
+			return nil
+		},
+	}
+}
+
+// apiError extracts the "error" field of a v2 error body, falling back to the
+// raw body
+func apiError(body []byte) string {
+	var e struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &e); err == nil && e.Error != "" {
+		return e.Error
+	}
+	return strings.TrimSpace(string(body))
+}
@@ -0,0 +1,127 @@
+package meter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFakeMeter starts a TLS stand-in for a HomeWizard v2 device whose pairing
+// endpoint returns 403 until pressAfter requests have been made.
+func newFakeMeter(t *testing.T, pressAfter int) *httptest.Server {
+	t.Helper()
+	attempts := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"product_type":"HWE-P1","api_version":"2.0.0"}`))
+	})
+	mux.HandleFunc("/api/user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("X-Api-Version") != "2" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		attempts++
+		if attempts <= pressAfter {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":"user:creation-not-enabled"}`))
+			return
+		}
+		w.Write([]byte(`{"token":"ABCDEF0123456789"}`))
+	})
+	mux.HandleFunc("/api/measurement", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer ABCDEF0123456789" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"user:unauthorized"}`))
+			return
+		}
+		w.Write([]byte(`{"energy_import_kwh":16152.335,"power_w":321}`))
+	})
+	srv := httptest.NewTLSServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestV2Client_PairAndMeasure(t *testing.T) {
+	srv := newFakeMeter(t, 2)
+	c := NewV2Client(srv.URL, "", "", time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Pair(ctx, "local/metercli", 10*time.Millisecond); err != nil {
+		t.Fatalf("pair: %v", err)
+	}
+	if c.Token != "ABCDEF0123456789" {
+		t.Fatalf("unexpected token %q", c.Token)
+	}
+	if want := CertFingerprint(srv.Certificate().Raw); c.CertSHA256 != want {
+		t.Fatalf("expected pinned fingerprint %s, got %s", want, c.CertSHA256)
+	}
+
+	body, err := c.GetMeasurement(ctx)
+	if err != nil {
+		t.Fatalf("get measurement: %v", err)
+	}
+	if len(body) == 0 {
+		t.Fatalf("expected measurement body")
+	}
+}
+
+func TestV2Client_PairTimesOutWithoutButton(t *testing.T) {
+	srv := newFakeMeter(t, 1000)
+	c := NewV2Client(srv.URL, "", "", time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := c.Pair(ctx, "local/metercli", 10*time.Millisecond); !errors.Is(err, ErrButtonNotPressed) {
+		t.Fatalf("expected ErrButtonNotPressed, got %v", err)
+	}
+}
+
+func TestV2Client_RejectsUnpinnedCertificate(t *testing.T) {
+	srv := newFakeMeter(t, 0)
+	c := NewV2Client(srv.URL, "ABCDEF0123456789", CertFingerprint([]byte("other")), time.Second)
+
+	_, err := c.GetMeasurement(context.Background())
+	if !errors.Is(err, ErrCertificateMismatch) {
+		t.Fatalf("expected ErrCertificateMismatch, got %v", err)
+	}
+}
+
+func TestV2Client_Unauthorized(t *testing.T) {
+	srv := newFakeMeter(t, 0)
+	c := NewV2Client(srv.URL, "wrong", CertFingerprint(srv.Certificate().Raw), time.Second)
+
+	if _, err := c.GetMeasurement(context.Background()); err == nil {
+		t.Fatalf("expected error for invalid token")
+	}
+}
+
+func TestV2Client_ReusesConnection(t *testing.T) {
+	var conns atomic.Int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"power_w":321}`))
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	c := NewV2Client(srv.URL, "ABCDEF0123456789", CertFingerprint(srv.Certificate().Raw), time.Second)
+	defer c.CloseIdleConnections()
+	for i := 0; i < 5; i++ {
+		if _, err := c.GetMeasurement(context.Background()); err != nil {
+			t.Fatalf("get measurement: %v", err)
+		}
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("expected 5 polls over 1 connection, got %d connections", n)
+	}
+}
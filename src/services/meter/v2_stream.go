@@ -0,0 +1,141 @@
+package meter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrUnauthorized is returned when the device rejects the stored token on the
+// websocket; reconnecting will not help, so Stream gives up.
+var ErrUnauthorized = errors.New("meter rejected token")
+
+// Backoff bounds for reconnecting the measurement stream
+const (
+	streamMinBackoff = time.Second
+	streamMaxBackoff = time.Minute
+)
+
+// wsMessage is the envelope of every message on the v2 websocket
+type wsMessage struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// MeasurementHandler receives each pushed measurement payload
+type MeasurementHandler func(ctx context.Context, measurement []byte) error
+
+// Stream keeps a websocket open to /api/ws, authenticates with the stored
+// token, subscribes to measurements and calls handle for every push. Dropped
+// connections are re-established with exponential backoff until ctx is done.
+// Handler errors are logged and do not close the stream.
+func (c *V2Client) Stream(ctx context.Context, handle MeasurementHandler) error {
+	if c.Token == "" {
+		return errors.New("meter token not set; run metercli pair")
+	}
+	if c.CertSHA256 == "" {
+		return errors.New("meter certificate fingerprint not set; run metercli pair")
+	}
+
+	backoff := streamMinBackoff
+	for {
+		subscribed, err := c.streamOnce(ctx, handle)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, ErrUnauthorized) {
+			return err
+		}
+		if subscribed {
+			// the connection was healthy before it dropped
+			backoff = streamMinBackoff
+		}
+		log.Printf("meter stream disconnected: %v; reconnecting in %s\n", err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > streamMaxBackoff {
+			backoff = streamMaxBackoff
+		}
+	}
+}
+
+// streamOnce runs a single websocket session. It reports whether the
+// subscription was established so the caller can reset its backoff.
+func (c *V2Client) streamOnce(ctx context.Context, handle MeasurementHandler) (bool, error) {
+	dialer := websocket.Dialer{
+		TLSClientConfig:  tlsConfig(c.CertSHA256),
+		HandshakeTimeout: c.timeout(),
+	}
+	header := http.Header{}
+	header.Set("X-Api-Version", "2")
+
+	conn, _, err := dialer.DialContext(ctx, wsURL(c.BaseURL), header)
+	if err != nil {
+		return false, fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+
+	// close the connection when ctx is cancelled to unblock ReadMessage
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	subscribed := false
+	for {
+		var msg wsMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return subscribed, fmt.Errorf("read: %w", err)
+		}
+		if ctx.Err() != nil {
+			return subscribed, ctx.Err()
+		}
+		switch msg.Type {
+		case "authorization_requested":
+			token, _ := json.Marshal(c.Token)
+			if err := conn.WriteJSON(wsMessage{Type: "authorization", Data: token}); err != nil {
+				return subscribed, fmt.Errorf("authorize: %w", err)
+			}
+		case "authorized":
+			topic, _ := json.Marshal("measurement")
+			if err := conn.WriteJSON(wsMessage{Type: "subscribe", Data: topic}); err != nil {
+				return subscribed, fmt.Errorf("subscribe: %w", err)
+			}
+			subscribed = true
+		case "measurement":
+			if err := handle(ctx, msg.Data); err != nil {
+				log.Printf("meter stream handler error: %v\n", err)
+			}
+		case "error":
+			if strings.Contains(string(msg.Data), "unauthorized") {
+				return subscribed, ErrUnauthorized
+			}
+			return subscribed, fmt.Errorf("meter error: %s", string(msg.Data))
+		}
+	}
+}
+
+// wsURL converts the https base URL into the wss websocket endpoint
+func wsURL(baseURL string) string {
+	u := strings.TrimRight(baseURL, "/")
+	u = strings.Replace(u, "https://", "wss://", 1)
+	u = strings.Replace(u, "http://", "ws://", 1)
+	return u + "/api/ws"
+}
@@ -0,0 +1,95 @@
+package meter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newFakeStream starts a TLS websocket stand-in that performs the v2
+// authorization handshake and then pushes perConn measurements before
+// dropping the connection.
+func newFakeStream(t *testing.T, token string, perConn int) (*httptest.Server, *int32) {
+	t.Helper()
+	var conns int32
+	upgrader := websocket.Upgrader{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		atomic.AddInt32(&conns, 1)
+
+		conn.WriteJSON(map[string]interface{}{"type": "authorization_requested", "data": map[string]string{"api_version": "2.0.0"}})
+		var auth struct {
+			Type string `json:"type"`
+			Data string `json:"data"`
+		}
+		if err := conn.ReadJSON(&auth); err != nil || auth.Type != "authorization" {
+			return
+		}
+		if auth.Data != token {
+			conn.WriteJSON(map[string]string{"type": "error", "data": "user:unauthorized"})
+			return
+		}
+		conn.WriteJSON(map[string]string{"type": "authorized"})
+		var sub struct {
+			Type string `json:"type"`
+			Data string `json:"data"`
+		}
+		if err := conn.ReadJSON(&sub); err != nil || sub.Type != "subscribe" || sub.Data != "measurement" {
+			return
+		}
+		for i := 0; i < perConn; i++ {
+			conn.WriteJSON(map[string]interface{}{"type": "measurement", "data": map[string]float64{"power_w": float64(100 + i)}})
+		}
+	})
+	srv := httptest.NewTLSServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, &conns
+}
+
+func TestV2Client_StreamReconnects(t *testing.T) {
+	srv, conns := newFakeStream(t, "secret", 2)
+	c := NewV2Client(srv.URL, "secret", CertFingerprint(srv.Certificate().Raw), time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	received := 0
+	err := c.Stream(ctx, func(ctx context.Context, m []byte) error {
+		received++
+		if received == 3 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if received != 3 {
+		t.Fatalf("expected 3 measurements, got %d", received)
+	}
+	if n := atomic.LoadInt32(conns); n < 2 {
+		t.Fatalf("expected a reconnect, got %d connections", n)
+	}
+}
+
+func TestV2Client_StreamUnauthorized(t *testing.T) {
+	srv, _ := newFakeStream(t, "secret", 1)
+	c := NewV2Client(srv.URL, "wrong", CertFingerprint(srv.Certificate().Raw), time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Stream(ctx, func(context.Context, []byte) error { return nil }); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+}
@@ -0,0 +1,307 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/harrybawsac/p1-go/migrations"
+	"github.com/lib/pq"
+)
+
+// LockKey is the advisory lock taken while migrating. It differs from the
+// scheduler's key so a running collector does not block a migration.
+const LockKey int64 = 0x7031_6d69_6772 // "p1migr"
+
+// ErrSchemaBehind is returned by Check when migrations are pending
+var ErrSchemaBehind = errors.New("database schema is behind")
+
+const createTable = `CREATE SCHEMA IF NOT EXISTS p1;
+CREATE TABLE IF NOT EXISTS p1.schema_migrations (
+	version INT PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`
+
+// Migration is one numbered schema change with its revert
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status describes a known migration and whether it has been applied
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(.+?)(\.down)?\.sql$`)
+
+// Load reads NNN_name.sql / NNN_name.down.sql pairs from fsys, ordered by
+// version. Every version must have both files and versions must be unique.
+func Load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, f := range files {
+		m := fileName.FindStringSubmatch(path.Base(f))
+		if m == nil {
+			return nil, fmt.Errorf("migration %s: name must be NNN_name.sql or NNN_name.down.sql", f)
+		}
+		version, _ := strconv.Atoi(m[1])
+		body, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			return nil, err
+		}
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has files with different names: %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] != "" {
+			mig.Down = string(body)
+		} else {
+			mig.Up = string(body)
+		}
+	}
+
+	out := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("migration %03d_%s needs both an up and a down file", mig.Version, mig.Name)
+		}
+		out = append(out, *mig)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// Migrator applies migrations to a PostgreSQL database, recording applied
+// versions in p1.schema_migrations. Each migration runs in its own
+// transaction and the whole run holds an advisory lock, so concurrent
+// invocations wait for each other instead of applying a version twice.
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+}
+
+// New returns a Migrator for the migrations embedded in the binary
+func New(db *sql.DB) (*Migrator, error) {
+	migs, err := Load(migrations.FS)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Migrations: migs}, nil
+}
+
+// Latest is the highest known migration version, or 0 if there are none
+func (m *Migrator) Latest() int {
+	if len(m.Migrations) == 0 {
+		return 0
+	}
+	return m.Migrations[len(m.Migrations)-1].Version
+}
+
+// Status lists every known migration with its applied state
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx, m.DB)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Status, len(m.Migrations))
+	for i, mig := range m.Migrations {
+		at, ok := applied[mig.Version]
+		out[i] = Status{Migration: mig, Applied: ok, AppliedAt: at}
+	}
+	return out, nil
+}
+
+// Current returns the highest applied version, or 0 on a fresh database
+func (m *Migrator) Current(ctx context.Context) (int, error) {
+	applied, err := m.applied(ctx, m.DB)
+	if err != nil {
+		return 0, err
+	}
+	current := 0
+	for v := range applied {
+		if v > current {
+			current = v
+		}
+	}
+	return current, nil
+}
+
+// Check returns an error wrapping ErrSchemaBehind when any known migration
+// has not been applied
+func (m *Migrator) Check(ctx context.Context) error {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	var pending []string
+	for _, s := range status {
+		if !s.Applied {
+			pending = append(pending, fmt.Sprintf("%03d", s.Version))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: pending migrations %s; run metercli migrate up", ErrSchemaBehind, strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// Up applies all pending migrations
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.To(ctx, m.Latest())
+}
+
+// Down reverts the most recently applied migration
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.Migrations) - 1; i >= 0; i-- {
+			if _, ok := applied[m.Migrations[i].Version]; ok {
+				return m.revert(ctx, conn, m.Migrations[i])
+			}
+		}
+		return nil
+	})
+}
+
+// To migrates up or down until exactly the migrations numbered <= target are
+// applied
+func (m *Migrator) To(ctx context.Context, target int) error {
+	if target < 0 || target > m.Latest() {
+		return fmt.Errorf("unknown target version %d (latest is %d)", target, m.Latest())
+	}
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.Migrations) - 1; i >= 0; i-- {
+			mig := m.Migrations[i]
+			if _, ok := applied[mig.Version]; ok && mig.Version > target {
+				if err := m.revert(ctx, conn, mig); err != nil {
+					return err
+				}
+			}
+		}
+		for _, mig := range m.Migrations {
+			if _, ok := applied[mig.Version]; !ok && mig.Version <= target {
+				if err := m.apply(ctx, conn, mig); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mig Migration) error {
+	return inTx(ctx, conn, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, mig.Up); err != nil {
+			return fmt.Errorf("apply %03d_%s: %w", mig.Version, mig.Name, err)
+		}
+		_, err := tx.ExecContext(ctx, "INSERT INTO p1.schema_migrations (version, name) VALUES ($1, $2)", mig.Version, mig.Name)
+		return err
+	})
+}
+
+func (m *Migrator) revert(ctx context.Context, conn *sql.Conn, mig Migration) error {
+	return inTx(ctx, conn, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, mig.Down); err != nil {
+			return fmt.Errorf("revert %03d_%s: %w", mig.Version, mig.Name, err)
+		}
+		_, err := tx.ExecContext(ctx, "DELETE FROM p1.schema_migrations WHERE version = $1", mig.Version)
+		return err
+	})
+}
+
+// withLock runs fn on a dedicated connection holding the migration advisory
+// lock. Advisory locks belong to a session, so lock, migrations and unlock
+// must all use the same connection.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", LockKey); err != nil {
+		return fmt.Errorf("migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", LockKey)
+
+	if _, err := conn.ExecContext(ctx, createTable); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	return fn(conn)
+}
+
+// querier is satisfied by *sql.DB and *sql.Conn
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// applied returns the applied versions and when they were applied. A missing
+// schema_migrations table means nothing has been applied yet.
+func (m *Migrator) applied(ctx context.Context, q querier) (map[int]time.Time, error) {
+	rows, err := q.QueryContext(ctx, "SELECT version, applied_at FROM p1.schema_migrations")
+	if err != nil {
+		if isUndefinedTable(err) {
+			return map[int]time.Time{}, nil
+		}
+		return nil, fmt.Errorf("read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	out := map[int]time.Time{}
+	for rows.Next() {
+		var v int
+		var at time.Time
+		if err := rows.Scan(&v, &at); err != nil {
+			return nil, err
+		}
+		out[v] = at
+	}
+	return out, rows.Err()
+}
+
+func inTx(ctx context.Context, conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// isUndefinedTable reports whether err is PostgreSQL's undefined_table or
+// invalid_schema_name, i.e. the database has never been migrated
+func isUndefinedTable(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && (pqErr.Code == "42P01" || pqErr.Code == "3F000")
+}
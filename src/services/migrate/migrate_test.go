@@ -0,0 +1,126 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/harrybawsac/p1-go/migrations"
+)
+
+func testMigrations() fstest.MapFS {
+	return fstest.MapFS{
+		"001_first.sql":       {Data: []byte("CREATE TABLE a ()")},
+		"001_first.down.sql":  {Data: []byte("DROP TABLE a")},
+		"002_second.sql":      {Data: []byte("CREATE TABLE b ()")},
+		"002_second.down.sql": {Data: []byte("DROP TABLE b")},
+	}
+}
+
+func TestLoadEmbedded(t *testing.T) {
+	migs, err := Load(migrations.FS)
+	if err != nil {
+		t.Fatalf("load embedded migrations: %v", err)
+	}
+	for i, m := range migs {
+		if m.Version != i+1 {
+			t.Errorf("expected contiguous versions, got %d at position %d", m.Version, i)
+		}
+	}
+	if len(migs) < 4 || migs[3].Name != "unique_reading_key" {
+		t.Errorf("unexpected migrations: %+v", migs)
+	}
+}
+
+func TestLoadRequiresDown(t *testing.T) {
+	fsys := testMigrations()
+	delete(fsys, "002_second.down.sql")
+	if _, err := Load(fsys); err == nil {
+		t.Fatalf("expected error for migration without down file")
+	}
+}
+
+func TestUpAppliesPending(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock db: %v", err)
+	}
+	defer db.Close()
+
+	migs, err := Load(testMigrations())
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	m := &Migrator{DB: db, Migrations: migs}
+
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_lock($1)")).WithArgs(LockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS p1.schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, applied_at FROM p1.schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE b ()")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO p1.schema_migrations").WithArgs(2, "second").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_unlock($1)")).WithArgs(LockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := m.Up(context.Background()); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestToRollsBackFailedMigration(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock db: %v", err)
+	}
+	defer db.Close()
+
+	migs, _ := Load(testMigrations())
+	m := &Migrator{DB: db, Migrations: migs}
+
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_lock($1)")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS p1.schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, applied_at FROM p1.schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, time.Now()).AddRow(2, time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DROP TABLE b")).WillReturnError(errors.New("boom"))
+	mock.ExpectRollback()
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_unlock($1)")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := m.To(context.Background(), 1); err == nil {
+		t.Fatalf("expected error from failing down migration")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCheckReportsPending(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock db: %v", err)
+	}
+	defer db.Close()
+
+	migs, _ := Load(testMigrations())
+	m := &Migrator{DB: db, Migrations: migs}
+
+	mock.ExpectQuery("SELECT version, applied_at FROM p1.schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, time.Now()))
+	if err := m.Check(context.Background()); !errors.Is(err, ErrSchemaBehind) {
+		t.Fatalf("expected ErrSchemaBehind, got %v", err)
+	}
+
+	mock.ExpectQuery("SELECT version, applied_at FROM p1.schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, time.Now()).AddRow(2, time.Now()))
+	if err := m.Check(context.Background()); err != nil {
+		t.Fatalf("expected up-to-date schema, got %v", err)
+	}
+}
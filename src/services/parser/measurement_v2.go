@@ -0,0 +1,152 @@
+package parser
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/models"
+	"github.com/harrybawsac/p1-go/src/services/dsmr"
+)
+
+// MeasurementV2 is the payload of the HomeWizard API v2 /api/measurement
+// endpoint. Field names differ from the v1 /api/v1/data payload.
+type MeasurementV2 struct {
+	Tariff              int        `json:"tariff"`
+	EnergyImportKwh     float64    `json:"energy_import_kwh"`
+	EnergyImportT1Kwh   float64    `json:"energy_import_t1_kwh"`
+	EnergyImportT2Kwh   float64    `json:"energy_import_t2_kwh"`
+	EnergyExportKwh     float64    `json:"energy_export_kwh"`
+	EnergyExportT1Kwh   float64    `json:"energy_export_t1_kwh"`
+	EnergyExportT2Kwh   float64    `json:"energy_export_t2_kwh"`
+	PowerW              float64    `json:"power_w"`
+	PowerL1W            float64    `json:"power_l1_w"`
+	PowerL2W            float64    `json:"power_l2_w"`
+	PowerL3W            float64    `json:"power_l3_w"`
+	VoltageL1V          float64    `json:"voltage_l1_v"`
+	VoltageL2V          float64    `json:"voltage_l2_v"`
+	VoltageL3V          float64    `json:"voltage_l3_v"`
+	CurrentA            float64    `json:"current_a"`
+	CurrentL1A          float64    `json:"current_l1_a"`
+	CurrentL2A          float64    `json:"current_l2_a"`
+	CurrentL3A          float64    `json:"current_l3_a"`
+	VoltageSagL1Count   int        `json:"voltage_sag_l1_count"`
+	VoltageSagL2Count   int        `json:"voltage_sag_l2_count"`
+	VoltageSagL3Count   int        `json:"voltage_sag_l3_count"`
+	VoltageSwellL1Count int        `json:"voltage_swell_l1_count"`
+	VoltageSwellL2Count int        `json:"voltage_swell_l2_count"`
+	VoltageSwellL3Count int        `json:"voltage_swell_l3_count"`
+	AnyPowerFailCount   int        `json:"any_power_fail_count"`
+	LongPowerFailCount  int        `json:"long_power_fail_count"`
+	External            []External `json:"external"`
+
+	// Belgian meters only
+	AveragePower15mW          float64         `json:"average_power_15m_w"`
+	MonthlyPowerPeakW         float64         `json:"monthly_power_peak_w"`
+	MonthlyPowerPeakTimestamp json.RawMessage `json:"monthly_power_peak_timestamp"`
+}
+
+// IsMeasurementV2 reports whether data looks like an API v2 measurement
+// rather than a v1 data payload.
+func IsMeasurementV2(data []byte) bool {
+	var probe struct {
+		EnergyImportKwh *float64 `json:"energy_import_kwh"`
+		PowerW          *float64 `json:"power_w"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return false
+	}
+	return probe.EnergyImportKwh != nil || probe.PowerW != nil
+}
+
+// ParseMeasurementV2 parses an API v2 measurement payload into a Reading. Gas
+// is taken from the first "gas_meter" entry of the external array.
+func ParseMeasurementV2(data []byte) (models.Reading, error) {
+	var m MeasurementV2
+	if err := json.Unmarshal(data, &m); err != nil {
+		return models.Reading{}, err
+	}
+
+	r := models.Reading{
+		ActiveTariff:          m.Tariff,
+		TotalPowerImportKwh:   m.EnergyImportKwh,
+		TotalPowerImportT1Kwh: m.EnergyImportT1Kwh,
+		TotalPowerImportT2Kwh: m.EnergyImportT2Kwh,
+		TotalPowerExportKwh:   m.EnergyExportKwh,
+		TotalPowerExportT1Kwh: m.EnergyExportT1Kwh,
+		TotalPowerExportT2Kwh: m.EnergyExportT2Kwh,
+		ActivePowerW:          m.PowerW,
+		ActivePowerL1W:        m.PowerL1W,
+		ActivePowerL2W:        m.PowerL2W,
+		ActivePowerL3W:        m.PowerL3W,
+		ActiveVoltageL1V:      m.VoltageL1V,
+		ActiveVoltageL2V:      m.VoltageL2V,
+		ActiveVoltageL3V:      m.VoltageL3V,
+		ActiveCurrentA:        m.CurrentA,
+		ActiveCurrentL1A:      m.CurrentL1A,
+		ActiveCurrentL2A:      m.CurrentL2A,
+		ActiveCurrentL3A:      m.CurrentL3A,
+		VoltageSagL1Count:     m.VoltageSagL1Count,
+		VoltageSagL2Count:     m.VoltageSagL2Count,
+		VoltageSagL3Count:     m.VoltageSagL3Count,
+		VoltageSwellL1Count:   m.VoltageSwellL1Count,
+		VoltageSwellL2Count:   m.VoltageSwellL2Count,
+		VoltageSwellL3Count:   m.VoltageSwellL3Count,
+		AnyPowerFailCount:     m.AnyPowerFailCount,
+		LongPowerFailCount:    m.LongPowerFailCount,
+		External:              externalReadings(m.External),
+
+		ActivePowerAverageW:       m.AveragePower15mW,
+		MonthlyPowerPeakW:         m.MonthlyPowerPeakW,
+		MonthlyPowerPeakTimestamp: externalTimestamp(m.MonthlyPowerPeakTimestamp),
+		MonthlyPowerPeakAt:        externalTime(m.MonthlyPowerPeakTimestamp),
+	}
+	for _, ext := range m.External {
+		if ext.Type == "gas_meter" {
+			r.TotalGasM3 = ext.Value
+			r.GasTimestamp = externalTimestamp(ext.Timestamp)
+			r.GasMeasuredAt = externalTime(ext.Timestamp)
+			break
+		}
+	}
+	return r, nil
+}
+
+// externalTime decodes an external or peak timestamp in either form to a
+// time in Dutch local time, zero when it is missing or malformed
+func externalTime(raw json.RawMessage) time.Time {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return time.Time{}
+		}
+		return t.In(dsmr.Location)
+	}
+	var n int64
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return time.Time{}
+	}
+	return meterTime(n)
+}
+
+// externalTimestamp accepts both the v1 numeric YYMMDDhhmmss form and the
+// RFC 3339 string used by v2 firmware, returning the numeric form. Like the
+// meter's own timestamps it is in Dutch local time, whatever offset the
+// string carries.
+func externalTimestamp(raw json.RawMessage) int64 {
+	var n int64
+	if err := json.Unmarshal(raw, &n); err == nil {
+		return n
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return 0
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0
+	}
+	n, _ = strconv.ParseInt(t.In(dsmr.Location).Format("060102150405"), 10, 64)
+	return n
+}
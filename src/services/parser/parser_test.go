@@ -1,9 +1,12 @@
 package parser
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/harrybawsac/p1-go/src/models"
 )
@@ -24,3 +27,404 @@ func TestParseFullReading_File(t *testing.T) {
 	// quick sanity check types
 	_ = models.Reading{}
 }
+
+func TestParseTelegram_Electricity(t *testing.T) {
+	telegram := "/ISk5\\2MT382-1000\r\n" +
+		"\r\n" +
+		"1-0:1.8.1(000100.500*kWh)\r\n" +
+		"1-0:1.8.2(000200.250*kWh)\r\n" +
+		"1-0:2.8.1(000010.000*kWh)\r\n" +
+		"1-0:2.8.2(000020.000*kWh)\r\n" +
+		"0-0:96.14.0(0002)\r\n" +
+		"1-0:1.7.0(01.193*kW)\r\n" +
+		"1-0:2.7.0(00.193*kW)\r\n" +
+		"!864D\r\n"
+
+	r, err := ParseTelegram([]byte(telegram))
+	if err != nil {
+		t.Fatalf("parse telegram: %v", err)
+	}
+	if r.ActiveTariff != 2 {
+		t.Errorf("expected tariff 2, got %d", r.ActiveTariff)
+	}
+	if r.TotalPowerImportKwh != 300.75 {
+		t.Errorf("expected total import 300.75, got %f", r.TotalPowerImportKwh)
+	}
+	if r.TotalPowerExportKwh != 30 {
+		t.Errorf("expected total export 30, got %f", r.TotalPowerExportKwh)
+	}
+	if r.ActivePowerW != 1000 {
+		t.Errorf("expected active power 1000 W, got %f", r.ActivePowerW)
+	}
+}
+
+// dsmr5Telegram mirrors meter_sample.json as a DSMR 5.0 telegram with a valid CRC
+var dsmr5Telegram = strings.Join([]string{
+	`/ISK5\2M550T-1012`,
+	``,
+	`1-3:0.2.8(50)`,
+	`0-0:1.0.0(251003101003S)`,
+	`0-0:96.1.1(4530303434303037313331363530323138)`,
+	`1-0:1.8.1(008732.008*kWh)`,
+	`1-0:1.8.2(007420.327*kWh)`,
+	`1-0:2.8.1(002239.556*kWh)`,
+	`1-0:2.8.2(005090.173*kWh)`,
+	`0-0:96.14.0(0002)`,
+	`1-0:1.7.0(00.400*kW)`,
+	`1-0:2.7.0(00.079*kW)`,
+	`0-0:96.7.21(00006)`,
+	`0-0:96.7.9(00005)`,
+	`1-0:99.97.0(1)(0-0:96.7.19)(190911154427S)(0000000219*s)`,
+	`1-0:32.32.0(00018)`,
+	`1-0:52.32.0(00021)`,
+	`1-0:72.32.0(00022)`,
+	`1-0:32.36.0(00000)`,
+	`1-0:52.36.0(00002)`,
+	`1-0:72.36.0(00000)`,
+	`0-0:96.13.0()`,
+	`1-0:32.7.0(236.6*V)`,
+	`1-0:52.7.0(234.0*V)`,
+	`1-0:72.7.0(238.0*V)`,
+	`1-0:31.7.0(000*A)`,
+	`1-0:51.7.0(001*A)`,
+	`1-0:71.7.0(000*A)`,
+	`1-0:21.7.0(00.138*kW)`,
+	`1-0:41.7.0(00.202*kW)`,
+	`1-0:61.7.0(00.060*kW)`,
+	`1-0:22.7.0(00.000*kW)`,
+	`1-0:42.7.0(00.079*kW)`,
+	`1-0:62.7.0(00.000*kW)`,
+	`0-1:24.1.0(003)`,
+	`0-1:96.1.0(4730303339303031393338343636303139)`,
+	`0-1:24.2.1(251003101003S)(03571.732*m3)`,
+	`!DC7F`,
+	``,
+}, "\r\n")
+
+func TestParseTelegram_DSMR5(t *testing.T) {
+	r, err := ParseTelegram([]byte(dsmr5Telegram))
+	if err != nil {
+		t.Fatalf("parse telegram: %v", err)
+	}
+	if r.TotalPowerImportT1Kwh != 8732.008 || r.TotalPowerImportT2Kwh != 7420.327 {
+		t.Errorf("unexpected import counters: %f / %f", r.TotalPowerImportT1Kwh, r.TotalPowerImportT2Kwh)
+	}
+	if r.ActivePowerW != 321 {
+		t.Errorf("expected net active power 321 W, got %f", r.ActivePowerW)
+	}
+	if r.ActivePowerL2W != 123 {
+		t.Errorf("expected L2 power 123 W, got %f", r.ActivePowerL2W)
+	}
+	if r.ActiveVoltageL1V != 236.6 {
+		t.Errorf("expected L1 voltage 236.6, got %f", r.ActiveVoltageL1V)
+	}
+	if r.VoltageSagL3Count != 22 || r.VoltageSwellL2Count != 2 {
+		t.Errorf("unexpected sag/swell counts: %d / %d", r.VoltageSagL3Count, r.VoltageSwellL2Count)
+	}
+	if r.AnyPowerFailCount != 6 || r.LongPowerFailCount != 5 {
+		t.Errorf("unexpected power fail counts: %d / %d", r.AnyPowerFailCount, r.LongPowerFailCount)
+	}
+	if r.TotalGasM3 != 3571.732 {
+		t.Errorf("expected gas 3571.732, got %f", r.TotalGasM3)
+	}
+	if r.GasTimestamp != 251003101003 {
+		t.Errorf("expected gas timestamp 251003101003, got %d", r.GasTimestamp)
+	}
+	if want := time.Date(2025, 10, 3, 8, 10, 3, 0, time.UTC); !r.GasMeasuredAt.Equal(want) {
+		t.Errorf("expected gas measured at %v, got %v", want, r.GasMeasuredAt)
+	}
+	wantDevice := models.Device{UniqueID: "4530303434303037313331363530323138", Model: `ISK5\2M550T-1012`, SMRVersion: 50}
+	if r.Device == nil || *r.Device != wantDevice {
+		t.Errorf("unexpected device: %+v", r.Device)
+	}
+}
+
+func TestParseTelegram_DSMR22Gas(t *testing.T) {
+	telegram := "/KMP5 ZABF001587315111\r\n\r\n" +
+		"1-0:1.8.1(00185.000*kWh)\r\n" +
+		"1-0:1.8.2(00084.000*kWh)\r\n" +
+		"0-0:96.14.0(0001)\r\n" +
+		"1-0:1.7.0(0000.98*kW)\r\n" +
+		"0-1:24.1.0(3)\r\n" +
+		"0-1:24.3.0(120517020000)(08)(60)(1)(0-1:24.2.1)(m3)\r\n" +
+		"(00124.477)\r\n" +
+		"0-1:24.4.0(1)\r\n" +
+		"!\r\n"
+
+	r, err := ParseTelegram([]byte(telegram))
+	if err != nil {
+		t.Fatalf("parse telegram: %v", err)
+	}
+	if r.TotalPowerImportKwh != 269 {
+		t.Errorf("expected total import 269, got %f", r.TotalPowerImportKwh)
+	}
+	if r.TotalGasM3 != 124.477 {
+		t.Errorf("expected gas 124.477, got %f", r.TotalGasM3)
+	}
+	if r.GasTimestamp != 120517020000 {
+		t.Errorf("expected gas timestamp 120517020000, got %d", r.GasTimestamp)
+	}
+	if want := time.Date(2012, 5, 17, 0, 0, 0, 0, time.UTC); !r.GasMeasuredAt.Equal(want) {
+		t.Errorf("expected gas measured at %v, got %v", want, r.GasMeasuredAt)
+	}
+	if len(r.External) != 1 || r.External[0].Type != "gas_meter" || r.External[0].Unit != "m3" {
+		t.Errorf("unexpected external readings: %+v", r.External)
+	}
+}
+
+func TestParseTelegram_MBusChannels(t *testing.T) {
+	telegram := "/ISK5\\2M550T-1012\r\n\r\n" +
+		"1-0:1.8.1(008732.008*kWh)\r\n" +
+		"0-1:24.1.0(003)\r\n" +
+		"0-1:96.1.0(4730303339303031393338343636303139)\r\n" +
+		"0-1:24.2.1(251003101003S)(03571.732*m3)\r\n" +
+		"0-2:24.1.0(007)\r\n" +
+		"0-2:96.1.0(3232323241424344313233343536373839)\r\n" +
+		"0-2:24.2.1(251003100500S)(00412.108*m3)\r\n" +
+		"0-3:24.1.0(004)\r\n" +
+		"0-3:24.2.1(251003100000S)(00031.250*GJ)\r\n" +
+		"0-4:24.1.0(010)\r\n" +
+		"0-4:24.2.1(251003100000S)(00001.500*GJ)\r\n" +
+		"!\r\n"
+
+	r, err := ParseTelegram([]byte(telegram))
+	if err != nil {
+		t.Fatalf("parse telegram: %v", err)
+	}
+	if r.TotalGasM3 != 3571.732 {
+		t.Errorf("expected gas 3571.732, got %f", r.TotalGasM3)
+	}
+	want := []models.ExternalReading{
+		{UniqueID: "4730303339303031393338343636303139", Type: "gas_meter", Timestamp: 251003101003, Value: 3571.732, Unit: "m3"},
+		{UniqueID: "3232323241424344313233343536373839", Type: "water_meter", Timestamp: 251003100500, Value: 412.108, Unit: "m3"},
+		{Type: "heat_meter", Timestamp: 251003100000, Value: 31.25, Unit: "GJ"},
+		{Type: "unknown", Timestamp: 251003100000, Value: 1.5, Unit: "GJ"},
+	}
+	if len(r.External) != len(want) {
+		t.Fatalf("expected %d external readings, got %+v", len(want), r.External)
+	}
+	for i := range want {
+		if r.External[i] != want[i] {
+			t.Errorf("external[%d] = %+v, want %+v", i, r.External[i], want[i])
+		}
+	}
+	kinds := []string{models.KindGas, models.KindWater, models.KindHeat, models.KindHeat}
+	for i, k := range kinds {
+		if got := r.External[i].Kind(); got != k {
+			t.Errorf("external[%d] kind = %q, want %q", i, got, k)
+		}
+	}
+}
+
+func TestParseTelegram_TwoGasMeters(t *testing.T) {
+	telegram := "/ISK5\\2M550T-1012\r\n\r\n" +
+		"1-0:1.8.1(008732.008*kWh)\r\n" +
+		"0-2:24.1.0(003)\r\n" +
+		"0-2:24.2.1(251003101003S)(00100.000*m3)\r\n" +
+		"0-3:24.1.0(003)\r\n" +
+		"0-3:24.2.1(251003100500S)(00200.000*m3)\r\n" +
+		"!\r\n"
+
+	// the device types are kept in a map, so parse a few times
+	for i := 0; i < 20; i++ {
+		r, err := ParseTelegram([]byte(telegram))
+		if err != nil {
+			t.Fatalf("parse telegram: %v", err)
+		}
+		if r.TotalGasM3 != 100 || r.GasTimestamp != 251003101003 {
+			t.Fatalf("expected the gas meter on channel 2, got %f at %d", r.TotalGasM3, r.GasTimestamp)
+		}
+	}
+}
+
+func TestParseTelegram_RejectsCorrupted(t *testing.T) {
+	corrupted := strings.Replace(dsmr5Telegram, "008732.008", "008732.009", 1)
+	_, err := ParseTelegram([]byte(corrupted))
+	var crcErr *ChecksumError
+	if !errors.As(err, &crcErr) {
+		t.Fatalf("expected *ChecksumError, got %v", err)
+	}
+
+	_, err = ParseTelegram([]byte(dsmr5Telegram[:200]))
+	if !errors.Is(err, ErrMalformedTelegram) {
+		t.Fatalf("expected ErrMalformedTelegram for truncated telegram, got %v", err)
+	}
+	// only DSMR 2.2, which has no version line, may leave out the CRC
+	_, err = ParseTelegram([]byte(strings.Replace(dsmr5Telegram, "!DC7F", "!", 1)))
+	if !errors.Is(err, ErrMalformedTelegram) {
+		t.Fatalf("expected ErrMalformedTelegram for a DSMR 5 telegram without crc, got %v", err)
+	}
+
+	garbled := "/KMP5 ZABF001587315111\r\n\r\n" +
+		"1-0:1.8.1(00185.000*kWh)\r\n" +
+		"1-0:1.8.2(0008?.000*kWh)\r\n" +
+		"!\r\n"
+	_, err = ParseTelegram([]byte(garbled))
+	if !errors.Is(err, ErrMalformedTelegram) || !strings.Contains(err.Error(), "1-0:1.8.2") {
+		t.Fatalf("expected ErrMalformedTelegram naming 1-0:1.8.2 for a garbled value, got %v", err)
+	}
+}
+
+func TestParseMeasurementV2(t *testing.T) {
+	data := []byte(`{
+		"tariff": 2,
+		"energy_import_kwh": 16152.335,
+		"energy_import_t1_kwh": 8732.008,
+		"power_w": 321,
+		"voltage_l1_v": 236.6,
+		"any_power_fail_count": 6,
+		"external": [
+			{"unique_id": "G001", "type": "gas_meter", "timestamp": "2025-10-03T10:10:03+02:00", "value": 3571.732, "unit": "m3"}
+		]
+	}`)
+	if !IsMeasurementV2(data) {
+		t.Fatalf("expected payload to be detected as v2")
+	}
+	r, err := ParseMeasurementV2(data)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if r.ActiveTariff != 2 || r.TotalPowerImportKwh != 16152.335 || r.ActivePowerW != 321 {
+		t.Errorf("unexpected reading: %+v", r)
+	}
+	if r.TotalGasM3 != 3571.732 || r.GasTimestamp != 251003101003 {
+		t.Errorf("unexpected gas: %f at %d", r.TotalGasM3, r.GasTimestamp)
+	}
+	if want := time.Date(2025, 10, 3, 8, 10, 3, 0, time.UTC); !r.GasMeasuredAt.Equal(want) {
+		t.Errorf("expected gas measured at %v, got %v", want, r.GasMeasuredAt)
+	}
+	if len(r.External) != 1 || r.External[0].UniqueID != "G001" || r.External[0].Timestamp != 251003101003 {
+		t.Errorf("unexpected external readings: %+v", r.External)
+	}
+
+	// timestamps in another offset are stored in Dutch local time
+	r, err = ParseMeasurementV2([]byte(`{
+		"energy_import_kwh": 1,
+		"external": [{"unique_id": "G001", "type": "gas_meter", "timestamp": "2025-10-03T08:10:03Z", "value": 1, "unit": "m3"}]
+	}`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if r.GasTimestamp != 251003101003 || len(r.External) != 1 || r.External[0].Timestamp != 251003101003 {
+		t.Errorf("expected gas timestamp 251003101003, got %d (%+v)", r.GasTimestamp, r.External)
+	}
+}
+
+func TestParseCapacityTariffFields(t *testing.T) {
+	v1, _, err := ParseFullReadingStrict([]byte(`{
+		"total_power_import_kwh": 1000, "total_power_export_kwh": 0, "active_power_w": 500,
+		"active_power_average_w": 1234.5, "montly_power_peak_w": 4321, "montly_power_peak_timestamp": 251001183000
+	}`))
+	if err != nil {
+		t.Fatalf("parse v1: %v", err)
+	}
+	v2, err := ParseMeasurementV2([]byte(`{
+		"energy_import_kwh": 1000, "power_w": 500,
+		"average_power_15m_w": 1234.5, "monthly_power_peak_w": 4321, "monthly_power_peak_timestamp": "2025-10-01T18:30:00+02:00"
+	}`))
+	if err != nil {
+		t.Fatalf("parse v2: %v", err)
+	}
+	telegram := "/FLU5\\253769484_A\r\n\r\n" +
+		"0-0:96.1.1(3153414733313031303231363035)\r\n" +
+		"1-0:1.8.1(000100.000*kWh)\r\n" +
+		"1-0:1.4.0(01.2345*kW)\r\n" +
+		"1-0:1.6.0(251001183000S)(04.321*kW)\r\n" +
+		"!\r\n"
+	tg, err := ParseTelegram([]byte(telegram))
+	if err != nil {
+		t.Fatalf("parse telegram: %v", err)
+	}
+
+	peakAt := time.Date(2025, 10, 1, 16, 30, 0, 0, time.UTC)
+	for name, r := range map[string]models.Reading{"v1": v1, "v2": v2, "telegram": tg} {
+		if r.ActivePowerAverageW != 1234.5 && !(name == "telegram" && r.ActivePowerAverageW == 1235) {
+			t.Errorf("%s: unexpected average power %v", name, r.ActivePowerAverageW)
+		}
+		if r.MonthlyPowerPeakW != 4321 || r.MonthlyPowerPeakTimestamp != 251001183000 || !r.MonthlyPowerPeakAt.Equal(peakAt) {
+			t.Errorf("%s: unexpected monthly peak %v W at %d (%v)", name, r.MonthlyPowerPeakW, r.MonthlyPowerPeakTimestamp, r.MonthlyPowerPeakAt)
+		}
+	}
+}
+
+func TestParseFullReadingStrict_SamplePayload(t *testing.T) {
+	path := filepath.Join("..", "..", "..", "specs", "001-build-a-cli", "contracts", "meter_sample.json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read sample json: %v", err)
+	}
+	r, report, err := ParseFullReadingStrict(data)
+	if err != nil {
+		t.Fatalf("strict parse: %v", err)
+	}
+	if len(report.Issues) != 0 {
+		t.Errorf("unexpected issues: %s", report)
+	}
+	if r.TotalPowerImportKwh != 16152.335 || r.VoltageSagL3Count != 22 || r.GasTimestamp != 251003101003 {
+		t.Errorf("unexpected reading: %+v", r)
+	}
+	if want := time.Date(2025, 10, 3, 8, 10, 3, 0, time.UTC); !r.GasMeasuredAt.Equal(want) {
+		t.Errorf("expected gas measured at %v, got %v", want, r.GasMeasuredAt)
+	}
+	wantDevice := models.Device{UniqueID: "Unique ID", Model: "Model", SMRVersion: 50, WifiSSID: "Something", WifiStrength: 82}
+	if r.Device == nil || *r.Device != wantDevice {
+		t.Errorf("unexpected device: %+v", r.Device)
+	}
+	wantExt := models.ExternalReading{UniqueID: "Unique ID", Type: "gas_meter", Timestamp: 251003101003, Value: 3571.732, Unit: "m3"}
+	if len(r.External) != 1 || r.External[0] != wantExt {
+		t.Errorf("unexpected external readings: %+v", r.External)
+	}
+}
+
+func TestParseFullReadingStrict_Report(t *testing.T) {
+	data := []byte(`{
+		"unique_id": "M1",
+		"active_tariff": 2,
+		"total_power_import": 16152.335,
+		"total_power_export_kwh": "7329.729",
+		"active_power_w": 321,
+		"active_voltage_l1_v": 12.5,
+		"voltage_sag_l1_count": 1.5,
+		"any_power_fail_count": -1,
+		"external": [{"type": "water_meter", "value": -3, "unit": "m3"}, 4]
+	}`)
+
+	r, report, err := ParseFullReadingStrict(data)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected *ValidationError, got %v", err)
+	}
+	want := []Issue{
+		{Field: "active_voltage_l1_v", Kind: IssueOutOfRange, Detail: "12.5 V outside 180-270 V"},
+		{Field: "any_power_fail_count", Kind: IssueOutOfRange, Detail: "-1 outside >= 0"},
+		{Field: "external[0].value", Kind: IssueOutOfRange, Detail: "-3 outside >= 0"},
+		{Field: "external[1]", Kind: IssueWrongType, Detail: "want object, got number"},
+		{Field: "total_power_export_kwh", Kind: IssueWrongType, Detail: "want number, got string"},
+		{Field: "total_power_import", Kind: IssueUnknown},
+		{Field: "total_power_import_kwh", Kind: IssueMissing},
+		{Field: "voltage_sag_l1_count", Kind: IssueWrongType, Detail: "want integer, got 1.5"},
+	}
+	if len(report.Issues) != len(want) {
+		t.Fatalf("got issues %s", report)
+	}
+	for i, issue := range report.Issues {
+		if issue != want[i] {
+			t.Errorf("issue %d: got %s, want %s", i, issue, want[i])
+		}
+	}
+	// the lenient reading is still returned
+	if r.MeterID != "M1" || r.ActivePowerW != 321 || r.TotalPowerExportKwh != 0 {
+		t.Errorf("unexpected reading: %+v", r)
+	}
+}
+
+func TestValidationReport_UnknownFieldsStayValid(t *testing.T) {
+	data := []byte(`{"total_power_import_kwh": 1, "total_power_export_kwh": 0, "active_power_w": 5, "active_frequency_hz": 50}`)
+	_, report, err := ParseFullReadingStrict(data)
+	if err != nil {
+		t.Fatalf("unknown field should not invalidate the payload: %v", err)
+	}
+	if len(report.Issues) != 1 || report.Issues[0].Kind != IssueUnknown {
+		t.Errorf("expected one unknown field, got %s", report)
+	}
+}
@@ -0,0 +1,158 @@
+package parser
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/models"
+	"github.com/harrybawsac/p1-go/src/services/dsmr"
+)
+
+// DataV1 is the payload of the HomeWizard API v1 /api/v1/data endpoint.
+// Counts are decoded as numbers because meters report them as 18.0.
+type DataV1 struct {
+	WifiSSID                  string     `json:"wifi_ssid"`
+	WifiStrength              float64    `json:"wifi_strength"`
+	SMRVersion                float64    `json:"smr_version"`
+	MeterModel                string     `json:"meter_model"`
+	UniqueID                  string     `json:"unique_id"`
+	ActiveTariff              float64    `json:"active_tariff"`
+	TotalPowerImportKwh       float64    `json:"total_power_import_kwh"`
+	TotalPowerImportT1Kwh     float64    `json:"total_power_import_t1_kwh"`
+	TotalPowerImportT2Kwh     float64    `json:"total_power_import_t2_kwh"`
+	TotalPowerExportKwh       float64    `json:"total_power_export_kwh"`
+	TotalPowerExportT1Kwh     float64    `json:"total_power_export_t1_kwh"`
+	TotalPowerExportT2Kwh     float64    `json:"total_power_export_t2_kwh"`
+	ActivePowerW              float64    `json:"active_power_w"`
+	ActivePowerL1W            float64    `json:"active_power_l1_w"`
+	ActivePowerL2W            float64    `json:"active_power_l2_w"`
+	ActivePowerL3W            float64    `json:"active_power_l3_w"`
+	ActiveVoltageL1V          float64    `json:"active_voltage_l1_v"`
+	ActiveVoltageL2V          float64    `json:"active_voltage_l2_v"`
+	ActiveVoltageL3V          float64    `json:"active_voltage_l3_v"`
+	ActiveCurrentA            float64    `json:"active_current_a"`
+	ActiveCurrentL1A          float64    `json:"active_current_l1_a"`
+	ActiveCurrentL2A          float64    `json:"active_current_l2_a"`
+	ActiveCurrentL3A          float64    `json:"active_current_l3_a"`
+	VoltageSagL1Count         float64    `json:"voltage_sag_l1_count"`
+	VoltageSagL2Count         float64    `json:"voltage_sag_l2_count"`
+	VoltageSagL3Count         float64    `json:"voltage_sag_l3_count"`
+	VoltageSwellL1Count       float64    `json:"voltage_swell_l1_count"`
+	VoltageSwellL2Count       float64    `json:"voltage_swell_l2_count"`
+	VoltageSwellL3Count       float64    `json:"voltage_swell_l3_count"`
+	AnyPowerFailCount         float64    `json:"any_power_fail_count"`
+	LongPowerFailCount        float64    `json:"long_power_fail_count"`
+	ActivePowerAverageW       float64    `json:"active_power_average_w"`
+	MonthlyPowerPeakW         float64    `json:"montly_power_peak_w"`
+	MonthlyPowerPeakTimestamp float64    `json:"montly_power_peak_timestamp"`
+	TotalGasM3                float64    `json:"total_gas_m3"`
+	GasTimestamp              float64    `json:"gas_timestamp"`
+	GasUniqueID               string     `json:"gas_unique_id"`
+	External                  []External `json:"external"`
+}
+
+// External is an M-Bus device (gas, water, heat) attached to the meter
+type External struct {
+	UniqueID  string          `json:"unique_id"`
+	Type      string          `json:"type"`
+	Timestamp json.RawMessage `json:"timestamp"`
+	Value     float64         `json:"value"`
+	Unit      string          `json:"unit"`
+}
+
+// DecodeDataV1 decodes a v1 payload. A field of the wrong type is left at
+// its zero value; use ValidateDataV1 to find out which fields those are.
+func DecodeDataV1(data []byte) (DataV1, error) {
+	var d DataV1
+	err := json.Unmarshal(data, &d)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		err = nil
+	}
+	return d, err
+}
+
+// Reading maps the payload onto a Reading, including the device metadata
+// and the external array
+func (d DataV1) Reading() models.Reading {
+	r := models.Reading{
+		MeterID:               d.UniqueID,
+		ActiveTariff:          int(d.ActiveTariff),
+		TotalPowerImportKwh:   d.TotalPowerImportKwh,
+		TotalPowerImportT1Kwh: d.TotalPowerImportT1Kwh,
+		TotalPowerImportT2Kwh: d.TotalPowerImportT2Kwh,
+		TotalPowerExportKwh:   d.TotalPowerExportKwh,
+		TotalPowerExportT1Kwh: d.TotalPowerExportT1Kwh,
+		TotalPowerExportT2Kwh: d.TotalPowerExportT2Kwh,
+		ActivePowerW:          d.ActivePowerW,
+		ActivePowerL1W:        d.ActivePowerL1W,
+		ActivePowerL2W:        d.ActivePowerL2W,
+		ActivePowerL3W:        d.ActivePowerL3W,
+		ActiveVoltageL1V:      d.ActiveVoltageL1V,
+		ActiveVoltageL2V:      d.ActiveVoltageL2V,
+		ActiveVoltageL3V:      d.ActiveVoltageL3V,
+		ActiveCurrentA:        d.ActiveCurrentA,
+		ActiveCurrentL1A:      d.ActiveCurrentL1A,
+		ActiveCurrentL2A:      d.ActiveCurrentL2A,
+		ActiveCurrentL3A:      d.ActiveCurrentL3A,
+		VoltageSagL1Count:     int(d.VoltageSagL1Count),
+		VoltageSagL2Count:     int(d.VoltageSagL2Count),
+		VoltageSagL3Count:     int(d.VoltageSagL3Count),
+		VoltageSwellL1Count:   int(d.VoltageSwellL1Count),
+		VoltageSwellL2Count:   int(d.VoltageSwellL2Count),
+		VoltageSwellL3Count:   int(d.VoltageSwellL3Count),
+		AnyPowerFailCount:     int(d.AnyPowerFailCount),
+		LongPowerFailCount:    int(d.LongPowerFailCount),
+		TotalGasM3:            d.TotalGasM3,
+		GasTimestamp:          int64(d.GasTimestamp),
+		GasMeasuredAt:         meterTime(int64(d.GasTimestamp)),
+		External:              externalReadings(d.External),
+
+		ActivePowerAverageW:       d.ActivePowerAverageW,
+		MonthlyPowerPeakW:         d.MonthlyPowerPeakW,
+		MonthlyPowerPeakTimestamp: int64(d.MonthlyPowerPeakTimestamp),
+		MonthlyPowerPeakAt:        meterTime(int64(d.MonthlyPowerPeakTimestamp)),
+	}
+	if d.UniqueID != "" {
+		r.Device = &models.Device{
+			UniqueID:     d.UniqueID,
+			Model:        d.MeterModel,
+			SMRVersion:   int(d.SMRVersion),
+			WifiSSID:     d.WifiSSID,
+			WifiStrength: int(d.WifiStrength),
+		}
+	}
+	return r
+}
+
+// meterTime decodes a YYMMDDhhmmss timestamp such as gas_timestamp,
+// leaving the time zero for a missing or malformed one
+func meterTime(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	t, err := dsmr.DecodeTimestamp(n)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// externalReadings converts the external array of a v1 or v2 payload
+func externalReadings(ext []External) []models.ExternalReading {
+	if len(ext) == 0 {
+		return nil
+	}
+	out := make([]models.ExternalReading, len(ext))
+	for i, e := range ext {
+		out[i] = models.ExternalReading{
+			UniqueID:  e.UniqueID,
+			Type:      e.Type,
+			Timestamp: externalTimestamp(e.Timestamp),
+			Value:     e.Value,
+			Unit:      e.Unit,
+		}
+	}
+	return out
+}
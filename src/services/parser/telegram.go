@@ -0,0 +1,465 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/models"
+	"github.com/harrybawsac/p1-go/src/services/dsmr"
+)
+
+// ErrMalformedTelegram is returned for input that is not a complete DSMR
+// telegram (missing "/" header or "!" trailer, or an unreadable CRC).
+var ErrMalformedTelegram = errors.New("malformed dsmr telegram")
+
+// ChecksumError is returned when the CRC16 in the telegram trailer does not
+// match the telegram contents.
+type ChecksumError struct {
+	Expected uint16 // CRC from the "!XXXX" trailer
+	Actual   uint16 // CRC computed over "/" through "!"
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("dsmr telegram checksum mismatch: trailer %04X, computed %04X", e.Expected, e.Actual)
+}
+
+// gasDeviceType is the M-Bus device type (0-n:24.1.0) of a gas meter
+const gasDeviceType = 3
+
+// mbusDeviceTypes maps M-Bus device types (0-n:24.1.0) onto the external
+// types of the HomeWizard API. Channels of other types are stored as
+// "unknown" and classified by their unit.
+var mbusDeviceTypes = map[int]string{
+	gasDeviceType: "gas_meter",
+	4:             "heat_meter",
+	6:             "warm_water_meter",
+	7:             "water_meter",
+	12:            "inlet_heat_meter",
+}
+
+// telegramState collects values while walking the telegram lines. Some Reading
+// fields are derived from several OBIS codes (net power, totals), so raw
+// values are kept here until the whole telegram has been seen.
+type telegramState struct {
+	r models.Reading
+
+	powerDeliveredKw, powerReturnedKw float64
+	phaseDeliveredKw, phaseReturnedKw [3]float64
+	haveTotalImport, haveTotalExport  bool
+	haveCurrentTotal                  bool
+	deviceTypes                       map[string]int // M-Bus channel -> device type
+	equipmentIDs                      map[string]string
+	mbusByChannel                     map[string]mbusValue
+	legacyGasPending                  bool // DSMR 2.2: value follows on next line
+	legacyGasTimestamp                int64
+	legacyGasMeasuredAt               time.Time
+	legacyGasUnit                     string
+	// header is the meter identification after "/", smrVersion is 1-3:0.2.8
+	header     string
+	smrVersion int
+
+	// obis is the code of the line being handled, err the first value that
+	// could not be parsed
+	obis string
+	err  error
+}
+
+// mbusValue is the last value read from an M-Bus channel
+type mbusValue struct {
+	value      float64
+	unit       string
+	timestamp  int64
+	measuredAt time.Time
+}
+
+// obisHandler applies the value groups of one COSEM line to the state
+type obisHandler func(s *telegramState, values []string)
+
+// obisTable maps OBIS references (without the M-Bus channel for 0-n codes)
+// onto Reading fields. Codes not listed here are ignored.
+var obisTable = map[string]obisHandler{
+	"0-0:96.1.1":  func(s *telegramState, v []string) { s.r.MeterID = v[len(v)-1] },
+	"0-0:96.1.0":  func(s *telegramState, v []string) { s.r.MeterID = v[len(v)-1] }, // DSMR 2.2 / Belgian meters
+	"1-3:0.2.8":   func(s *telegramState, v []string) { s.smrVersion = int(s.float(v)) },
+	"0-0:96.14.0": func(s *telegramState, v []string) { s.r.ActiveTariff = int(s.float(v)) },
+
+	"1-0:1.8.0": func(s *telegramState, v []string) { s.r.TotalPowerImportKwh = s.float(v); s.haveTotalImport = true },
+	"1-0:1.8.1": func(s *telegramState, v []string) { s.r.TotalPowerImportT1Kwh = s.float(v) },
+	"1-0:1.8.2": func(s *telegramState, v []string) { s.r.TotalPowerImportT2Kwh = s.float(v) },
+	"1-0:2.8.0": func(s *telegramState, v []string) { s.r.TotalPowerExportKwh = s.float(v); s.haveTotalExport = true },
+	"1-0:2.8.1": func(s *telegramState, v []string) { s.r.TotalPowerExportT1Kwh = s.float(v) },
+	"1-0:2.8.2": func(s *telegramState, v []string) { s.r.TotalPowerExportT2Kwh = s.float(v) },
+
+	"1-0:1.7.0":  func(s *telegramState, v []string) { s.powerDeliveredKw = s.float(v) },
+	"1-0:2.7.0":  func(s *telegramState, v []string) { s.powerReturnedKw = s.float(v) },
+	"1-0:21.7.0": func(s *telegramState, v []string) { s.phaseDeliveredKw[0] = s.float(v) },
+	"1-0:41.7.0": func(s *telegramState, v []string) { s.phaseDeliveredKw[1] = s.float(v) },
+	"1-0:61.7.0": func(s *telegramState, v []string) { s.phaseDeliveredKw[2] = s.float(v) },
+	"1-0:22.7.0": func(s *telegramState, v []string) { s.phaseReturnedKw[0] = s.float(v) },
+	"1-0:42.7.0": func(s *telegramState, v []string) { s.phaseReturnedKw[1] = s.float(v) },
+	"1-0:62.7.0": func(s *telegramState, v []string) { s.phaseReturnedKw[2] = s.float(v) },
+
+	"1-0:32.7.0": func(s *telegramState, v []string) { s.r.ActiveVoltageL1V = s.float(v) },
+	"1-0:52.7.0": func(s *telegramState, v []string) { s.r.ActiveVoltageL2V = s.float(v) },
+	"1-0:72.7.0": func(s *telegramState, v []string) { s.r.ActiveVoltageL3V = s.float(v) },
+
+	"1-0:31.7.0": func(s *telegramState, v []string) { s.r.ActiveCurrentL1A = s.float(v) },
+	"1-0:51.7.0": func(s *telegramState, v []string) { s.r.ActiveCurrentL2A = s.float(v) },
+	"1-0:71.7.0": func(s *telegramState, v []string) { s.r.ActiveCurrentL3A = s.float(v) },
+	"1-0:90.7.0": func(s *telegramState, v []string) { s.r.ActiveCurrentA = s.float(v); s.haveCurrentTotal = true },
+
+	"1-0:32.32.0": func(s *telegramState, v []string) { s.r.VoltageSagL1Count = int(s.float(v)) },
+	"1-0:52.32.0": func(s *telegramState, v []string) { s.r.VoltageSagL2Count = int(s.float(v)) },
+	"1-0:72.32.0": func(s *telegramState, v []string) { s.r.VoltageSagL3Count = int(s.float(v)) },
+	"1-0:32.36.0": func(s *telegramState, v []string) { s.r.VoltageSwellL1Count = int(s.float(v)) },
+	"1-0:52.36.0": func(s *telegramState, v []string) { s.r.VoltageSwellL2Count = int(s.float(v)) },
+	"1-0:72.36.0": func(s *telegramState, v []string) { s.r.VoltageSwellL3Count = int(s.float(v)) },
+
+	// Belgian capacity tariff: current quarter-hour average demand and the
+	// month's peak as (timestamp)(kW)
+	"1-0:1.4.0": func(s *telegramState, v []string) { s.r.ActivePowerAverageW = math.Round(s.float(v) * 1000) },
+	"1-0:1.6.0": func(s *telegramState, v []string) {
+		if len(v) < 2 {
+			return
+		}
+		s.r.MonthlyPowerPeakW = math.Round(s.float(v) * 1000)
+		s.r.MonthlyPowerPeakTimestamp = cosemTimestamp(v[0])
+		s.r.MonthlyPowerPeakAt = cosemTime(v[0])
+	},
+
+	"0-0:96.7.21": func(s *telegramState, v []string) { s.r.AnyPowerFailCount = int(s.float(v)) },
+	"0-0:96.7.9":  func(s *telegramState, v []string) { s.r.LongPowerFailCount = int(s.float(v)) },
+}
+
+// mbusTable handles the per-channel 0-n:24.x codes. The channel ("1".."4") is
+// passed separately because the same code repeats for every M-Bus device.
+var mbusTable = map[string]func(s *telegramState, channel string, values []string){
+	// device type
+	"24.1.0": func(s *telegramState, ch string, v []string) { s.deviceTypes[ch] = int(s.float(v)) },
+	// equipment identifier, the unique_id of the sub-meter
+	"96.1.0": func(s *telegramState, ch string, v []string) { s.equipmentIDs[ch] = v[len(v)-1] },
+	// DSMR 4.x/5.0 hourly/5-minute value: (timestamp)(value*unit)
+	"24.2.1": func(s *telegramState, ch string, v []string) {
+		if len(v) < 2 {
+			return
+		}
+		last := v[len(v)-1]
+		s.mbusByChannel[ch] = mbusValue{value: s.parseFloat(last), unit: cosemUnit(last), timestamp: cosemTimestamp(v[0]), measuredAt: cosemTime(v[0])}
+	},
+	// DSMR 2.2 gas: (timestamp)(..)(..)(..)(obis)(unit) with the value on the next line
+	"24.3.0": func(s *telegramState, ch string, v []string) {
+		if len(v) == 0 {
+			return
+		}
+		s.legacyGasPending = true
+		s.legacyGasTimestamp = cosemTimestamp(v[0])
+		s.legacyGasMeasuredAt = cosemTime(v[0])
+		s.legacyGasUnit = v[len(v)-1]
+		if _, ok := s.deviceTypes[ch]; !ok {
+			s.deviceTypes[ch] = gasDeviceType
+		}
+	},
+}
+
+// ParseTelegram parses a DSMR 2.2, 4.x or 5.0 P1 telegram ("/" header through
+// "!" trailer), verifies the CRC16 (see VerifyTelegramCRC), and maps the OBIS
+// codes onto a Reading. Corrupted input, including a value that is not a
+// number, returns ErrMalformedTelegram or a *ChecksumError rather than a
+// partially filled Reading.
+func ParseTelegram(data []byte) (models.Reading, error) {
+	if err := VerifyTelegramCRC(data); err != nil {
+		return models.Reading{}, err
+	}
+
+	s := &telegramState{
+		deviceTypes:   map[string]int{},
+		equipmentIDs:  map[string]string{},
+		mbusByChannel: map[string]mbusValue{},
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "/") && s.header == "" {
+			s.header = line[1:]
+			continue
+		}
+		if line == "" || line[0] == '/' || line[0] == '!' {
+			continue
+		}
+		// DSMR 2.2 continuation line carrying the gas value
+		if s.legacyGasPending && line[0] == '(' {
+			s.legacyGasPending = false
+			s.obis = "0-" + s.gasChannel() + ":24.3.0"
+			s.mbusByChannel[s.gasChannel()] = mbusValue{value: s.float(cosemGroups(line)), unit: s.legacyGasUnit, timestamp: s.legacyGasTimestamp, measuredAt: s.legacyGasMeasuredAt}
+			continue
+		}
+		obis, values := splitCOSEM(line)
+		if obis == "" || len(values) == 0 {
+			continue
+		}
+		s.obis = obis
+		if h, ok := obisTable[obis]; ok {
+			h(s, values)
+			continue
+		}
+		if ch, code, ok := splitMBus(obis); ok {
+			if h, ok := mbusTable[code]; ok {
+				h(s, ch, values)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return models.Reading{}, err
+	}
+	if s.err != nil {
+		return models.Reading{}, s.err
+	}
+
+	return s.reading(), nil
+}
+
+// reading derives the aggregate fields and returns the final Reading
+func (s *telegramState) reading() models.Reading {
+	r := s.r
+	if !s.haveTotalImport {
+		r.TotalPowerImportKwh = r.TotalPowerImportT1Kwh + r.TotalPowerImportT2Kwh
+	}
+	if !s.haveTotalExport {
+		r.TotalPowerExportKwh = r.TotalPowerExportT1Kwh + r.TotalPowerExportT2Kwh
+	}
+	r.ActivePowerW = netWatts(s.powerDeliveredKw, s.powerReturnedKw)
+	r.ActivePowerL1W = netWatts(s.phaseDeliveredKw[0], s.phaseReturnedKw[0])
+	r.ActivePowerL2W = netWatts(s.phaseDeliveredKw[1], s.phaseReturnedKw[1])
+	r.ActivePowerL3W = netWatts(s.phaseDeliveredKw[2], s.phaseReturnedKw[2])
+	if !s.haveCurrentTotal {
+		r.ActiveCurrentA = r.ActiveCurrentL1A + r.ActiveCurrentL2A + r.ActiveCurrentL3A
+	}
+	if g, ok := s.mbusByChannel[s.gasChannel()]; ok {
+		r.TotalGasM3 = g.value
+		r.GasTimestamp = g.timestamp
+		r.GasMeasuredAt = g.measuredAt
+	}
+	r.External = s.external()
+	if r.MeterID != "" {
+		r.Device = &models.Device{UniqueID: r.MeterID, Model: s.header, SMRVersion: s.smrVersion}
+	}
+	return r
+}
+
+// netWatts converts delivered minus returned kW to W, rounded to the meter's
+// 1 W resolution so float noise does not leak into the stored value
+func netWatts(deliveredKw, returnedKw float64) float64 {
+	return math.Round((deliveredKw - returnedKw) * 1000)
+}
+
+// gasChannel picks the M-Bus channel of the gas meter, the lowest one when
+// there are several. Telegrams without device type lines (some DSMR 4.0
+// meters) put gas on channel 1.
+func (s *telegramState) gasChannel() string {
+	var gas []string
+	for ch, typ := range s.deviceTypes {
+		if typ == gasDeviceType {
+			gas = append(gas, ch)
+		}
+	}
+	if len(gas) == 0 {
+		return "1"
+	}
+	sort.Strings(gas)
+	return gas[0]
+}
+
+// external returns every M-Bus channel with a value as an external reading,
+// like the external array of the JSON API, ordered by channel
+func (s *telegramState) external() []models.ExternalReading {
+	if len(s.mbusByChannel) == 0 {
+		return nil
+	}
+	channels := make([]string, 0, len(s.mbusByChannel))
+	for ch := range s.mbusByChannel {
+		channels = append(channels, ch)
+	}
+	sort.Strings(channels)
+
+	gas := s.gasChannel()
+	out := make([]models.ExternalReading, 0, len(channels))
+	for _, ch := range channels {
+		typ, ok := s.deviceTypes[ch]
+		if !ok && ch == gas {
+			typ = gasDeviceType
+		}
+		name, ok := mbusDeviceTypes[typ]
+		if !ok {
+			name = "unknown"
+		}
+		v := s.mbusByChannel[ch]
+		out = append(out, models.ExternalReading{
+			UniqueID:  s.equipmentIDs[ch],
+			Type:      name,
+			Timestamp: v.timestamp,
+			Value:     v.value,
+			Unit:      v.unit,
+		})
+	}
+	return out
+}
+
+// VerifyTelegramCRC checks the "!XXXX" trailer of a telegram against a
+// CRC16/ARC computed over everything from "/" through "!". DSMR 2.2 telegrams
+// end in a bare "!" and are accepted without a checksum; they are recognised
+// by the missing version line (1-3:0.2.8), which every later version sends
+// along with the CRC.
+func VerifyTelegramCRC(data []byte) error {
+	if len(data) == 0 || data[0] != '/' {
+		return fmt.Errorf("%w: missing '/' header", ErrMalformedTelegram)
+	}
+	end := bytes.LastIndexByte(data, '!')
+	if end < 0 {
+		return fmt.Errorf("%w: missing '!' trailer", ErrMalformedTelegram)
+	}
+	trailer := strings.TrimSpace(string(data[end+1:]))
+	if trailer == "" {
+		if bytes.Contains(data[:end], []byte("\n1-3:0.2.8(")) {
+			return fmt.Errorf("%w: missing crc", ErrMalformedTelegram)
+		}
+		return nil
+	}
+	if len(trailer) != 4 {
+		return fmt.Errorf("%w: invalid crc %q", ErrMalformedTelegram, trailer)
+	}
+	want, err := strconv.ParseUint(trailer, 16, 16)
+	if err != nil {
+		return fmt.Errorf("%w: invalid crc %q", ErrMalformedTelegram, trailer)
+	}
+	got := crc16(data[:end+1])
+	if uint16(want) != got {
+		return &ChecksumError{Expected: uint16(want), Actual: got}
+	}
+	return nil
+}
+
+// crc16 computes CRC16/ARC (polynomial 0xA001 reflected, initial value 0) as
+// specified for DSMR 4.0 and later.
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// splitCOSEM splits a line like "1-0:1.8.1(001234.567*kWh)" into its OBIS
+// reference and the contents of each parenthesised group.
+func splitCOSEM(line string) (string, []string) {
+	i := strings.IndexByte(line, '(')
+	if i <= 0 {
+		return "", nil
+	}
+	return line[:i], cosemGroups(line[i:])
+}
+
+// cosemGroups returns the contents of each leading "(...)" group in rest
+func cosemGroups(rest string) []string {
+	var values []string
+	for len(rest) > 0 && rest[0] == '(' {
+		end := strings.IndexByte(rest, ')')
+		if end < 0 {
+			break
+		}
+		values = append(values, rest[1:end])
+		rest = rest[end+1:]
+	}
+	return values
+}
+
+// splitMBus splits "0-1:24.2.1" into channel "1" and code "24.2.1". Besides
+// the 24.x codes it accepts 96.1.0, the equipment identifier of a channel.
+func splitMBus(obis string) (string, string, bool) {
+	if !strings.HasPrefix(obis, "0-") {
+		return "", "", false
+	}
+	ch, code, ok := strings.Cut(obis[2:], ":")
+	if !ok || ch == "0" || !(strings.HasPrefix(code, "24.") || code == "96.1.0") {
+		return "", "", false
+	}
+	return ch, code, true
+}
+
+// float parses the last value group, which holds the measurement for all
+// single-value codes
+func (s *telegramState) float(values []string) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	return s.parseFloat(values[len(values)-1])
+}
+
+// parseFloat parses v with cosemFloat, keeping the first error for
+// ParseTelegram to return
+func (s *telegramState) parseFloat(v string) float64 {
+	f, err := cosemFloat(v)
+	if err != nil && s.err == nil {
+		s.err = fmt.Errorf("%w: %s: %v", ErrMalformedTelegram, s.obis, err)
+	}
+	return f
+}
+
+// cosemFloat parses the numeric part of a value such as "001234.567*kWh"; an
+// empty value reads as 0
+func cosemFloat(v string) (float64, error) {
+	if i := strings.IndexByte(v, '*'); i >= 0 {
+		v = v[:i]
+	}
+	if v == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("invalid value %q", v)
+	}
+	return f, nil
+}
+
+// cosemUnit returns the unit of a value such as "03571.732*m3"
+func cosemUnit(v string) string {
+	if i := strings.IndexByte(v, '*'); i >= 0 {
+		return v[i+1:]
+	}
+	return ""
+}
+
+// cosemTime decodes a DSMR timestamp "YYMMDDhhmmssX" honouring its DST flag,
+// zero when it is malformed
+func cosemTime(v string) time.Time {
+	t, err := dsmr.ParseTimestamp(v)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// cosemTimestamp converts a DSMR timestamp "YYMMDDhhmmssX" (X = S/W DST flag)
+// to the YYMMDDhhmmss integer form used by the meter JSON API.
+func cosemTimestamp(v string) int64 {
+	v = strings.TrimRight(v, "SW")
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
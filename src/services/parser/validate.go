@@ -0,0 +1,321 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/harrybawsac/p1-go/src/models"
+)
+
+// IssueKind classifies a problem found in a meter payload
+type IssueKind string
+
+const (
+	IssueMissing    IssueKind = "missing"
+	IssueWrongType  IssueKind = "wrong_type"
+	IssueUnknown    IssueKind = "unknown_field"
+	IssueOutOfRange IssueKind = "out_of_range"
+)
+
+// Issue is one problem with one field of a payload
+type Issue struct {
+	Field  string
+	Kind   IssueKind
+	Detail string
+}
+
+func (i Issue) String() string {
+	if i.Detail == "" {
+		return fmt.Sprintf("%s: %s", i.Field, i.Kind)
+	}
+	return fmt.Sprintf("%s: %s (%s)", i.Field, i.Kind, i.Detail)
+}
+
+// ValidationReport lists the issues found in a payload, ordered by field.
+// Unknown fields are reported but do not make a payload invalid, so a
+// firmware update adding fields does not stop collection.
+type ValidationReport struct {
+	Issues []Issue
+}
+
+// Valid reports whether the payload has no issues other than unknown fields
+func (r ValidationReport) Valid() bool {
+	for _, i := range r.Issues {
+		if i.Kind != IssueUnknown {
+			return false
+		}
+	}
+	return true
+}
+
+func (r ValidationReport) String() string {
+	if len(r.Issues) == 0 {
+		return "no issues"
+	}
+	out := make([]string, len(r.Issues))
+	for n, i := range r.Issues {
+		out[n] = i.String()
+	}
+	return strings.Join(out, "; ")
+}
+
+// InvalidPolicy decides what happens to a reading whose payload failed
+// validation
+type InvalidPolicy string
+
+const (
+	// InvalidWarn stores the reading and logs the report (default)
+	InvalidWarn InvalidPolicy = "warn"
+	// InvalidReject drops the reading and fails the run
+	InvalidReject InvalidPolicy = "reject"
+	// InvalidReview moves the raw payload to the buffer's dead-letter file
+	// for review and fails the run
+	InvalidReview InvalidPolicy = "review"
+)
+
+// ParseInvalidPolicy validates a policy name from the CLI
+func ParseInvalidPolicy(s string) (InvalidPolicy, error) {
+	switch p := InvalidPolicy(s); p {
+	case InvalidWarn, InvalidReject, InvalidReview:
+		return p, nil
+	case "":
+		return InvalidWarn, nil
+	default:
+		return "", fmt.Errorf("invalid payload policy %q (want warn, reject or review)", s)
+	}
+}
+
+// ValidationError is returned by ParseFullReadingStrict for an invalid payload
+type ValidationError struct {
+	Report ValidationReport
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid meter payload: " + e.Report.String()
+}
+
+// valueKind is the JSON type a field must have
+type valueKind int
+
+const (
+	kindNumber valueKind = iota
+	kindInteger
+	kindString
+	kindArray
+)
+
+func (k valueKind) String() string {
+	return [...]string{"number", "integer", "string", "array"}[k]
+}
+
+// jsonType is the JSON type holding values of kind k
+func (k valueKind) jsonType() string {
+	if k == kindInteger {
+		return "number"
+	}
+	return k.String()
+}
+
+// fieldSpec describes one known field of a v1 payload
+type fieldSpec struct {
+	kind     valueKind
+	required bool
+	// min and max bound numeric values when set
+	min, max *float64
+	unit     string
+}
+
+func bound(v float64) *float64 { return &v }
+
+// dataV1Fields are the fields of DataV1. Per-phase fields are optional since
+// single-phase meters leave them out.
+var dataV1Fields = map[string]fieldSpec{
+	"wifi_ssid":                   {kind: kindString},
+	"wifi_strength":               {kind: kindNumber, min: bound(0), max: bound(100), unit: "%"},
+	"smr_version":                 {kind: kindInteger},
+	"meter_model":                 {kind: kindString},
+	"unique_id":                   {kind: kindString},
+	"active_tariff":               {kind: kindInteger, min: bound(1), max: bound(4)},
+	"total_power_import_kwh":      {kind: kindNumber, required: true, min: bound(0), unit: "kWh"},
+	"total_power_import_t1_kwh":   {kind: kindNumber, min: bound(0), unit: "kWh"},
+	"total_power_import_t2_kwh":   {kind: kindNumber, min: bound(0), unit: "kWh"},
+	"total_power_export_kwh":      {kind: kindNumber, required: true, min: bound(0), unit: "kWh"},
+	"total_power_export_t1_kwh":   {kind: kindNumber, min: bound(0), unit: "kWh"},
+	"total_power_export_t2_kwh":   {kind: kindNumber, min: bound(0), unit: "kWh"},
+	"active_power_w":              {kind: kindNumber, required: true},
+	"active_power_l1_w":           {kind: kindNumber},
+	"active_power_l2_w":           {kind: kindNumber},
+	"active_power_l3_w":           {kind: kindNumber},
+	"active_voltage_l1_v":         {kind: kindNumber, min: bound(180), max: bound(270), unit: "V"},
+	"active_voltage_l2_v":         {kind: kindNumber, min: bound(180), max: bound(270), unit: "V"},
+	"active_voltage_l3_v":         {kind: kindNumber, min: bound(180), max: bound(270), unit: "V"},
+	"active_current_a":            {kind: kindNumber},
+	"active_current_l1_a":         {kind: kindNumber},
+	"active_current_l2_a":         {kind: kindNumber},
+	"active_current_l3_a":         {kind: kindNumber},
+	"voltage_sag_l1_count":        {kind: kindInteger, min: bound(0)},
+	"voltage_sag_l2_count":        {kind: kindInteger, min: bound(0)},
+	"voltage_sag_l3_count":        {kind: kindInteger, min: bound(0)},
+	"voltage_swell_l1_count":      {kind: kindInteger, min: bound(0)},
+	"voltage_swell_l2_count":      {kind: kindInteger, min: bound(0)},
+	"voltage_swell_l3_count":      {kind: kindInteger, min: bound(0)},
+	"any_power_fail_count":        {kind: kindInteger, min: bound(0)},
+	"long_power_fail_count":       {kind: kindInteger, min: bound(0)},
+	"active_power_average_w":      {kind: kindNumber},
+	"montly_power_peak_w":         {kind: kindNumber, min: bound(0), unit: "W"},
+	"montly_power_peak_timestamp": {kind: kindInteger},
+	"total_gas_m3":                {kind: kindNumber, min: bound(0), unit: "m3"},
+	"gas_timestamp":               {kind: kindInteger},
+	"gas_unique_id":               {kind: kindString},
+	"external":                    {kind: kindArray},
+}
+
+// externalFields are the fields of an entry of the external array
+var externalFields = map[string]fieldSpec{
+	"unique_id": {kind: kindString},
+	"type":      {kind: kindString, required: true},
+	"timestamp": {kind: kindInteger},
+	"value":     {kind: kindNumber, required: true, min: bound(0)},
+	"unit":      {kind: kindString},
+}
+
+// ValidateDataV1 checks a v1 payload field by field: required fields that are
+// missing, values of the wrong JSON type, fields it does not know and values
+// out of range, such as negative counters or a voltage outside 180-270 V.
+// Only malformed JSON is returned as an error.
+func ValidateDataV1(data []byte) (ValidationReport, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return ValidationReport{}, err
+	}
+	var report ValidationReport
+	validateObject(&report, "", raw, dataV1Fields)
+
+	if ext, ok := raw["external"]; ok && jsonKind(ext) == "array" {
+		var entries []json.RawMessage
+		json.Unmarshal(ext, &entries)
+		for n, entry := range entries {
+			prefix := fmt.Sprintf("external[%d].", n)
+			var obj map[string]json.RawMessage
+			if err := json.Unmarshal(entry, &obj); err != nil {
+				report.add(strings.TrimSuffix(prefix, "."), IssueWrongType, "want object, got "+jsonKind(entry))
+				continue
+			}
+			validateObject(&report, prefix, obj, externalFields)
+		}
+	}
+	sort.SliceStable(report.Issues, func(i, j int) bool { return report.Issues[i].Field < report.Issues[j].Field })
+	return report, nil
+}
+
+// ParseFullReadingStrict parses a v1 payload like ParseFullReading and also
+// validates it. For an invalid payload the error is a *ValidationError; the
+// Reading is still returned, with the offending fields left at zero, so a
+// caller can decide to keep it.
+func ParseFullReadingStrict(data []byte) (models.Reading, ValidationReport, error) {
+	report, err := ValidateDataV1(data)
+	if err != nil {
+		return models.Reading{}, report, err
+	}
+	d, err := DecodeDataV1(data)
+	if err != nil {
+		return models.Reading{}, report, err
+	}
+	if !report.Valid() {
+		return d.Reading(), report, &ValidationError{Report: report}
+	}
+	return d.Reading(), report, nil
+}
+
+func validateObject(report *ValidationReport, prefix string, obj map[string]json.RawMessage, fields map[string]fieldSpec) {
+	for key, value := range obj {
+		spec, ok := fields[key]
+		if !ok {
+			report.add(prefix+key, IssueUnknown, "")
+			continue
+		}
+		spec.check(report, prefix+key, value)
+	}
+	for key, spec := range fields {
+		if value, ok := obj[key]; spec.required && (!ok || jsonKind(value) == "null") {
+			report.add(prefix+key, IssueMissing, "")
+		}
+	}
+}
+
+// check reports a value of the wrong type or out of range; null is left to
+// the required check
+func (s fieldSpec) check(report *ValidationReport, field string, value json.RawMessage) {
+	got := jsonKind(value)
+	if got == "null" {
+		return
+	}
+	want := s.kind.jsonType()
+	if got != want {
+		report.add(field, IssueWrongType, fmt.Sprintf("want %s, got %s", s.kind, got))
+		return
+	}
+	if want != "number" {
+		return
+	}
+	var v float64
+	if err := json.Unmarshal(value, &v); err != nil {
+		report.add(field, IssueWrongType, fmt.Sprintf("want %s, got %s", s.kind, value))
+		return
+	}
+	if s.kind == kindInteger && v != math.Trunc(v) {
+		report.add(field, IssueWrongType, fmt.Sprintf("want integer, got %v", v))
+		return
+	}
+	if (s.min != nil && v < *s.min) || (s.max != nil && v > *s.max) {
+		report.add(field, IssueOutOfRange, fmt.Sprintf("%v%s outside %s", v, s.unitSuffix(), s.rangeString()))
+	}
+}
+
+func (s fieldSpec) unitSuffix() string {
+	if s.unit == "" || s.unit == "%" {
+		return s.unit
+	}
+	return " " + s.unit
+}
+
+func (s fieldSpec) rangeString() string {
+	switch {
+	case s.min != nil && s.max != nil:
+		return fmt.Sprintf("%v-%v%s", *s.min, *s.max, s.unitSuffix())
+	case s.min != nil:
+		return fmt.Sprintf(">= %v", *s.min)
+	default:
+		return fmt.Sprintf("<= %v", *s.max)
+	}
+}
+
+func (r *ValidationReport) add(field string, kind IssueKind, detail string) {
+	r.Issues = append(r.Issues, Issue{Field: field, Kind: kind, Detail: detail})
+}
+
+// jsonKind names the JSON type of a raw value
+func jsonKind(v json.RawMessage) string {
+	v = bytes.TrimSpace(v)
+	if len(v) == 0 {
+		return "null"
+	}
+	switch v[0] {
+	case '"':
+		return "string"
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case 't', 'f':
+		return "boolean"
+	case 'n':
+		return "null"
+	default:
+		return "number"
+	}
+}
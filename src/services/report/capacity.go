@@ -0,0 +1,192 @@
+package report
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/models"
+)
+
+// QuarterHour is the demand period of the Belgian capacity tariff
+const QuarterHour = 15 * time.Minute
+
+// DefaultMinimumKW is the lowest monthly peak billed by the capacity tariff
+const DefaultMinimumKW = 2.5
+
+// QuarterHourDemand computes the average import demand per quarter-hour
+// from the import counters of readings, oldest first, like the
+// quarter_hour_demand view, for stores that cannot aggregate it (see
+// db.DemandStore): the counter at the end of each quarter-hour against the
+// counter at its start, taking the first reading at or after each boundary.
+// A reading exactly on a boundary so ends the quarter before it. Quarters
+// without a reading in the quarter-hour that follows, or whose counter went
+// down, are left out.
+func QuarterHourDemand(readings []models.Reading) []models.Demand {
+	type boundary struct {
+		start time.Time
+		kwh   float64
+	}
+	last := map[string]boundary{}
+	var out []models.Demand
+	for _, r := range readings {
+		v := importKwh(r)
+		if v <= 0 {
+			continue
+		}
+		start := r.CreatedAt.Truncate(QuarterHour)
+		prev, ok := last[r.MeterID]
+		if ok && !start.After(prev.start) {
+			// not the first reading of its quarter-hour
+			continue
+		}
+		last[r.MeterID] = boundary{start: start, kwh: v}
+		if ok && start.Sub(prev.start) == QuarterHour && v >= prev.kwh {
+			out = append(out, models.Demand{MeterID: r.MeterID, QuarterStart: prev.start, AverageW: (v - prev.kwh) * 4000})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].QuarterStart.Before(out[j].QuarterStart) })
+	return out
+}
+
+// PowerPeaks lists the distinct monthly peaks the meters report in readings,
+// oldest first, for stores that cannot aggregate them
+func PowerPeaks(readings []models.Reading) []models.PowerPeak {
+	type key struct {
+		meter string
+		at    time.Time
+	}
+	index := map[key]int{}
+	var out []models.PowerPeak
+	for _, r := range readings {
+		if r.MonthlyPowerPeakW <= 0 || r.MonthlyPowerPeakAt.IsZero() {
+			continue
+		}
+		k := key{r.MeterID, r.MonthlyPowerPeakAt}
+		if i, ok := index[k]; ok {
+			out[i].PeakW = math.Max(out[i].PeakW, r.MonthlyPowerPeakW)
+			continue
+		}
+		index[k] = len(out)
+		out = append(out, models.PowerPeak{MeterID: r.MeterID, At: r.MonthlyPowerPeakAt, PeakW: r.MonthlyPowerPeakW})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].At.Before(out[j].At) })
+	return out
+}
+
+// MonthlyPeak is the highest quarter-hour demand of a meter in a month
+type MonthlyPeak struct {
+	MeterID string
+	Month   time.Time
+	// PeakW and PeakAt are computed from the import counters
+	PeakW  float64
+	PeakAt time.Time
+	// MeterPeakW and MeterPeakAt are reported by Belgian meters (1-0:1.6.0)
+	MeterPeakW  float64
+	MeterPeakAt time.Time
+}
+
+// Peak returns the meter's own peak when it reports one, and the computed
+// peak otherwise
+func (p MonthlyPeak) Peak() (float64, time.Time) {
+	if p.MeterPeakW > 0 {
+		return p.MeterPeakW, p.MeterPeakAt
+	}
+	return p.PeakW, p.PeakAt
+}
+
+// MonthlyPeaks finds the monthly peak per meter from the computed demand and
+// the peaks reported by the meters, with months starting in loc. A reported
+// peak counts for the month it occurred in.
+func MonthlyPeaks(demand []models.Demand, reported []models.PowerPeak, loc *time.Location) []MonthlyPeak {
+	type key struct {
+		meter string
+		month time.Time
+	}
+	peaks := map[key]*MonthlyPeak{}
+	get := func(meter string, at time.Time) *MonthlyPeak {
+		k := key{meter, Month.Start(at.In(loc))}
+		p := peaks[k]
+		if p == nil {
+			p = &MonthlyPeak{MeterID: meter, Month: k.month}
+			peaks[k] = p
+		}
+		return p
+	}
+
+	for _, d := range demand {
+		if p := get(d.MeterID, d.QuarterStart); d.AverageW > p.PeakW {
+			p.PeakW, p.PeakAt = d.AverageW, d.QuarterStart
+		}
+	}
+	for _, r := range reported {
+		if p := get(r.MeterID, r.At); r.PeakW > p.MeterPeakW {
+			p.MeterPeakW, p.MeterPeakAt = r.PeakW, r.At
+		}
+	}
+
+	out := make([]MonthlyPeak, 0, len(peaks))
+	for _, p := range peaks {
+		out = append(out, *p)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].Month.Equal(out[j].Month) {
+			return out[i].Month.Before(out[j].Month)
+		}
+		return out[i].MeterID < out[j].MeterID
+	})
+	return out
+}
+
+// CapacityCost is the capacity tariff billed for one meter and month
+type CapacityCost struct {
+	MonthlyPeak
+	// BilledKW is the monthly peak, raised to the minimum; for the month in
+	// progress, the peak so far
+	BilledKW float64
+	// InProgress marks the month that has not ended yet
+	InProgress bool
+	// ProjectedKW is the peak the month is expected to be billed at. For a
+	// month in progress it is the peak so far, or the average billed peak of
+	// the preceding months when that is higher, as the month's peak can only
+	// grow; for a complete month it is BilledKW.
+	ProjectedKW float64
+	// RollingKW is the average ProjectedKW over this and up to 11 preceding
+	// months with peaks, the basis of the tariff
+	RollingKW float64
+	Cost      float64
+}
+
+// CapacityCosts prices peaks, ordered by month, at ratePerKWYear (per kW
+// per year, billed a twelfth each month) with peaks below minimumKW billed
+// as minimumKW. The month holding now is projected (see ProjectedKW).
+func CapacityCosts(peaks []MonthlyPeak, ratePerKWYear, minimumKW float64, now time.Time) []CapacityCost {
+	history := map[string][]float64{}
+	out := make([]CapacityCost, 0, len(peaks))
+	for _, p := range peaks {
+		w, _ := p.Peak()
+		c := CapacityCost{MonthlyPeak: p, BilledKW: math.Max(w/1000, minimumKW)}
+		c.ProjectedKW = c.BilledKW
+		prev := history[p.MeterID]
+		if c.InProgress = !now.Before(p.Month) && now.Before(Month.Add(p.Month, 1)); c.InProgress && len(prev) > 0 {
+			c.ProjectedKW = math.Max(c.BilledKW, average(prev))
+		}
+		h := append(prev, c.ProjectedKW)
+		if len(h) > 12 {
+			h = h[len(h)-12:]
+		}
+		history[p.MeterID] = h
+		c.RollingKW = average(h)
+		c.Cost = c.RollingKW * ratePerKWYear / 12
+		out = append(out, c)
+	}
+	return out
+}
+
+func average(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
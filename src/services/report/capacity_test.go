@@ -0,0 +1,113 @@
+package report
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/models"
+)
+
+func TestQuarterHourDemand(t *testing.T) {
+	base := time.Date(2025, 10, 3, 8, 0, 0, 0, time.UTC)
+	reading := func(at time.Duration, kwh float64) models.Reading {
+		return models.Reading{CreatedAt: base.Add(at), TotalPowerImportKwh: kwh}
+	}
+	got := QuarterHourDemand([]models.Reading{
+		reading(5*time.Minute, 100),
+		reading(14*time.Minute, 100.25),
+		reading(20*time.Minute, 100.5),
+		reading(29*time.Minute, 101),
+		{CreatedAt: base.Add(30 * time.Minute)}, // no counters
+		reading(44*time.Minute, 101.5),
+		reading(74*time.Minute, 102), // after a missing quarter
+		reading(89*time.Minute, 102.25),
+	})
+	// each quarter runs from the first reading at or after its start to the
+	// first reading at or after its end
+	want := []models.Demand{
+		{QuarterStart: base, AverageW: 2000},
+		{QuarterStart: base.Add(15 * time.Minute), AverageW: 4000},
+		{QuarterStart: base.Add(60 * time.Minute), AverageW: 1000},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d quarters, got %+v", len(want), got)
+	}
+	for i := range want {
+		if !got[i].QuarterStart.Equal(want[i].QuarterStart) || math.Abs(got[i].AverageW-want[i].AverageW) > 1e-6 {
+			t.Errorf("quarter %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestMonthlyPeaksAndCosts(t *testing.T) {
+	loc := time.UTC
+	sep := time.Date(2025, 9, 30, 23, 30, 0, 0, loc)
+	meterPeak := time.Date(2025, 10, 1, 16, 30, 0, 0, loc)
+	// readings on the quarter-hour boundaries, as with --align --interval
+	// 900: the reading at midnight ends September's last quarter
+	readings := []models.Reading{
+		{CreatedAt: sep, TotalPowerImportKwh: 100},
+		{CreatedAt: sep.Add(15 * time.Minute), TotalPowerImportKwh: 100.25}, // 1 kW 23:30-23:45
+		{CreatedAt: sep.Add(30 * time.Minute), TotalPowerImportKwh: 100.75}, // 2 kW 23:45-24:00
+		{CreatedAt: sep.Add(45 * time.Minute), TotalPowerImportKwh: 101, // 1 kW on October 1st
+			MonthlyPowerPeakW: 4321, MonthlyPowerPeakAt: meterPeak},
+		// in the first minutes of November the meter still reports
+		// October's peak
+		{CreatedAt: time.Date(2025, 11, 1, 0, 1, 0, 0, loc), TotalPowerImportKwh: 200,
+			MonthlyPowerPeakW: 5000, MonthlyPowerPeakAt: time.Date(2025, 10, 20, 18, 0, 0, 0, loc)},
+	}
+	reported := PowerPeaks(readings)
+	if len(reported) != 2 || reported[0].PeakW != 4321 || reported[1].PeakW != 5000 {
+		t.Fatalf("unexpected reported peaks %+v", reported)
+	}
+	peaks := MonthlyPeaks(QuarterHourDemand(readings), reported, loc)
+	if len(peaks) != 2 {
+		t.Fatalf("expected 2 months, got %+v", peaks)
+	}
+	if peaks[0].Month.Month() != time.September || peaks[0].PeakW != 2000 || peaks[0].MeterPeakW != 0 {
+		t.Errorf("unexpected September peak %+v", peaks[0])
+	}
+	if w, at := peaks[0].Peak(); w != 2000 || !at.Equal(sep.Add(15*time.Minute)) {
+		t.Errorf("September Peak() = %v at %v", w, at)
+	}
+	oct := peaks[1]
+	if oct.PeakW != 1000 || !oct.PeakAt.Equal(sep.Add(30*time.Minute)) || oct.MeterPeakW != 5000 {
+		t.Errorf("unexpected October peak %+v", oct)
+	}
+	if w, _ := oct.Peak(); w != 5000 {
+		t.Errorf("expected the meter's peak to be preferred, got %v", w)
+	}
+
+	costs := CapacityCosts(peaks, 48, DefaultMinimumKW, time.Date(2025, 11, 1, 0, 1, 0, 0, loc))
+	if costs[0].BilledKW != 2.5 || costs[0].RollingKW != 2.5 || costs[0].Cost != 10 {
+		t.Errorf("unexpected September cost %+v", costs[0])
+	}
+	if costs[1].BilledKW != 5 || costs[1].ProjectedKW != 5 || costs[1].RollingKW != 3.75 || costs[1].Cost != 15 || costs[1].InProgress {
+		t.Errorf("unexpected October cost %+v", costs[1])
+	}
+}
+
+func TestCapacityCostsProjectsCurrentMonth(t *testing.T) {
+	month := func(m time.Month) time.Time { return time.Date(2025, m, 1, 0, 0, 0, 0, time.UTC) }
+	peaks := []MonthlyPeak{
+		{MeterID: "a", Month: month(time.August), PeakW: 6000},
+		{MeterID: "a", Month: month(time.September), PeakW: 4000},
+		{MeterID: "a", Month: month(time.October), PeakW: 3000},
+		{MeterID: "b", Month: month(time.October), PeakW: 3000},
+	}
+	costs := CapacityCosts(peaks, 48, DefaultMinimumKW, time.Date(2025, 10, 10, 12, 0, 0, 0, time.UTC))
+	if costs[1].InProgress || costs[1].ProjectedKW != 4 {
+		t.Errorf("a complete month must not be projected: %+v", costs[1])
+	}
+	// the peak so far is below the average of the months before
+	a := costs[2]
+	if !a.InProgress || a.BilledKW != 3 || a.ProjectedKW != 5 || a.RollingKW != 5 || a.Cost != 20 {
+		t.Errorf("unexpected projection %+v", a)
+	}
+	// without history the peak so far is all there is
+	b := costs[3]
+	if !b.InProgress || b.ProjectedKW != 3 || b.RollingKW != 3 || b.Cost != 12 {
+		t.Errorf("unexpected projection without history %+v", b)
+	}
+}
@@ -0,0 +1,274 @@
+// Package report derives consumption from the cumulative meter counters
+// stored with each reading.
+package report
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/models"
+)
+
+// Interval is the length of a report period
+type Interval string
+
+const (
+	Day   Interval = "day"
+	Month Interval = "month"
+)
+
+// ParseInterval validates an interval name from the CLI
+func ParseInterval(s string) (Interval, error) {
+	switch i := Interval(s); i {
+	case Day, Month:
+		return i, nil
+	default:
+		return "", fmt.Errorf("invalid interval %q (want day or month)", s)
+	}
+}
+
+// Start returns the start of the period holding t, in t's location
+func (i Interval) Start(t time.Time) time.Time {
+	if i == Month {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// Add moves t by n periods
+func (i Interval) Add(t time.Time, n int) time.Time {
+	if i == Month {
+		return t.AddDate(0, n, 0)
+	}
+	return t.AddDate(0, 0, n)
+}
+
+// SubMeterKinds are the sub-meter series reported next to electricity and
+// gas, in column order
+var SubMeterKinds = []string{models.KindWater, models.KindWarmWater, models.KindHeat}
+
+// Period is the consumption in one report period
+type Period struct {
+	Start     time.Time
+	ImportKwh float64
+	ExportKwh float64
+	GasM3     float64
+	// SubMeters holds the consumption of water, warm water and heat by kind,
+	// summed over the sub-meters of that kind, in Units[kind]
+	SubMeters map[string]float64
+}
+
+// Usage is a consumption report
+type Usage struct {
+	Periods []Period
+	// Units holds the unit of each sub-meter kind seen; kinds without
+	// sub-meters are absent
+	Units map[string]string
+}
+
+// Summarize computes the consumption per period from readings, oldest
+// first. Consumption between two readings counts for the period of the
+// later one, or for gas of the gas meter's measurement time when the reading
+// has it; readings before from only serve as the starting point. Each
+// meter's counters are followed separately and summed per period. A counter
+// that went down (meter replaced) starts over without counting.
+// Periods without any consumption data are left out.
+func Summarize(readings []models.Reading, from time.Time, interval Interval, loc *time.Location) Usage {
+	u := Usage{Units: map[string]string{}}
+	periods := map[time.Time]*Period{}
+	last := map[string]float64{}
+
+	add := func(series string, at time.Time, value float64, apply func(p *Period, delta float64)) {
+		prev, ok := last[series]
+		last[series] = value
+		if !ok || value < prev || at.Before(from) {
+			return
+		}
+		start := interval.Start(at.In(loc))
+		p := periods[start]
+		if p == nil {
+			p = &Period{Start: start, SubMeters: map[string]float64{}}
+			periods[start] = p
+		}
+		apply(p, value-prev)
+	}
+
+	for _, r := range readings {
+		at := r.CreatedAt
+		if v := importKwh(r); v > 0 {
+			add(r.MeterID+"/import", at, v, func(p *Period, d float64) { p.ImportKwh += d })
+		}
+		if v := exportKwh(r); v > 0 {
+			add(r.MeterID+"/export", at, v, func(p *Period, d float64) { p.ExportKwh += d })
+		}
+		if v := gasM3(r); v > 0 {
+			gasAt := at
+			if !r.GasMeasuredAt.IsZero() {
+				gasAt = r.GasMeasuredAt
+			}
+			add(r.MeterID+"/gas", gasAt, v, func(p *Period, d float64) { p.GasM3 += d })
+		}
+		for _, e := range r.External {
+			kind := e.Kind()
+			if kind == "" || kind == models.KindGas {
+				continue
+			}
+			value, unit := e.Value, e.Unit
+			if unit == "MJ" {
+				value, unit = value/1000, "GJ"
+			}
+			if _, ok := u.Units[kind]; !ok {
+				u.Units[kind] = unit
+			}
+			add(r.MeterID+"/"+kind+"/"+e.UniqueID, at, value, func(p *Period, d float64) { p.SubMeters[kind] += d })
+		}
+	}
+
+	for _, p := range periods {
+		u.Periods = append(u.Periods, *p)
+	}
+	sort.Slice(u.Periods, func(i, j int) bool { return u.Periods[i].Start.Before(u.Periods[j].Start) })
+	return u
+}
+
+// SummarizeCounters computes the consumption per period like Summarize, from
+// the first and last counter of each series per period as a UsageStore
+// returns them. Consumption between the last counter of one period and the
+// first of the next counts for the later period; periods starting before
+// from only serve as the starting point. A counter that went down starts
+// over without counting; within a period that loses what was used after
+// the reset, which is fine for replaced meters.
+func SummarizeCounters(counters []models.PeriodCounter, from time.Time, interval Interval, loc *time.Location) Usage {
+	u := Usage{Units: map[string]string{}}
+	periods := map[time.Time]*Period{}
+	last := map[string]float64{}
+
+	sorted := append([]models.PeriodCounter(nil), counters...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].PeriodStart.Before(sorted[j].PeriodStart) })
+	for _, c := range sorted {
+		first, end, unit := c.First, c.Last, c.Unit
+		if unit == "MJ" {
+			first, end, unit = first/1000, end/1000, "GJ"
+		}
+		key := c.MeterID + "/" + c.Series
+		var apply func(p *Period, delta float64)
+		switch c.Series {
+		case "import":
+			apply = func(p *Period, d float64) { p.ImportKwh += d }
+		case "export":
+			apply = func(p *Period, d float64) { p.ExportKwh += d }
+		case models.KindGas:
+			apply = func(p *Period, d float64) { p.GasM3 += d }
+		default:
+			kind := c.Series
+			if _, ok := u.Units[kind]; !ok {
+				u.Units[kind] = unit
+			}
+			key += "/" + c.UniqueID
+			apply = func(p *Period, d float64) { p.SubMeters[kind] += d }
+		}
+
+		var delta float64
+		prev, ok := last[key]
+		if ok && first >= prev {
+			delta += first - prev
+		}
+		if end >= first {
+			delta += end - first
+		}
+		last[key] = end
+		if c.PeriodStart.Before(from) || (!ok && end == first) {
+			continue
+		}
+		start := interval.Start(c.PeriodStart.In(loc))
+		p := periods[start]
+		if p == nil {
+			p = &Period{Start: start, SubMeters: map[string]float64{}}
+			periods[start] = p
+		}
+		apply(p, delta)
+	}
+
+	for _, p := range periods {
+		u.Periods = append(u.Periods, *p)
+	}
+	sort.Slice(u.Periods, func(i, j int) bool { return u.Periods[i].Start.Before(u.Periods[j].Start) })
+	return u
+}
+
+// importKwh is the import counter; CSV imports only carry the tariff counters
+func importKwh(r models.Reading) float64 {
+	if r.TotalPowerImportKwh > 0 {
+		return r.TotalPowerImportKwh
+	}
+	return r.TotalPowerImportT1Kwh + r.TotalPowerImportT2Kwh
+}
+
+func exportKwh(r models.Reading) float64 {
+	if r.TotalPowerExportKwh > 0 {
+		return r.TotalPowerExportKwh
+	}
+	return r.TotalPowerExportT1Kwh + r.TotalPowerExportT2Kwh
+}
+
+// gasM3 is the gas counter, taken from the gas sub-meter when the reading
+// has no top-level gas value
+func gasM3(r models.Reading) float64 {
+	if r.TotalGasM3 > 0 {
+		return r.TotalGasM3
+	}
+	for _, e := range r.External {
+		if e.Kind() == models.KindGas {
+			return e.Value
+		}
+	}
+	return 0
+}
+
+// GasInterval is the gas used between two updates of a gas meter
+type GasInterval struct {
+	MeterID    string
+	Start, End time.Time
+	ConsumedM3 float64
+}
+
+// FlowM3h is the average flow over the interval in m3 per hour
+func (g GasInterval) FlowM3h() float64 {
+	hours := g.End.Sub(g.Start).Hours()
+	if hours <= 0 {
+		return 0
+	}
+	return g.ConsumedM3 / hours
+}
+
+// GasIntervals derives the gas use per gas meter update from readings,
+// oldest first. A gas meter updates every 5 minutes (DSMR 5) or hourly
+// (DSMR 4) while the meter is read more often, so readings repeating a
+// measurement are skipped. Readings without GasMeasuredAt are ignored, as
+// is a counter that went down.
+func GasIntervals(readings []models.Reading) []GasInterval {
+	type measurement struct {
+		at    time.Time
+		value float64
+	}
+	last := map[string]measurement{}
+	var out []GasInterval
+	for _, r := range readings {
+		v := gasM3(r)
+		if r.GasMeasuredAt.IsZero() || v <= 0 {
+			continue
+		}
+		prev, ok := last[r.MeterID]
+		if ok && !r.GasMeasuredAt.After(prev.at) {
+			continue
+		}
+		last[r.MeterID] = measurement{at: r.GasMeasuredAt, value: v}
+		if !ok || v < prev.value {
+			continue
+		}
+		out = append(out, GasInterval{MeterID: r.MeterID, Start: prev.at, End: r.GasMeasuredAt, ConsumedM3: v - prev.value})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].End.Before(out[j].End) })
+	return out
+}
@@ -0,0 +1,177 @@
+package report
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/harrybawsac/p1-go/src/models"
+)
+
+func TestSummarize_Daily(t *testing.T) {
+	loc := time.UTC
+	base := time.Date(2025, 10, 2, 23, 0, 0, 0, loc)
+	reading := func(at time.Time, imp, gas, water, heat float64) models.Reading {
+		return models.Reading{
+			CreatedAt:           at,
+			TotalPowerImportKwh: imp,
+			TotalPowerExportKwh: 100,
+			TotalGasM3:          gas,
+			External: []models.ExternalReading{
+				{UniqueID: "W1", Type: "water_meter", Value: water, Unit: "m3"},
+				{UniqueID: "H1", Type: "unknown", Value: heat, Unit: "MJ"},
+			},
+		}
+	}
+	readings := []models.Reading{
+		reading(base, 1000, 50, 10, 31000),                      // before from: baseline only
+		reading(base.Add(2*time.Hour), 1002, 50.5, 10.2, 31500), // Oct 3
+		reading(base.Add(3*time.Hour), 1003, 51, 10.3, 32000),   // Oct 3
+		reading(base.Add(26*time.Hour), 1010, 52, 0.1, 33000),   // Oct 4, water meter replaced
+		reading(base.Add(27*time.Hour), 1011, 52, 0.4, 33000),   // Oct 4
+	}
+
+	u := Summarize(readings, base.Add(time.Hour), Day, loc)
+	if len(u.Periods) != 2 {
+		t.Fatalf("expected 2 periods, got %+v", u.Periods)
+	}
+	approx := func(name string, got, want float64) {
+		t.Helper()
+		if math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+	oct3, oct4 := u.Periods[0], u.Periods[1]
+	if !oct3.Start.Equal(time.Date(2025, 10, 3, 0, 0, 0, 0, loc)) {
+		t.Errorf("unexpected first period %v", oct3.Start)
+	}
+	approx("oct 3 import", oct3.ImportKwh, 3)
+	approx("oct 3 export", oct3.ExportKwh, 0)
+	approx("oct 3 gas", oct3.GasM3, 1)
+	approx("oct 3 water", oct3.SubMeters[models.KindWater], 0.3)
+	approx("oct 3 heat", oct3.SubMeters[models.KindHeat], 1)
+	approx("oct 4 import", oct4.ImportKwh, 8)
+	approx("oct 4 water", oct4.SubMeters[models.KindWater], 0.3)
+	approx("oct 4 heat", oct4.SubMeters[models.KindHeat], 1)
+	if u.Units[models.KindHeat] != "GJ" || u.Units[models.KindWater] != "m3" {
+		t.Errorf("unexpected units %v", u.Units)
+	}
+	if _, ok := u.Units[models.KindWarmWater]; ok {
+		t.Errorf("warm water reported without a warm water meter")
+	}
+}
+
+func TestSummarize_MonthlyTariffCounters(t *testing.T) {
+	loc := time.UTC
+	readings := []models.Reading{
+		{CreatedAt: time.Date(2025, 9, 30, 23, 45, 0, 0, loc), TotalPowerImportT1Kwh: 10, TotalPowerImportT2Kwh: 20},
+		{CreatedAt: time.Date(2025, 10, 15, 12, 0, 0, 0, loc), TotalPowerImportT1Kwh: 15, TotalPowerImportT2Kwh: 25},
+		{CreatedAt: time.Date(2025, 11, 1, 0, 15, 0, 0, loc), TotalPowerImportT1Kwh: 16, TotalPowerImportT2Kwh: 25},
+	}
+	u := Summarize(readings, time.Date(2025, 10, 1, 0, 0, 0, 0, loc), Month, loc)
+	if len(u.Periods) != 2 || u.Periods[0].ImportKwh != 10 || u.Periods[1].ImportKwh != 1 {
+		t.Errorf("unexpected periods %+v", u.Periods)
+	}
+}
+
+func TestSummarizeCounters(t *testing.T) {
+	loc := time.UTC
+	oct2 := time.Date(2025, 10, 2, 0, 0, 0, 0, loc)
+	oct3, oct4 := oct2.AddDate(0, 0, 1), oct2.AddDate(0, 0, 2)
+	counter := func(start time.Time, series, id, unit string, first, last float64) models.PeriodCounter {
+		return models.PeriodCounter{PeriodStart: start, MeterID: "E1", Series: series, UniqueID: id, Unit: unit, First: first, Last: last}
+	}
+	// the readings of TestSummarize_Daily, as a UsageStore returns them
+	counters := []models.PeriodCounter{
+		counter(oct2, "import", "", "", 1000, 1000),
+		counter(oct2, "export", "", "", 100, 100),
+		counter(oct2, models.KindGas, "", "", 50, 50),
+		counter(oct2, models.KindWater, "W1", "m3", 10, 10),
+		counter(oct2, models.KindHeat, "H1", "MJ", 31000, 31000),
+		counter(oct3, "import", "", "", 1002, 1003),
+		counter(oct3, "export", "", "", 100, 100),
+		counter(oct3, models.KindGas, "", "", 50.5, 51),
+		counter(oct3, models.KindWater, "W1", "m3", 10.2, 10.3),
+		counter(oct3, models.KindHeat, "H1", "MJ", 31500, 32000),
+		counter(oct4, "import", "", "", 1010, 1011),
+		counter(oct4, "export", "", "", 100, 100),
+		counter(oct4, models.KindGas, "", "", 52, 52),
+		counter(oct4, models.KindWater, "W1", "m3", 0.1, 0.4),
+		counter(oct4, models.KindHeat, "H1", "MJ", 33000, 33000),
+	}
+
+	u := SummarizeCounters(counters, oct3, Day, loc)
+	if len(u.Periods) != 2 {
+		t.Fatalf("expected 2 periods, got %+v", u.Periods)
+	}
+	approx := func(name string, got, want float64) {
+		t.Helper()
+		if math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+	p3, p4 := u.Periods[0], u.Periods[1]
+	if !p3.Start.Equal(oct3) || !p4.Start.Equal(oct4) {
+		t.Errorf("unexpected periods %v and %v", p3.Start, p4.Start)
+	}
+	approx("oct 3 import", p3.ImportKwh, 3)
+	approx("oct 3 export", p3.ExportKwh, 0)
+	approx("oct 3 gas", p3.GasM3, 1)
+	approx("oct 3 water", p3.SubMeters[models.KindWater], 0.3)
+	approx("oct 3 heat", p3.SubMeters[models.KindHeat], 1)
+	approx("oct 4 import", p4.ImportKwh, 8)
+	approx("oct 4 gas", p4.GasM3, 1)
+	approx("oct 4 water", p4.SubMeters[models.KindWater], 0.3)
+	approx("oct 4 heat", p4.SubMeters[models.KindHeat], 1)
+	if u.Units[models.KindHeat] != "GJ" || u.Units[models.KindWater] != "m3" {
+		t.Errorf("unexpected units %v", u.Units)
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+	if i, err := ParseInterval("month"); err != nil || i != Month {
+		t.Errorf("ParseInterval(month) = %q, %v", i, err)
+	}
+	if _, err := ParseInterval("week"); err == nil {
+		t.Error("expected an error for week")
+	}
+}
+
+func TestGasIntervals(t *testing.T) {
+	base := time.Date(2025, 10, 3, 8, 0, 0, 0, time.UTC)
+	reading := func(polled, measured time.Duration, gas float64) models.Reading {
+		return models.Reading{CreatedAt: base.Add(polled), GasMeasuredAt: base.Add(measured), TotalGasM3: gas}
+	}
+	readings := []models.Reading{
+		reading(1*time.Minute, 0, 100),
+		reading(2*time.Minute, 0, 100), // same measurement polled again
+		reading(6*time.Minute, 5*time.Minute, 100.25),
+		reading(7*time.Minute, 5*time.Minute, 100.25),
+		reading(11*time.Minute, 10*time.Minute, 100.25),
+		{CreatedAt: base.Add(12 * time.Minute)}, // no gas meter value
+		reading(16*time.Minute, 15*time.Minute, 100.5),
+	}
+	got := GasIntervals(readings)
+	if len(got) != 3 {
+		t.Fatalf("expected 3 intervals, got %+v", got)
+	}
+	if !got[0].Start.Equal(base) || !got[0].End.Equal(base.Add(5*time.Minute)) || got[0].ConsumedM3 != 0.25 {
+		t.Errorf("unexpected first interval %+v", got[0])
+	}
+	if got[0].FlowM3h() != 3 {
+		t.Errorf("expected 3 m3/h, got %v", got[0].FlowM3h())
+	}
+	if got[1].ConsumedM3 != 0 || got[2].ConsumedM3 != 0.25 {
+		t.Errorf("unexpected intervals %+v", got)
+	}
+
+	// gas counts for the day of the measurement, not of the poll
+	late := []models.Reading{
+		{CreatedAt: base, GasMeasuredAt: base, TotalGasM3: 100},
+		{CreatedAt: time.Date(2025, 10, 4, 0, 2, 0, 0, time.UTC), GasMeasuredAt: time.Date(2025, 10, 3, 23, 55, 0, 0, time.UTC), TotalGasM3: 101},
+	}
+	u := Summarize(late, base, Day, time.UTC)
+	if len(u.Periods) != 1 || u.Periods[0].Start.Day() != 3 || u.Periods[0].GasM3 != 1 {
+		t.Errorf("unexpected periods %+v", u.Periods)
+	}
+}
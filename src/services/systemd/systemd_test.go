@@ -0,0 +1,136 @@
+package systemd
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listen opens a unixgram socket standing in for systemd's notify socket
+func listen(t *testing.T) (*net.UnixConn, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, path
+}
+
+func receive(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotifier_SendsDatagrams(t *testing.T) {
+	conn, path := listen(t)
+	t.Setenv("NOTIFY_SOCKET", path)
+	n := NewNotifier()
+	if n == nil {
+		t.Fatal("expected a notifier with NOTIFY_SOCKET set")
+	}
+
+	steps := []struct {
+		send func() error
+		want string
+	}{
+		{func() error { return n.Ready("polling every 1m0s") }, "READY=1\nSTATUS=polling every 1m0s"},
+		{func() error { return n.Ready("reloaded:\nSTOPPING=1") }, "READY=1\nSTATUS=reloaded: STOPPING=1"},
+		{func() error { return n.Status("last poll failed:\nboom") }, "STATUS=last poll failed: boom"},
+		{n.Watchdog, "WATCHDOG=1"},
+		{n.Reloading, "RELOADING=1"},
+		{n.Stopping, "STOPPING=1"},
+	}
+	for _, s := range steps {
+		if err := s.send(); err != nil {
+			t.Fatalf("send %q: %v", s.want, err)
+		}
+		if got := receive(t, conn); got != s.want {
+			t.Errorf("got %q, want %q", got, s.want)
+		}
+	}
+}
+
+func TestNotifier_NoSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	n := NewNotifier()
+	if n != nil {
+		t.Fatalf("expected nil notifier, got %+v", n)
+	}
+	if err := n.Ready(""); err != nil {
+		t.Errorf("nil notifier: %v", err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", "")
+	if got := WatchdogInterval(); got != 30*time.Second {
+		t.Errorf("got %s, want 30s", got)
+	}
+	// meant for another process
+	t.Setenv("WATCHDOG_PID", "1")
+	if got := WatchdogInterval(); got != 0 {
+		t.Errorf("got %s for another pid, want 0", got)
+	}
+	t.Setenv("WATCHDOG_USEC", "")
+	t.Setenv("WATCHDOG_PID", "")
+	if got := WatchdogInterval(); got != 0 {
+		t.Errorf("got %s without watchdog, want 0", got)
+	}
+}
+
+func TestUnit_WriteTo(t *testing.T) {
+	var b strings.Builder
+	u := Unit{
+		ExecStart:        []string{"/usr/local/bin/metercli", "--config", "/etc/p1/config.json", "--loop", "--schedule", "TZ=Europe/Amsterdam */15 * * * *"},
+		User:             "p1",
+		WorkingDirectory: "/var/lib/p1",
+		Watchdog:         3 * time.Minute,
+		StopTimeout:      35 * time.Second,
+	}
+	if _, err := u.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	got := b.String()
+	for _, want := range []string{
+		"Type=notify\n",
+		`ExecStart=/usr/local/bin/metercli --config /etc/p1/config.json --loop --schedule "TZ=Europe/Amsterdam */15 * * * *"` + "\n",
+		"ExecReload=/bin/kill -HUP $MAINPID\n",
+		"User=p1\n",
+		"WorkingDirectory=/var/lib/p1\n",
+		"WatchdogSec=180\n",
+		"TimeoutStopSec=35\n",
+		"RestartPreventExitStatus=2 6\n",
+		"WantedBy=multi-user.target\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("unit lacks %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestQuoteArg(t *testing.T) {
+	cases := map[string]string{
+		"--loop":       "--loop",
+		"100%":         "100%%",
+		"$HOME":        "$$HOME",
+		`a "b"`:        `"a \"b\""`,
+		"":             `""`,
+		`C:\path with`: `"C:\\path with"`,
+	}
+	for in, want := range cases {
+		if got := quoteArg(in); got != want {
+			t.Errorf("quoteArg(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package systemd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Unit describes a Type=notify service running the collector
+type Unit struct {
+	Description string
+	// ExecStart is the binary followed by its arguments
+	ExecStart []string
+	// User runs the service as this account when set
+	User             string
+	WorkingDirectory string
+	// Watchdog restarts the service when no WATCHDOG=1 ping arrives within
+	// this time; zero disables the watchdog
+	Watchdog time.Duration
+	// StopTimeout is how long systemd waits after SIGTERM before SIGKILL
+	StopTimeout time.Duration
+}
+
+// WriteTo renders the unit file
+func (u Unit) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	desc := u.Description
+	if desc == "" {
+		desc = "P1 meter collector"
+	}
+	fmt.Fprintf(&b, "[Unit]\nDescription=%s\n", desc)
+	b.WriteString("Wants=network-online.target\nAfter=network-online.target\n\n")
+
+	b.WriteString("[Service]\nType=notify\nNotifyAccess=main\n")
+	quoted := make([]string, len(u.ExecStart))
+	for i, arg := range u.ExecStart {
+		quoted[i] = quoteArg(arg)
+	}
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(quoted, " "))
+	b.WriteString("ExecReload=/bin/kill -HUP $MAINPID\n")
+	if u.User != "" {
+		fmt.Fprintf(&b, "User=%s\n", u.User)
+	}
+	if u.WorkingDirectory != "" {
+		fmt.Fprintf(&b, "WorkingDirectory=%s\n", quoteArg(u.WorkingDirectory))
+	}
+	if u.Watchdog > 0 {
+		fmt.Fprintf(&b, "WatchdogSec=%s\n", seconds(u.Watchdog))
+	}
+	if u.StopTimeout > 0 {
+		fmt.Fprintf(&b, "TimeoutStopSec=%s\n", seconds(u.StopTimeout))
+	}
+	// exit codes 2 (usage) and 6 (not configured) will not fix themselves
+	b.WriteString("Restart=on-failure\nRestartSec=10\nRestartPreventExitStatus=2 6\n\n")
+
+	b.WriteString("[Install]\nWantedBy=multi-user.target\n")
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// seconds formats d the way systemd time spans are usually written
+func seconds(d time.Duration) string {
+	if d%time.Second != 0 {
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	}
+	return fmt.Sprintf("%d", int64(d/time.Second))
+}
+
+// quoteArg quotes a command-line word for systemd, escaping specifier and
+// variable expansion
+func quoteArg(s string) string {
+	s = strings.NewReplacer("%", "%%", "$", "$$").Replace(s)
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\;") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
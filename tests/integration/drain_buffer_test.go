@@ -3,10 +3,15 @@ package integration
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/harrybawsac/p1-go/src/app"
 	"github.com/harrybawsac/p1-go/src/buffer"
+	"github.com/harrybawsac/p1-go/src/models"
+	"github.com/harrybawsac/p1-go/src/services/db"
 )
 
 func TestBufferDrain_PersistsLines(t *testing.T) {
@@ -22,17 +27,113 @@ func TestBufferDrain_PersistsLines(t *testing.T) {
 	}
 	f.Close()
 
-	b := buffer.New(tmp)
+	// lines of the legacy single-file buffer are moved into the WAL
+	b := buffer.New(t.TempDir())
+	if n, err := b.ImportFile(tmp); err != nil || n != 1 {
+		t.Fatalf("import legacy buffer: %d entries, %v", n, err)
+	}
 	called := false
 	persist := func(ctx context.Context, raw json.RawMessage) error {
 		called = true
 		return nil
 	}
 
-	if err := b.Drain(context.Background(), persist); err != nil {
+	if _, err := b.Drain(context.Background(), persist); err != nil {
 		t.Fatalf("drain failed: %v", err)
 	}
 	if !called {
 		t.Fatalf("persist function was not called")
 	}
 }
+
+func TestDrainBuffer_RestoresCaptureTime(t *testing.T) {
+	b := buffer.New(t.TempDir())
+	captured := time.Date(2025, 6, 2, 3, 15, 0, 0, time.UTC)
+	payload := json.RawMessage(`{"active_tariff":2,"total_power_import_t1_kwh":8293.146}`)
+	if err := b.AppendEntry(buffer.Entry{CapturedAt: captured, Format: buffer.FormatHomeWizardV1, Payload: payload}); err != nil {
+		t.Fatalf("append entry: %v", err)
+	}
+	// legacy raw-only line
+	if err := b.Append(payload); err != nil {
+		t.Fatalf("append legacy: %v", err)
+	}
+
+	store := db.NewMemoryStore()
+	before := time.Now()
+	if _, err := app.DrainBuffer(context.Background(), store, b); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+
+	readings := store.Readings()
+	if len(readings) != 2 {
+		t.Fatalf("expected 2 readings, got %d", len(readings))
+	}
+	if !readings[0].CreatedAt.Equal(captured) {
+		t.Errorf("expected capture time %v, got %v", captured, readings[0].CreatedAt)
+	}
+	if readings[1].CreatedAt.Before(before) {
+		t.Errorf("expected legacy entry stamped at drain time, got %v", readings[1].CreatedAt)
+	}
+}
+
+func TestDrainBufferLimited_PacesInserts(t *testing.T) {
+	b := buffer.New(t.TempDir())
+	base := time.Date(2025, 6, 2, 3, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		e := buffer.Entry{CapturedAt: base.Add(time.Duration(i) * time.Minute), Format: buffer.FormatHomeWizardV1, Payload: json.RawMessage(`{"active_tariff":1}`)}
+		if err := b.AppendEntry(e); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	store := db.NewMemoryStore()
+	start := time.Now()
+	summary, err := app.DrainBufferLimited(context.Background(), store, b, 20)
+	if err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	if summary.Persisted != 4 {
+		t.Fatalf("expected 4 persisted, got %+v", summary)
+	}
+	// 4 entries at 20/s leave three 50ms gaps
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("expected rate limited drain to take >= 150ms, took %v", elapsed)
+	}
+}
+
+// cancellingStore cancels the drain while an insert is in flight, failing it
+// the way a driver reports a cancelled query
+type cancellingStore struct {
+	*db.MemoryStore
+	cancel context.CancelFunc
+}
+
+func (s cancellingStore) InsertReading(ctx context.Context, r models.Reading) error {
+	s.cancel()
+	return fmt.Errorf("insert reading: %w", context.Canceled)
+}
+
+func TestDrainBuffer_CancelledInsertIsNotCharged(t *testing.T) {
+	b := buffer.New(t.TempDir())
+	e := buffer.Entry{CapturedAt: time.Date(2025, 6, 2, 3, 0, 0, 0, time.UTC), Format: buffer.FormatHomeWizardV1, Payload: json.RawMessage(`{"active_tariff":1}`)}
+	if err := b.AppendEntry(e); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := app.DrainBuffer(ctx, cancellingStore{db.NewMemoryStore(), cancel}, b); err == nil {
+		t.Fatalf("expected the cancelled drain to fail")
+	}
+
+	var attempts []int
+	if err := b.Walk(func(_ string, e buffer.Entry, err error) error {
+		attempts = append(attempts, e.Attempts)
+		return err
+	}); err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+	if len(attempts) != 1 || attempts[0] != 0 {
+		t.Errorf("expected the entry kept without an attempt, got attempts %v", attempts)
+	}
+}
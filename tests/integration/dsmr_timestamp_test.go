@@ -0,0 +1,51 @@
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"github.com/harrybawsac/p1-go/src/services/dsmr"
+	"github.com/harrybawsac/p1-go/src/services/migrate"
+)
+
+// TestIntegration_DSMRTimestampMatchesCollector checks that the backfill
+// function of migration 009 decodes timestamps to the same instant as the
+// collector, in particular the hour that occurs twice when the clocks go back
+func TestIntegration_DSMRTimestampMatchesCollector(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("skipping integration test: set TEST_DATABASE_DSN and start docker compose to run")
+	}
+	db, err := sql.Open("postgres", dsn)
+	must(t, err)
+	defer db.Close()
+	ctx := context.Background()
+	m, err := migrate.New(db)
+	must(t, err)
+	must(t, m.Up(ctx))
+
+	want := time.Date(2025, 10, 26, 0, 30, 0, 0, time.UTC) // 02:30 CEST
+	for _, ts := range []int64{251026023000, 251026033000, 250330023000, 251003101003, 250115120000} {
+		decoded, err := dsmr.DecodeTimestamp(ts)
+		must(t, err)
+		var got time.Time
+		must(t, db.QueryRowContext(ctx, `SELECT p1.dsmr_timestamp($1)`, ts).Scan(&got))
+		if !got.Equal(decoded) {
+			t.Errorf("p1.dsmr_timestamp(%d) = %v, dsmr.DecodeTimestamp = %v", ts, got.UTC(), decoded.UTC())
+		}
+		if ts == 251026023000 && !got.Equal(want) {
+			t.Errorf("p1.dsmr_timestamp(%d) = %v, want %v", ts, got.UTC(), want)
+		}
+	}
+
+	var malformed sql.NullTime
+	must(t, db.QueryRowContext(ctx, `SELECT p1.dsmr_timestamp(251303101003)`).Scan(&malformed))
+	if malformed.Valid {
+		t.Errorf("expected NULL for a malformed timestamp, got %v", malformed.Time)
+	}
+}
@@ -0,0 +1,58 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/harrybawsac/p1-go/src/app"
+	"github.com/harrybawsac/p1-go/src/buffer"
+	"github.com/harrybawsac/p1-go/src/services/db"
+	"github.com/harrybawsac/p1-go/src/services/parser"
+)
+
+// renamedImport mimics firmware that renamed total_power_import_kwh
+const renamedImport = `{"unique_id":"M1","total_power_import":16152.335,"total_power_export_kwh":7329.729,"active_power_w":321}`
+
+func TestRunOnceValidated_Policies(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(renamedImport))
+	}))
+	defer srv.Close()
+	t.Setenv("METER_ENDPOINT", srv.URL)
+
+	cases := []struct {
+		policy       parser.InvalidPolicy
+		wantErr      bool
+		wantReadings int
+		wantDead     int
+	}{
+		{parser.InvalidWarn, false, 1, 0},
+		{parser.InvalidReject, true, 0, 0},
+		{parser.InvalidReview, true, 0, 1},
+	}
+	for _, c := range cases {
+		t.Run(string(c.policy), func(t *testing.T) {
+			store := db.NewMemoryStore()
+			buf := buffer.New(t.TempDir())
+
+			err := app.RunOnceValidated(context.Background(), store, buf, c.policy, false)
+			var verr *parser.ValidationError
+			if c.wantErr != errors.As(err, &verr) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if n := len(store.Readings()); n != c.wantReadings {
+				t.Errorf("stored %d readings, want %d", n, c.wantReadings)
+			}
+			st, err := buf.Stats()
+			if err != nil {
+				t.Fatalf("stats: %v", err)
+			}
+			if st.Entries != 0 || st.DeadLettered != c.wantDead {
+				t.Errorf("unexpected buffer contents: %+v", st)
+			}
+		})
+	}
+}